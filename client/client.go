@@ -0,0 +1,232 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"security/common/discover"
+	"security/common/loadbalance"
+	"security/common/tracing"
+	"security/model"
+	"strings"
+	"time"
+)
+
+/**
+授权服务器的Go客户端
+通过DiscoveryClient和LoadBalance定位授权服务器实例，封装令牌申请、刷新、校验和内省请求
+授权服务器使用JWT令牌，签发后无法撤销，因此不提供RFC 7009的撤销请求
+*/
+
+var ErrNoAuthorizationServer = errors.New("no authorization server instance available")
+
+//授权服务器返回的OAuth2错误
+type Error struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth2: %s: %s", e.Code, e.Description)
+	}
+	return fmt.Sprintf("oauth2: %s (status %d)", e.Code, e.StatusCode)
+}
+
+//令牌响应
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	//根据ExpiresIn计算的过期时间，零值表示不过期
+	Expiry time.Time `json:"-"`
+}
+
+//令牌在delta时间内是否会过期
+func (t *Token) ExpiresWithin(delta time.Duration) bool {
+	return !t.Expiry.IsZero() && time.Now().Add(delta).After(t.Expiry)
+}
+
+//RFC 7662 introspection响应
+type Introspection struct {
+	Active      bool                `json:"active"`
+	Scope       string              `json:"scope"`
+	ClientId    string              `json:"client_id"`
+	Username    string              `json:"username"`
+	TokenType   string              `json:"token_type"`
+	Exp         int64               `json:"exp"`
+	Authorities []string            `json:"authorities"`
+	Amr         []string            `json:"amr"`
+	Cnf         *model.Confirmation `json:"cnf"`
+}
+
+//授权服务器各端点的路径
+type Endpoints struct {
+	Token      string
+	CheckToken string
+	Introspect string
+}
+
+var DefaultEndpoints = Endpoints{
+	Token:      "/oauth/token",
+	CheckToken: "/oauth/check_token",
+	Introspect: "/oauth/introspect",
+}
+
+type Client struct {
+	serviceName     string
+	clientId        string
+	clientSecret    string
+	endpoints       Endpoints
+	discoveryClient discover.DiscoveryClient
+	loadBalance     loadbalance.LoadBalance
+	httpClient      *http.Client
+}
+
+//httpClient为nil时使用http.DefaultClient
+//clientSecret为空时使用客户端证书认证（RFC 8705），证书在httpClient的TLS配置中提供
+func NewClient(serviceName, clientId, clientSecret string, endpoints Endpoints, discoveryClient discover.DiscoveryClient, loadBalance loadbalance.LoadBalance, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		serviceName:     serviceName,
+		clientId:        clientId,
+		clientSecret:    clientSecret,
+		endpoints:       endpoints,
+		discoveryClient: discoveryClient,
+		loadBalance:     loadBalance,
+		httpClient:      httpClient,
+	}
+}
+
+//密码类型
+func (c *Client) PasswordGrant(ctx context.Context, username, password, mfaCode string) (*Token, error) {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+	if mfaCode != "" {
+		form.Set("mfa_code", mfaCode)
+	}
+	return c.Grant(ctx, "password", form)
+}
+
+//令牌刷新
+func (c *Client) RefreshGrant(ctx context.Context, refreshToken string) (*Token, error) {
+	form := url.Values{}
+	form.Set("refresh_token", refreshToken)
+	return c.Grant(ctx, "refresh_token", form)
+}
+
+//客户端凭证类型，令牌不绑定用户，也没有刷新令牌
+func (c *Client) ClientCredentialsGrant(ctx context.Context) (*Token, error) {
+	return c.Grant(ctx, "client_credentials", url.Values{})
+}
+
+//使用指定的授权类型申请访问令牌
+func (c *Client) Grant(ctx context.Context, grantType string, form url.Values) (*Token, error) {
+	form.Set("grant_type", grantType)
+	token := &Token{}
+	if err := c.post(ctx, c.endpoints.Token, form, token); err != nil {
+		return nil, err
+	}
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+//使用/oauth/check_token校验访问令牌，返回令牌绑定的用户信息和客户端信息
+func (c *Client) CheckToken(ctx context.Context, accessToken string) (*model.OAuth2Details, error) {
+	form := url.Values{}
+	form.Set("token", accessToken)
+	var body struct {
+		OAuthDetails *model.OAuth2Details `json:"o_auth_details"`
+	}
+	if err := c.post(ctx, c.endpoints.CheckToken, form, &body); err != nil {
+		return nil, err
+	}
+	return body.OAuthDetails, nil
+}
+
+//RFC 7662 令牌内省，令牌无效时返回Active为false的结果而不是错误
+func (c *Client) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	introspection := &Introspection{}
+	if err := c.post(ctx, c.endpoints.Introspect, form, introspection); err != nil {
+		return nil, err
+	}
+	return introspection, nil
+}
+
+//选择一个授权服务器实例
+func (c *Client) selectInstance(ctx context.Context) (*discover.ServiceInstance, error) {
+	services, err := c.discoveryClient.DiscoverServices(ctx, c.serviceName)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, ErrNoAuthorizationServer
+	}
+	return c.loadBalance.SelectService(ctx, services)
+}
+
+//向选中的实例发送表单请求
+func (c *Client) send(ctx context.Context, instance *discover.ServiceInstance, path string, form url.Values) (*http.Response, error) {
+	endpoint := instance.Scheme() + "://" + instance.Address() + path
+	if c.clientSecret == "" {
+		//证书认证时在表单中携带client_id
+		form.Set("client_id", c.clientId)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.clientSecret != "" {
+		req.SetBasicAuth(c.clientId, c.clientSecret)
+	}
+	//传递trace context，授权服务器上的span与调用方关联
+	tracing.InjectHTTPHeader(ctx, req)
+	return c.httpClient.Do(req.WithContext(ctx))
+}
+
+//以表单格式向授权服务器发送请求，使用客户端秘钥进行Basic认证或使用客户端证书认证，响应的JSON写入result
+func (c *Client) post(ctx context.Context, path string, form url.Values, result interface{}) error {
+	instance, err := c.selectInstance(ctx)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	resp, err := c.send(ctx, instance, path, form)
+	//只有网络错误和5xx响应视为实例故障，OAuth2错误不影响负载均衡
+	failure := err
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		failure = errors.New(resp.Status)
+	}
+	c.loadBalance.Done(instance, time.Since(start), failure)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		oauth2Err := &Error{StatusCode: resp.StatusCode}
+		if json.NewDecoder(resp.Body).Decode(oauth2Err) != nil || oauth2Err.Code == "" {
+			oauth2Err.Code = http.StatusText(resp.StatusCode)
+		}
+		return oauth2Err
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
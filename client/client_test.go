@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"security/common/discover"
+	"security/common/loadbalance"
+	endpoint2 "security/endpoint"
+	"security/model"
+	"security/service"
+	"security/transport"
+	"strconv"
+	"testing"
+)
+
+//启动只提供令牌和内省端点的授权服务器，返回连接它的客户端
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+	enhancer := service.NewJWTTokenEnhancer("client-test-signing-key-0123456789").(*service.JWTTokenEnhancer)
+	tokenService := service.NewTokenService(service.NewJwtTokenStore(enhancer), enhancer)
+	clientDetailsService := service.NewInMemoryClientDetailService([]*model.ClientDetails{{
+		ClientId:                   "worker",
+		ClientSecret:               "secret",
+		AccessTokenValiditySeconds: 60,
+		AuthorizedGrantTypes:       []string{"client_credentials"},
+		Scope:                      []string{"read"},
+	}}, nil)
+	granter := service.NewComposeTokenGranter(map[string]service.TokenGrant{
+		"client_credentials": service.NewClientCredentialsGranter("client_credentials", tokenService),
+	})
+	clientAuthorization := endpoint2.MakeClientAuthorizationMiddleware(log.NewNopLogger())
+	handler := transport.MakeHttpHandler(context.Background(), endpoint2.OAuth2Endpoints{
+		TokenEndpoint:      clientAuthorization(endpoint2.MakeTokenEndpoint(granter, clientDetailsService)),
+		IntrospectEndpoint: clientAuthorization(endpoint2.MakeIntrospectEndpoint(tokenService)),
+	}, tokenService, clientDetailsService, nil, &endpoint2.EndpointMetrics{
+		RequestCount:   generic.NewCounter("request_count"),
+		RequestLatency: generic.NewHistogram("request_latency_seconds", 10),
+	}, transport.BearerTokenHeader, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(address.Port())
+	discoveryClient := discover.NewStaticDiscoveryClient([]*discover.ServiceInstance{{
+		ID: "oauth-1", Name: "oauth", Host: address.Hostname(), Port: port, Weight: 1, Healthy: true,
+	}})
+	return NewClient("oauth", "worker", "secret", DefaultEndpoints, discoveryClient, &loadbalance.RandomLoadBalance{}, server.Client())
+}
+
+func TestClientCredentialsGrantAndIntrospect(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	token, err := c.ClientCredentialsGrant(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//RFC 6749 4.4.3节，客户端凭证授权不签发刷新令牌
+	if token.AccessToken == "" || token.RefreshToken != "" || token.Expiry.IsZero() {
+		t.Fatalf("token = %+v, want an expiring access token without refresh token", token)
+	}
+
+	introspection, err := c.Introspect(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !introspection.Active || introspection.ClientId != "worker" || introspection.Scope != "read" || introspection.Username != "" || introspection.Exp == 0 {
+		t.Fatalf("introspection = %+v", introspection)
+	}
+
+	//无效的令牌不返回错误
+	introspection, err = c.Introspect(ctx, "not-a-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if introspection.Active || introspection.ClientId != "" {
+		t.Fatalf("introspection of invalid token = %+v, want inactive", introspection)
+	}
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	c := newTestClient(t)
+	source := NewClientCredentialsTokenSource(c)
+	first, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	//未过期时使用缓存的令牌
+	second, err := source.Token(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Fatal("token source requested a new token before expiry")
+	}
+}
+
+func TestClientAuthenticationError(t *testing.T) {
+	c := newTestClient(t)
+	c.clientSecret = "wrong"
+	_, err := c.ClientCredentialsGrant(context.Background())
+	oauth2Err, ok := err.(*Error)
+	if !ok || oauth2Err.StatusCode != http.StatusUnauthorized || oauth2Err.Code != "invalid_client" {
+		t.Fatalf("error = %v, want 401 invalid_client", err)
+	}
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//在令牌过期前多久刷新
+const DefaultExpiryDelta = 30 * time.Second
+
+//提供有效的访问令牌
+type TokenSource interface {
+	Token(ctx context.Context) (*Token, error)
+}
+
+/**
+缓存访问令牌，并在过期前自动刷新
+优先使用刷新令牌，刷新失败或没有刷新令牌时重新调用grant申请
+*/
+type RefreshingTokenSource struct {
+	client      *Client
+	grant       func(ctx context.Context) (*Token, error)
+	expiryDelta time.Duration
+	mutex       sync.Mutex
+	token       *Token
+}
+
+func NewRefreshingTokenSource(client *Client, grant func(ctx context.Context) (*Token, error), expiryDelta time.Duration) *RefreshingTokenSource {
+	return &RefreshingTokenSource{
+		client:      client,
+		grant:       grant,
+		expiryDelta: expiryDelta,
+	}
+}
+
+//使用用户名和密码申请令牌的TokenSource
+func NewPasswordTokenSource(client *Client, username, password string) *RefreshingTokenSource {
+	return NewRefreshingTokenSource(client, func(ctx context.Context) (*Token, error) {
+		return client.PasswordGrant(ctx, username, password, "")
+	}, DefaultExpiryDelta)
+}
+
+//使用客户端凭证申请令牌的TokenSource，令牌过期前重新申请
+func NewClientCredentialsTokenSource(client *Client) *RefreshingTokenSource {
+	return NewRefreshingTokenSource(client, func(ctx context.Context) (*Token, error) {
+		return client.ClientCredentialsGrant(ctx)
+	}, DefaultExpiryDelta)
+}
+
+func (s *RefreshingTokenSource) Token(ctx context.Context) (*Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.token != nil && !s.token.ExpiresWithin(s.expiryDelta) {
+		return s.token, nil
+	}
+	if s.token != nil && s.token.RefreshToken != "" {
+		if token, err := s.client.RefreshGrant(ctx, s.token.RefreshToken); err == nil {
+			s.token = token
+			return token, nil
+		}
+	}
+	token, err := s.grant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.token = token
+	return token, nil
+}
+
+/**
+为请求自动附加访问令牌的http.RoundTripper
+*/
+type Transport struct {
+	Source TokenSource
+	//为nil时使用http.DefaultTransport
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	//RoundTripper不能修改原请求
+	newReq := req.Clone(req.Context())
+	newReq.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(newReq)
+}
@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"time"
+)
+
+/**
+安全审计日志
+记录谁在何时、从哪里获取或使用了哪个令牌，事件写入一个或多个Sink，如JSON文件、syslog、webhook
+*/
+
+type EventType string
+
+const (
+	EventLoginSuccess                EventType = "login_success"
+	EventLoginFailure                EventType = "login_failure"
+	EventTokenIssued                 EventType = "token_issued"
+	EventTokenRefreshed              EventType = "token_refreshed"
+	EventTokenRevoked                EventType = "token_revoked"
+	EventClientAuthenticated         EventType = "client_authenticated"
+	EventClientAuthenticationFailure EventType = "client_authentication_failure"
+	EventPermissionDenied            EventType = "permission_denied"
+	//令牌存储不支持撤销时，被新令牌替换的访问令牌和已使用的刷新令牌仍然有效到过期
+	EventTokenRotated     EventType = "token_rotated"
+	EventRefreshTokenUsed EventType = "refresh_used"
+)
+
+//是否为失败或拒绝的事件
+func (t EventType) IsFailure() bool {
+	return t == EventLoginFailure || t == EventClientAuthenticationFailure || t == EventPermissionDenied
+}
+
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      EventType `json:"type"`
+	ClientId  string    `json:"client_id,omitempty"`
+	UserId    int64     `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	GrantType string    `json:"grant_type,omitempty"`
+	//令牌的指纹，不记录令牌值本身
+	TokenId   string `json:"token_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	//权限拒绝时请求的路由和方法
+	Path   string `json:"path,omitempty"`
+	Method string `json:"method,omitempty"`
+	//失败原因
+	Reason  string `json:"reason,omitempty"`
+	TraceId string `json:"trace_id,omitempty"`
+}
+
+//令牌值SHA-256摘要的前16字节，用于关联同一令牌的事件
+func TokenFingerprint(tokenValue string) string {
+	sum := sha256.Sum256([]byte(tokenValue))
+	return hex.EncodeToString(sum[:16])
+}
+
+//请求的来源，由transport层写入context
+type Source struct {
+	IP        string
+	UserAgent string
+}
+
+type sourceKey struct{}
+
+func NewSourceContext(ctx context.Context, source *Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+func SourceFromContext(ctx context.Context) (*Source, bool) {
+	source, ok := ctx.Value(sourceKey{}).(*Source)
+	return source, ok
+}
+
+//审计事件的写入目标
+type Sink interface {
+	Write(event *Event) error
+	Close() error
+}
+
+type Recorder interface {
+	//补全事件的时间、来源和trace id后写入
+	Record(ctx context.Context, event *Event)
+}
+
+//将事件写入全部Sink，写入失败只记录日志，不影响请求的处理
+type SinkRecorder struct {
+	sinks  []Sink
+	logger *log.Logger
+}
+
+func NewRecorder(logger *log.Logger, sinks ...Sink) *SinkRecorder {
+	return &SinkRecorder{
+		sinks:  sinks,
+		logger: logger,
+	}
+}
+
+func (r *SinkRecorder) Record(ctx context.Context, event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if source, ok := SourceFromContext(ctx); ok {
+		if event.IP == "" {
+			event.IP = source.IP
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = source.UserAgent
+		}
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		event.TraceId = spanContext.TraceID().String()
+	}
+	for _, sink := range r.sinks {
+		if err := sink.Write(event); err != nil {
+			r.logger.Println("write audit event error:", err)
+		}
+	}
+}
+
+func (r *SinkRecorder) Close() error {
+	var lastErr error
+	for _, sink := range r.sinks {
+		if err := sink.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	ErrHashChainBroken = errors.New("audit log hash chain is broken")
+	ErrNoChainKey      = errors.New("audit log hash chain key is empty")
+)
+
+/**
+文件中的一行，hash = HMAC-SHA256(key, prev_hash + event)
+修改或删除任意一行都会使之后的哈希链校验失败，没有秘钥时无法重新计算整条哈希链
+*/
+type fileRecord struct {
+	Event    json.RawMessage `json:"event"`
+	PrevHash string          `json:"prev_hash"`
+	Hash     string          `json:"hash"`
+}
+
+/**
+以JSON Lines格式追加写入文件的审计日志
+每条记录包含前一条记录的哈希，重新打开文件时先校验已有的哈希链，再从最后一条记录继续
+*/
+type FileSink struct {
+	mutex    sync.Mutex
+	file     *os.File
+	key      []byte
+	prevHash string
+}
+
+//已有文件的哈希链校验失败时返回错误，不在被篡改的记录之后追加
+func NewFileSink(path string, key []byte) (*FileSink, error) {
+	if len(key) == 0 {
+		return nil, ErrNoChainKey
+	}
+	prevHash, err := lastHash(path, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{
+		file:     file,
+		key:      key,
+		prevHash: prevHash,
+	}, nil
+}
+
+func (s *FileSink) Write(event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	record := &fileRecord{
+		Event:    value,
+		PrevHash: s.prevHash,
+		Hash:     chainHash(s.key, s.prevHash, value),
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	//审计记录需要落盘
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.prevHash = record.Hash
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
+
+//校验文件的哈希链，返回校验通过的记录数
+func VerifyFile(path string, key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, ErrNoChainKey
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count, _, err := verifyRecords(file, key)
+	return count, err
+}
+
+func chainHash(key []byte, prevHash string, event []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prevHash))
+	mac.Write(event)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//校验文件的哈希链并返回最后一条记录的哈希，文件不存在时为空
+func lastHash(path string, key []byte) (string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, hash, err := verifyRecords(file, key)
+	if err != nil {
+		return "", fmt.Errorf("verify %s: %w", path, err)
+	}
+	return hash, nil
+}
+
+//依次校验每条记录，返回校验通过的记录数和最后一条记录的哈希
+func verifyRecords(r io.Reader, key []byte) (int, string, error) {
+	count := 0
+	prevHash := ""
+	err := readRecords(r, func(record *fileRecord) error {
+		expected := chainHash(key, prevHash, record.Event)
+		if record.PrevHash != prevHash || !hmac.Equal([]byte(record.Hash), []byte(expected)) {
+			return fmt.Errorf("%w: record %d", ErrHashChainBroken, count+1)
+		}
+		prevHash = record.Hash
+		count++
+		return nil
+	})
+	return count, prevHash, err
+}
+
+//逐行解析记录，忽略空行
+func readRecords(r io.Reader, handle func(record *fileRecord) error) error {
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		value, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(value)) > 0 {
+			record := &fileRecord{}
+			if err := json.Unmarshal(value, record); err != nil {
+				return fmt.Errorf("%w: line %d: %v", ErrHashChainBroken, line, err)
+			}
+			if err := handle(record); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
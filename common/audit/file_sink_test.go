@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testChainKey = []byte("audit-chain-key-for-tests-0123456789")
+
+func writeEvents(t *testing.T, path string, key []byte, usernames ...string) {
+	t.Helper()
+	sink, err := NewFileSink(path, key)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	for _, username := range usernames {
+		if err := sink.Write(&Event{Type: EventLoginSuccess, Username: username}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestFileSinkContinuesChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEvents(t, path, testChainKey, "alice", "bob")
+	//重新打开后从最后一条记录继续
+	writeEvents(t, path, testChainKey, "carol")
+
+	count, err := VerifyFile(path, testChainKey)
+	if err != nil || count != 3 {
+		t.Fatalf("VerifyFile = %d, %v; want 3, nil", count, err)
+	}
+}
+
+func TestFileSinkDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+		valid  int
+	}{
+		{"modify event", func(lines []string) []string {
+			lines[1] = strings.Replace(lines[1], "bob", "mallory", 1)
+			return lines
+		}, 1},
+		{"delete record", func(lines []string) []string {
+			return append(lines[:1], lines[2:]...)
+		}, 1},
+		{"drop first record", func(lines []string) []string {
+			return lines[1:]
+		}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			writeEvents(t, path, testChainKey, "alice", "bob", "carol")
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := test.tamper(strings.Split(strings.TrimSpace(string(data)), "\n"))
+			if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			count, err := VerifyFile(path, testChainKey)
+			if !errors.Is(err, ErrHashChainBroken) || count != test.valid {
+				t.Fatalf("VerifyFile = %d, %v; want %d, %v", count, err, test.valid, ErrHashChainBroken)
+			}
+			//不在被篡改的文件之后追加
+			if _, err := NewFileSink(path, testChainKey); !errors.Is(err, ErrHashChainBroken) {
+				t.Fatalf("NewFileSink error = %v, want %v", err, ErrHashChainBroken)
+			}
+		})
+	}
+}
+
+func TestFileSinkRequiresKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	writeEvents(t, path, testChainKey, "alice")
+
+	//没有秘钥时无法伪造哈希链
+	if _, err := VerifyFile(path, []byte("another-key-another-key-another-key")); !errors.Is(err, ErrHashChainBroken) {
+		t.Fatalf("VerifyFile with wrong key error = %v, want %v", err, ErrHashChainBroken)
+	}
+	if _, err := NewFileSink(path, nil); err != ErrNoChainKey {
+		t.Fatalf("NewFileSink without key error = %v, want %v", err, ErrNoChainKey)
+	}
+}
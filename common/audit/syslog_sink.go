@@ -0,0 +1,35 @@
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+//以JSON格式写入syslog的authpriv设施，失败和拒绝的事件使用warning级别
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+//network和raddr为空时连接本机的syslog服务
+func NewSyslogSink(network, raddr, tag string) (*SyslogSink, error) {
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_AUTHPRIV|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+func (s *SyslogSink) Write(event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Type.IsFailure() {
+		return s.writer.Warning(string(value))
+	}
+	return s.writer.Info(string(value))
+}
+
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
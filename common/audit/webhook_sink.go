@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrWebhookQueueFull = errors.New("audit webhook queue is full")
+	ErrSinkClosed       = errors.New("audit sink is closed")
+)
+
+const (
+	DefaultWebhookQueueSize = 1024
+	//单个事件的最大投递次数
+	webhookAttempts = 3
+)
+
+/**
+将事件以JSON格式POST到webhook地址
+事件先放入队列，由后台协程投递，不阻塞请求；投递失败时重试，队列满时丢弃事件并返回错误
+*/
+type WebhookSink struct {
+	url        string
+	httpClient *http.Client
+	queue      chan *Event
+	done       sync.WaitGroup
+	//Close之后的Write返回ErrSinkClosed，不再向已关闭的队列发送
+	mutex  sync.RWMutex
+	closed bool
+	logger *log.Logger
+}
+
+//httpClient为nil时使用5秒超时的客户端，queueSize不大于0时使用DefaultWebhookQueueSize
+func NewWebhookSink(url string, httpClient *http.Client, queueSize int, logger *log.Logger) *WebhookSink {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultWebhookQueueSize
+	}
+	s := &WebhookSink{
+		url:        url,
+		httpClient: httpClient,
+		queue:      make(chan *Event, queueSize),
+		logger:     logger,
+	}
+	s.done.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *WebhookSink) Write(event *Event) error {
+	//队列中保存副本，调用方可以继续修改事件
+	copied := *event
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	select {
+	case s.queue <- &copied:
+		return nil
+	default:
+		return ErrWebhookQueueFull
+	}
+}
+
+//投递队列中剩余的事件后返回
+func (s *WebhookSink) Close() error {
+	s.mutex.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mutex.Unlock()
+	s.done.Wait()
+	return nil
+}
+
+func (s *WebhookSink) run() {
+	defer s.done.Done()
+	for event := range s.queue {
+		var err error
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			if err = s.post(event); err == nil {
+				break
+			}
+			if attempt < webhookAttempts {
+				time.Sleep(time.Duration(attempt) * time.Second)
+			}
+		}
+		if err != nil {
+			s.logger.Println("deliver audit event error:", err)
+		}
+	}
+}
+
+func (s *WebhookSink) post(event *Event) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := s.httpClient.Post(s.url, "application/json", bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
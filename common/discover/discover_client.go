@@ -1,20 +1,36 @@
 package discover
 
-import "log"
+import (
+	"context"
+	"errors"
+)
+
+var ErrInstanceNotRegistered = errors.New("service instance is not registered")
 
 type DiscoveryClient interface {
 	/**
-	服务注册
+	服务注册，healthCheckUrl为实例上健康检查的路径，如/health
 	*/
-	Register(serviceName, instanceId, healthCheckUrl string, instanceHost string, instancePort int, meta map[string]string, logger *log.Logger) bool
+	Register(ctx context.Context, instance *ServiceInstance, healthCheckUrl string) error
 
 	/**
 	服务注销
 	*/
-	Deregister(instanceId string, logger *log.Logger) bool
+	Deregister(ctx context.Context, instanceId string) error
+
+	/**
+	服务发现，只返回健康的实例
+	*/
+	DiscoverServices(ctx context.Context, serviceName string) ([]*ServiceInstance, error)
+
+	/**
+	订阅服务实例的变化，通道中首先收到当前的健康实例，之后每次变化收到最新的实例列表；
+	消费不及时时只保留最新的列表，ctx结束后通道关闭
+	*/
+	Watch(ctx context.Context, serviceName string) (<-chan []*ServiceInstance, error)
 
 	/**
-	服务发现
+	停止后台的监控和续约，释放连接
 	*/
-	DiscoverServices(serviceName string, logger *log.Logger) []interface{}
+	Close() error
 }
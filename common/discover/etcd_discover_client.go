@@ -0,0 +1,418 @@
+package discover
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"go.etcd.io/etcd/client/v3"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//服务实例在etcd中的默认键前缀，完整的键为 前缀/服务名/实例ID
+	DefaultEtcdPrefix = "/services"
+	//租约的默认有效期，实例停止续约超过该时间后自动从etcd中删除
+	DefaultEtcdLeaseTTL = 15 * time.Second
+)
+
+var ErrInvalidEtcdInstance = errors.New("invalid etcd service instance")
+
+//写入etcd的实例数据
+type etcdServiceInstance struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Host    string            `json:"host"`
+	Port    int               `json:"port"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+	Weight  int               `json:"weight"`
+	Healthy bool              `json:"healthy"`
+	Secure  bool              `json:"secure,omitempty"`
+}
+
+type etcdRegistration struct {
+	key string
+	//停止续约
+	cancel context.CancelFunc
+}
+
+/**
+基于etcd v3的服务发现
+注册时为实例创建租约并持续续约，进程退出或失联后实例随租约过期自动删除；
+注册方按租约有效期定期请求自身的健康检查地址，并把结果写回实例数据，发现方只返回健康的实例；
+每个服务名首次发现时读取前缀下的全部实例，之后通过watch增量更新本地缓存
+*/
+type EtcdDiscoveryClient struct {
+	client *clientv3.Client
+	//是否由本对象创建了etcd客户端，Close时需要关闭
+	ownClient bool
+	prefix    string
+	ttl       time.Duration
+	//用于请求健康检查地址
+	httpClient *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mutex sync.Mutex
+	//实例ID -> 本进程注册的实例
+	registrations map[string]*etcdRegistration
+	//服务名 -> 键 -> 实例
+	instances map[string]map[string]*ServiceInstance
+	watchers  instanceWatchers
+	logger    *log.Logger
+}
+
+//连接etcd集群，endpoints为etcd地址列表
+//checkTLSConfig用于请求HTTPS健康检查地址，为nil时使用系统根证书验证
+func NewEtcdDiscoveryClient(endpoints []string, prefix string, ttl time.Duration, checkTLSConfig *tls.Config, logger *log.Logger) (*EtcdDiscoveryClient, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := NewEtcdDiscoveryClientWithClient(client, prefix, ttl, checkTLSConfig, logger)
+	c.ownClient = true
+	return c, nil
+}
+
+//使用已有的etcd客户端，如连接嵌入式etcd服务器的客户端
+func NewEtcdDiscoveryClientWithClient(client *clientv3.Client, prefix string, ttl time.Duration, checkTLSConfig *tls.Config, logger *log.Logger) *EtcdDiscoveryClient {
+	if prefix == "" {
+		prefix = DefaultEtcdPrefix
+	}
+	if ttl < time.Second {
+		ttl = DefaultEtcdLeaseTTL
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EtcdDiscoveryClient{
+		client: client,
+		prefix: strings.TrimSuffix(prefix, "/"),
+		ttl:    ttl,
+		httpClient: &http.Client{
+			Timeout:   ttl / 3,
+			Transport: &http.Transport{TLSClientConfig: checkTLSConfig},
+		},
+		ctx:           ctx,
+		cancel:        cancel,
+		registrations: make(map[string]*etcdRegistration),
+		instances:     make(map[string]map[string]*ServiceInstance),
+		logger:        logger,
+	}
+}
+
+func (c *EtcdDiscoveryClient) Register(ctx context.Context, instance *ServiceInstance, healthCheckUrl string) error {
+	registered := &etcdServiceInstance{
+		ID:      instance.ID,
+		Name:    instance.Name,
+		Host:    instance.Host,
+		Port:    instance.Port,
+		Tags:    instance.Tags,
+		Meta:    instance.Meta,
+		Weight:  instance.Weight,
+		Healthy: true,
+		Secure:  instance.Secure,
+	}
+	leaseId, err := c.put(ctx, registered)
+	if err != nil {
+		return err
+	}
+
+	keepAliveCtx, cancel := context.WithCancel(c.ctx)
+	c.mutex.Lock()
+	if previous, ok := c.registrations[instance.ID]; ok {
+		previous.cancel()
+	}
+	c.registrations[instance.ID] = &etcdRegistration{
+		key:    c.serviceKey(instance.Name) + instance.ID,
+		cancel: cancel,
+	}
+	c.mutex.Unlock()
+
+	checkUrl := ""
+	if healthCheckUrl != "" {
+		checkUrl = instance.Scheme() + "://" + instance.Address() + healthCheckUrl
+	}
+	go c.keepAlive(keepAliveCtx, registered, leaseId, checkUrl)
+	return nil
+}
+
+func (c *EtcdDiscoveryClient) Deregister(ctx context.Context, instanceId string) error {
+	c.mutex.Lock()
+	registration, ok := c.registrations[instanceId]
+	delete(c.registrations, instanceId)
+	c.mutex.Unlock()
+	if !ok {
+		return ErrInstanceNotRegistered
+	}
+	//停止续约，并删除键使实例立即下线，而不是等待租约过期
+	registration.cancel()
+	_, err := c.client.Delete(ctx, registration.key)
+	return err
+}
+
+func (c *EtcdDiscoveryClient) DiscoverServices(ctx context.Context, serviceName string) ([]*ServiceInstance, error) {
+	if err := c.watch(ctx, serviceName); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.healthy(serviceName), nil
+}
+
+func (c *EtcdDiscoveryClient) Watch(ctx context.Context, serviceName string) (<-chan []*ServiceInstance, error) {
+	if err := c.watch(ctx, serviceName); err != nil {
+		return nil, err
+	}
+	//持有锁添加订阅者，保证不会遗漏期间发生的变化
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.watchers.add(ctx, serviceName, c.healthy(serviceName)), nil
+}
+
+//停止全部续约和watch，由本对象创建的etcd客户端会被关闭
+func (c *EtcdDiscoveryClient) Close() error {
+	c.cancel()
+	c.watchers.close()
+	if c.ownClient {
+		return c.client.Close()
+	}
+	return nil
+}
+
+//缓存中健康的实例，调用方需持有锁
+func (c *EtcdDiscoveryClient) healthy(serviceName string) []*ServiceInstance {
+	instances := make([]*ServiceInstance, 0, len(c.instances[serviceName]))
+	for _, instance := range c.instances[serviceName] {
+		if instance.Healthy {
+			instances = append(instances, instance)
+		}
+	}
+	return instances
+}
+
+//服务尚未缓存时，读取服务的全部实例写入缓存，并启动watch增量更新
+func (c *EtcdDiscoveryClient) watch(ctx context.Context, serviceName string) error {
+	c.mutex.Lock()
+	_, ok := c.instances[serviceName]
+	c.mutex.Unlock()
+	if ok {
+		return nil
+	}
+
+	instances, revision, err := c.load(ctx, serviceName)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	if _, ok := c.instances[serviceName]; ok {
+		//并发的首次发现已经启动了watch
+		c.mutex.Unlock()
+		return nil
+	}
+	c.instances[serviceName] = instances
+	c.mutex.Unlock()
+
+	go c.follow(serviceName, revision)
+	return nil
+}
+
+//读取服务的全部实例，返回读取时的版本
+func (c *EtcdDiscoveryClient) load(ctx context.Context, serviceName string) (map[string]*ServiceInstance, int64, error) {
+	resp, err := c.client.Get(ctx, c.serviceKey(serviceName), clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	instances := make(map[string]*ServiceInstance, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		if instance, err := decodeEtcdInstance(kv.Value); err == nil {
+			instances[string(kv.Key)] = instance
+		}
+	}
+	return instances, resp.Header.Revision, nil
+}
+
+//从revision之后开始watch并更新缓存，直到Close；
+//watch异常结束（如起始版本已被压缩）时重新读取全部实例，通知现有订阅者，并从新的版本继续watch
+func (c *EtcdDiscoveryClient) follow(serviceName string, revision int64) {
+	for {
+		c.watchEvents(serviceName, revision)
+		if c.ctx.Err() != nil {
+			break
+		}
+
+		c.logger.Println("etcd watch ended, reload service instances:", serviceName)
+		var instances map[string]*ServiceInstance
+		var err error
+		for {
+			instances, revision, err = c.load(c.ctx, serviceName)
+			if err == nil || c.ctx.Err() != nil {
+				break
+			}
+			c.logger.Println("reload service instances error:", err)
+			select {
+			case <-c.ctx.Done():
+			case <-time.After(c.ttl / 3):
+			}
+		}
+		if err != nil {
+			break
+		}
+		c.mutex.Lock()
+		c.instances[serviceName] = instances
+		c.watchers.notify(serviceName, c.healthy(serviceName))
+		c.mutex.Unlock()
+	}
+	//Close后清除缓存
+	c.mutex.Lock()
+	delete(c.instances, serviceName)
+	c.mutex.Unlock()
+}
+
+//处理watch事件，直到watch结束；从读取时的版本之后开始watch，不会遗漏中间的变化
+func (c *EtcdDiscoveryClient) watchEvents(serviceName string, revision int64) {
+	watchChan := c.client.Watch(c.ctx, c.serviceKey(serviceName), clientv3.WithPrefix(), clientv3.WithRev(revision+1))
+	for watchResp := range watchChan {
+		if err := watchResp.Err(); err != nil {
+			c.logger.Println("etcd watch error:", err)
+			continue
+		}
+		c.mutex.Lock()
+		for _, event := range watchResp.Events {
+			switch event.Type {
+			case clientv3.EventTypePut:
+				if instance, err := decodeEtcdInstance(event.Kv.Value); err == nil {
+					c.instances[serviceName][string(event.Kv.Key)] = instance
+				}
+			case clientv3.EventTypeDelete:
+				delete(c.instances[serviceName], string(event.Kv.Key))
+			}
+		}
+		c.watchers.notify(serviceName, c.healthy(serviceName))
+		c.mutex.Unlock()
+	}
+}
+
+//为实例创建租约并写入etcd
+func (c *EtcdDiscoveryClient) put(ctx context.Context, instance *etcdServiceInstance) (clientv3.LeaseID, error) {
+	lease, err := c.client.Grant(ctx, int64(c.ttl/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := c.client.Put(ctx, c.serviceKey(instance.Name)+instance.ID, string(value), clientv3.WithLease(lease.ID)); err != nil {
+		return 0, err
+	}
+	return lease.ID, nil
+}
+
+//持续续约，并按租约有效期请求健康检查地址，健康状态变化时更新实例数据；
+//租约丢失（如etcd长时间不可达）后重新注册，直到ctx结束
+func (c *EtcdDiscoveryClient) keepAlive(ctx context.Context, instance *etcdServiceInstance, leaseId clientv3.LeaseID, checkUrl string) {
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for {
+		aliveChan, err := c.client.KeepAlive(ctx, leaseId)
+		if err == nil {
+		alive:
+			for {
+				select {
+				case <-ctx.Done():
+					c.revoke(leaseId)
+					return
+				case _, ok := <-aliveChan:
+					if !ok {
+						break alive
+					}
+				case <-ticker.C:
+					if checkUrl == "" {
+						continue
+					}
+					healthy := c.check(ctx, checkUrl)
+					if healthy == instance.Healthy {
+						continue
+					}
+					instance.Healthy = healthy
+					value, _ := json.Marshal(instance)
+					if _, err := c.client.Put(ctx, c.serviceKey(instance.Name)+instance.ID, string(value), clientv3.WithLease(leaseId)); err != nil {
+						c.logger.Println("update service health error:", err)
+					}
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			c.revoke(leaseId)
+			return
+		}
+
+		c.logger.Println("etcd lease lost, register service again")
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if leaseId, err = c.put(ctx, instance); err == nil {
+				break
+			}
+			c.logger.Println("register service error:", err)
+		}
+	}
+}
+
+//请求健康检查地址，2xx视为健康
+func (c *EtcdDiscoveryClient) check(ctx context.Context, url string) bool {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+//撤销租约，实例随之删除
+func (c *EtcdDiscoveryClient) revoke(leaseId clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c.client.Revoke(ctx, leaseId)
+}
+
+func (c *EtcdDiscoveryClient) serviceKey(serviceName string) string {
+	return c.prefix + "/" + serviceName + "/"
+}
+
+func decodeEtcdInstance(value []byte) (*ServiceInstance, error) {
+	instance := &etcdServiceInstance{}
+	if err := json.Unmarshal(value, instance); err != nil {
+		return nil, err
+	}
+	if instance.ID == "" || instance.Host == "" {
+		return nil, ErrInvalidEtcdInstance
+	}
+	return &ServiceInstance{
+		ID:      instance.ID,
+		Name:    instance.Name,
+		Host:    instance.Host,
+		Port:    instance.Port,
+		Tags:    instance.Tags,
+		Meta:    instance.Meta,
+		Weight:  instance.Weight,
+		Healthy: instance.Healthy,
+		Secure:  instance.Secure,
+	}, nil
+}
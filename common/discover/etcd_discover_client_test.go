@@ -0,0 +1,351 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//etcd允许的最小租约时长
+const testLeaseTTL = 2 * time.Second
+
+//启动单节点的嵌入式etcd，返回连接它的客户端
+func startEmbeddedEtcd(t *testing.T) *clientv3.Client {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientUrl, peerUrl := freeUrl(t), freeUrl(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientUrl}, []url.URL{clientUrl}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerUrl}, []url.URL{peerUrl}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("start embedded etcd: %v", err)
+	}
+	t.Cleanup(server.Close)
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("embedded etcd is not ready")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{clientUrl.String()},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("connect embedded etcd: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func freeUrl(t *testing.T) url.URL {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return url.URL{Scheme: "http", Host: listener.Addr().String()}
+}
+
+func newTestEtcdDiscoveryClient(t *testing.T, client *clientv3.Client) *EtcdDiscoveryClient {
+	t.Helper()
+	c := NewEtcdDiscoveryClientWithClient(client, "/test", testLeaseTTL, nil, log.New(ioutil.Discard, "", 0))
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func testInstance(id string) *ServiceInstance {
+	return &ServiceInstance{ID: id, Name: "oauth", Host: "127.0.0.1", Port: 10098, Weight: 1}
+}
+
+//等待发现的实例ID满足条件
+func waitForInstances(t *testing.T, c *EtcdDiscoveryClient, condition func(ids map[string]bool) bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * testLeaseTTL)
+	for {
+		instances, err := c.DiscoverServices(context.Background(), "oauth")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[string]bool, len(instances))
+		for _, instance := range instances {
+			ids[instance.ID] = true
+		}
+		if condition(ids) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected instances %v", ids)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestEtcdDiscoveryWatchCache(t *testing.T) {
+	client := startEmbeddedEtcd(t)
+	registry := newTestEtcdDiscoveryClient(t, client)
+	discoverer := newTestEtcdDiscoveryClient(t, client)
+	ctx := context.Background()
+
+	if err := registry.Register(ctx, testInstance("oauth-1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	//首次发现读取全部实例，之后由watch更新缓存
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+	updates, err := discoverer.Watch(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+
+	if err := registry.Register(ctx, testInstance("oauth-2"), ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case instances := <-updates:
+		if len(instances) != 2 {
+			t.Fatalf("watch update has %d instances, want 2", len(instances))
+		}
+	case <-time.After(testLeaseTTL):
+		t.Fatal("no watch update after register")
+	}
+
+	if err := registry.Deregister(ctx, "oauth-1"); err != nil {
+		t.Fatal(err)
+	}
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return !ids["oauth-1"] && ids["oauth-2"] })
+}
+
+func TestEtcdDiscoveryLeaseExpiry(t *testing.T) {
+	client := startEmbeddedEtcd(t)
+	discoverer := newTestEtcdDiscoveryClient(t, client)
+	ctx := context.Background()
+
+	//没有续约的实例在租约过期后从缓存中删除
+	lease, err := client.Grant(ctx, int64(testLeaseTTL/time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := `{"id":"oauth-1","name":"oauth","host":"127.0.0.1","port":10098,"weight":1,"healthy":true}`
+	if _, err := client.Put(ctx, "/test/oauth/oauth-1", value, clientv3.WithLease(lease.ID)); err != nil {
+		t.Fatal(err)
+	}
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return len(ids) == 0 })
+}
+
+func TestEtcdDiscoveryReRegistersAfterLeaseLoss(t *testing.T) {
+	client := startEmbeddedEtcd(t)
+	registry := newTestEtcdDiscoveryClient(t, client)
+	discoverer := newTestEtcdDiscoveryClient(t, client)
+	ctx := context.Background()
+
+	if err := registry.Register(ctx, testInstance("oauth-1"), ""); err != nil {
+		t.Fatal(err)
+	}
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+
+	//撤销租约模拟etcd长时间不可达，实例被删除后应使用新的租约重新注册
+	lease := registeredLease(t, client, "/test/oauth/oauth-1")
+	if lease == 0 {
+		t.Fatal("registered key not found")
+	}
+	if _, err := client.Revoke(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * testLeaseTTL)
+	for {
+		if current := registeredLease(t, client, "/test/oauth/oauth-1"); current != 0 && current != lease {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("service is not registered again after lease loss")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+}
+
+//键当前绑定的租约，键不存在时为0
+func registeredLease(t *testing.T, client *clientv3.Client, key string) clientv3.LeaseID {
+	t.Helper()
+	resp, err := client.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return 0
+	}
+	return clientv3.LeaseID(resp.Kvs[0].Lease)
+}
+
+func TestEtcdDiscoveryHealthCheck(t *testing.T) {
+	var healthy int32 = 1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	address, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(address.Port())
+
+	client := startEmbeddedEtcd(t)
+	//使用测试服务器的证书验证HTTPS健康检查
+	checkTLSConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	registry := NewEtcdDiscoveryClientWithClient(client, "/test", testLeaseTTL, checkTLSConfig, log.New(ioutil.Discard, "", 0))
+	defer registry.Close()
+	discoverer := newTestEtcdDiscoveryClient(t, client)
+
+	instance := testInstance("oauth-1")
+	instance.Host, instance.Port, instance.Secure = address.Hostname(), port, true
+	if err := registry.Register(context.Background(), instance, "/health"); err != nil {
+		t.Fatal(err)
+	}
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+
+	//检查失败的实例不再被发现，恢复后重新出现
+	atomic.StoreInt32(&healthy, 0)
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return len(ids) == 0 })
+	atomic.StoreInt32(&healthy, 1)
+	waitForInstances(t, discoverer, func(ids map[string]bool) bool { return ids["oauth-1"] })
+}
+
+//只实现Get和Watch的内存etcd，用于模拟watch被压缩等难以在真实etcd上触发的情况
+type fakeEtcd struct {
+	clientv3.KV
+	clientv3.Watcher
+	mutex    sync.Mutex
+	revision int64
+	kvs      map[string]string
+	//Get返回错误的次数
+	getFailures int
+	watches     chan *fakeEtcdWatch
+}
+
+type fakeEtcdWatch struct {
+	revision int64
+	ch       chan clientv3.WatchResponse
+	once     sync.Once
+}
+
+func (w *fakeEtcdWatch) close() {
+	w.once.Do(func() { close(w.ch) })
+}
+
+func (f *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.getFailures > 0 {
+		f.getFailures--
+		return nil, errors.New("etcd unavailable")
+	}
+	resp := &clientv3.GetResponse{Header: &etcdserverpb.ResponseHeader{Revision: f.revision}}
+	for k, v := range f.kvs {
+		if strings.HasPrefix(k, key) {
+			resp.Kvs = append(resp.Kvs, &mvccpb.KeyValue{Key: []byte(k), Value: []byte(v)})
+		}
+	}
+	return resp, nil
+}
+
+func (f *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	w := &fakeEtcdWatch{revision: clientv3.OpGet(key, opts...).Rev(), ch: make(chan clientv3.WatchResponse, 1)}
+	go func() {
+		<-ctx.Done()
+		w.close()
+	}()
+	f.watches <- w
+	return w.ch
+}
+
+func (f *fakeEtcd) nextWatch(t *testing.T) *fakeEtcdWatch {
+	t.Helper()
+	select {
+	case w := <-f.watches:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch is not started")
+	}
+	return nil
+}
+
+func fakeEtcdValue(id string) string {
+	return `{"id":"` + id + `","name":"oauth","host":"127.0.0.1","port":10098,"weight":1,"healthy":true}`
+}
+
+//watch的起始版本被压缩后，重新读取全部实例，通知现有订阅者，并从新的版本继续watch
+func TestEtcdWatchCompacted(t *testing.T) {
+	fake := &fakeEtcd{
+		revision: 10,
+		kvs: map[string]string{
+			"/test/oauth/oauth-1": fakeEtcdValue("oauth-1"),
+			"/test/oauth/oauth-2": fakeEtcdValue("oauth-2"),
+		},
+		watches: make(chan *fakeEtcdWatch, 1),
+	}
+	c := NewEtcdDiscoveryClientWithClient(&clientv3.Client{KV: fake, Watcher: fake}, "/test", testLeaseTTL, nil, log.New(ioutil.Discard, "", 0))
+	defer c.Close()
+	ctx := context.Background()
+
+	updates, err := c.Watch(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveUntil(t, updates, "oauth-1", "oauth-2")
+	w := fake.nextWatch(t)
+	if w.revision != 11 {
+		t.Fatalf("watch revision = %d, want 11", w.revision)
+	}
+
+	//压缩期间发生的变化无法通过watch获得，第一次重新读取失败后重试
+	fake.mutex.Lock()
+	delete(fake.kvs, "/test/oauth/oauth-1")
+	fake.kvs["/test/oauth/oauth-3"] = fakeEtcdValue("oauth-3")
+	fake.revision = 20
+	fake.getFailures = 1
+	fake.mutex.Unlock()
+	w.ch <- clientv3.WatchResponse{CompactRevision: 15}
+	w.close()
+
+	receiveUntil(t, updates, "oauth-2", "oauth-3")
+	w = fake.nextWatch(t)
+	if w.revision != 21 {
+		t.Fatalf("watch revision after reload = %d, want 21", w.revision)
+	}
+	w.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{{
+		Type: clientv3.EventTypePut,
+		Kv:   &mvccpb.KeyValue{Key: []byte("/test/oauth/oauth-4"), Value: []byte(fakeEtcdValue("oauth-4"))},
+	}}}
+	receiveUntil(t, updates, "oauth-2", "oauth-3", "oauth-4")
+	instances, err := c.DiscoverServices(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 3 {
+		t.Fatalf("DiscoverServices after re-watch = %v", ids(instances))
+	}
+}
@@ -0,0 +1,145 @@
+package discover
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//实例文件中的服务实例，文件可以是YAML或JSON格式，示例见config/instances.yaml
+type fileServiceInstance struct {
+	ID   string            `yaml:"id"`
+	Host string            `yaml:"host"`
+	Port int               `yaml:"port"`
+	Tags []string          `yaml:"tags"`
+	Meta map[string]string `yaml:"meta"`
+	//未配置时为1
+	Weight *int `yaml:"weight"`
+	//未配置时为true
+	Healthy *bool `yaml:"healthy"`
+	//是否使用HTTPS访问
+	Secure bool `yaml:"secure"`
+}
+
+type fileServiceInstances struct {
+	//服务名 -> 实例列表
+	Services map[string][]fileServiceInstance `yaml:"services"`
+}
+
+/**
+基于实例文件的服务发现
+文件修改后通过WatchFile自动重新加载，文件有误时保留原有的实例列表
+*/
+type FileDiscoveryClient struct {
+	*StaticDiscoveryClient
+	path    string
+	logger  *log.Logger
+	mutex   sync.Mutex
+	modTime time.Time
+}
+
+func NewFileDiscoveryClient(path string, logger *log.Logger) (*FileDiscoveryClient, error) {
+	c := &FileDiscoveryClient{
+		StaticDiscoveryClient: NewStaticDiscoveryClient(nil),
+		path:                  path,
+		logger:                logger,
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//重新加载实例文件
+func (c *FileDiscoveryClient) Reload() error {
+	info, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	instances, err := ParseServiceInstances(data)
+	if err != nil {
+		return err
+	}
+	c.update(instances)
+	c.mutex.Lock()
+	c.modTime = info.ModTime()
+	c.mutex.Unlock()
+	return nil
+}
+
+//定期检查实例文件的修改时间，发生变化时重新加载，直到ctx结束
+func (c *FileDiscoveryClient) WatchFile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(c.path)
+			if err != nil {
+				c.logger.Println("stat service instance file error:", err)
+				continue
+			}
+			c.mutex.Lock()
+			modTime := c.modTime
+			c.mutex.Unlock()
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			if err := c.Reload(); err != nil {
+				c.logger.Println("reload service instance file error:", err)
+				continue
+			}
+			c.logger.Println("service instances reloaded")
+		}
+	}
+}
+
+//解析YAML或JSON格式的实例文件
+func ParseServiceInstances(data []byte) ([]*ServiceInstance, error) {
+	file := &fileServiceInstances{}
+	//JSON是YAML的子集，两种格式使用同一个解析器
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, err
+	}
+	var instances []*ServiceInstance
+	for name, items := range file.Services {
+		for i, item := range items {
+			if item.Host == "" || item.Port <= 0 {
+				return nil, fmt.Errorf("%w: %s[%d]", ErrInvalidStaticInstance, name, i)
+			}
+			instance := &ServiceInstance{
+				ID:      item.ID,
+				Name:    name,
+				Host:    item.Host,
+				Port:    item.Port,
+				Tags:    item.Tags,
+				Meta:    item.Meta,
+				Weight:  1,
+				Healthy: true,
+				Secure:  item.Secure,
+			}
+			if instance.ID == "" {
+				instance.ID = name + "-" + instance.Address()
+			}
+			if item.Weight != nil {
+				instance.Weight = *item.Weight
+			}
+			if item.Healthy != nil {
+				instance.Healthy = *item.Healthy
+			}
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
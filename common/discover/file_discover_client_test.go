@@ -0,0 +1,112 @@
+package discover
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseServiceInstances(t *testing.T) {
+	yamlData := `
+services:
+  oauth:
+    - id: oauth-1
+      host: 127.0.0.1
+      port: 10098
+      weight: 3
+      tags: [v1]
+      meta:
+        zone: a
+    - host: oauth.internal
+      port: 443
+      secure: true
+      healthy: false
+`
+	jsonData := `{"services": {"oauth": [
+		{"id": "oauth-1", "host": "127.0.0.1", "port": 10098, "weight": 3, "tags": ["v1"], "meta": {"zone": "a"}},
+		{"host": "oauth.internal", "port": 443, "secure": true, "healthy": false}
+	]}}`
+	want := []*ServiceInstance{
+		{ID: "oauth-1", Name: "oauth", Host: "127.0.0.1", Port: 10098, Tags: []string{"v1"}, Meta: map[string]string{"zone": "a"}, Weight: 3, Healthy: true},
+		//未配置ID时使用服务名和地址，权重默认为1
+		{ID: "oauth-oauth.internal:443", Name: "oauth", Host: "oauth.internal", Port: 443, Weight: 1, Secure: true},
+	}
+	for name, data := range map[string]string{"yaml": yamlData, "json": jsonData} {
+		t.Run(name, func(t *testing.T) {
+			instances, err := ParseServiceInstances([]byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(instances, want) {
+				t.Fatalf("ParseServiceInstances = %+v, want %+v", instances, want)
+			}
+		})
+	}
+
+	for _, data := range []string{
+		"services:\n  oauth:\n    - port: 10098\n",
+		"services:\n  oauth:\n    - host: 127.0.0.1\n",
+		"services:\n  oauth:\n    - host: 127.0.0.1\n      port: -1\n",
+	} {
+		if _, err := ParseServiceInstances([]byte(data)); !errors.Is(err, ErrInvalidStaticInstance) {
+			t.Errorf("ParseServiceInstances(%q) error = %v, want %v", data, err, ErrInvalidStaticInstance)
+		}
+	}
+	//未知字段和格式错误的文件同样返回错误
+	for _, data := range []string{
+		"services:\n  oauth:\n    - host: 127.0.0.1\n      port: 10098\n      zone: a\n",
+		"services: [oauth]\n",
+	} {
+		if _, err := ParseServiceInstances([]byte(data)); err == nil {
+			t.Errorf("ParseServiceInstances accepted %q", data)
+		}
+	}
+}
+
+func TestFileDiscoveryClientReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.yaml")
+	writeInstances := func(data string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeInstances("services:\n  oauth:\n    - id: oauth-1\n      host: 127.0.0.1\n      port: 10098\n")
+	c, err := NewFileDiscoveryClient(path, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if got := instanceIds(t, c, "oauth"); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("oauth instances = %v", got)
+	}
+
+	writeInstances("services:\n  oauth:\n    - id: oauth-1\n      host: 127.0.0.1\n      port: 10098\n    - id: oauth-2\n      host: 127.0.0.1\n      port: 10099\n")
+	if err := c.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got := instanceIds(t, c, "oauth")
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"oauth-1", "oauth-2"}) {
+		t.Fatalf("oauth instances after Reload = %v", got)
+	}
+
+	//文件有误时保留原有的实例列表
+	writeInstances("services:\n  oauth:\n    - id: oauth-3\n      port: 10100\n")
+	if err := c.Reload(); err == nil {
+		t.Fatal("Reload accepted an invalid instance file")
+	}
+	got = instanceIds(t, c, "oauth")
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, []string{"oauth-1", "oauth-2"}) {
+		t.Fatalf("previous instances are lost after a failed reload: %v", got)
+	}
+
+	if _, err := NewFileDiscoveryClient(filepath.Join(t.TempDir(), "missing.yaml"), log.New(ioutil.Discard, "", 0)); err == nil {
+		t.Fatal("NewFileDiscoveryClient accepted a missing file")
+	}
+}
@@ -0,0 +1,45 @@
+package discover
+
+import (
+	"net"
+	"strconv"
+)
+
+const (
+	//服务实例元数据中的权重字段
+	WeightMetaKey = "weight"
+	//服务实例元数据中标记HTTPS的字段，值为true
+	SecureMetaKey = "secure"
+)
+
+/**
+与注册中心无关的服务实例
+DiscoveryClient的各个实现负责把注册中心的数据转换为ServiceInstance，LoadBalance只依赖该结构
+*/
+type ServiceInstance struct {
+	ID   string
+	Name string
+	Host string
+	Port int
+	Tags []string
+	Meta map[string]string
+	//负载均衡权重，小于等于0的实例不会被加权轮询选中
+	Weight int
+	//注册中心的健康检查是否通过
+	Healthy bool
+	//是否使用HTTPS访问
+	Secure bool
+}
+
+//实例的host:port地址
+func (instance *ServiceInstance) Address() string {
+	return net.JoinHostPort(instance.Host, strconv.Itoa(instance.Port))
+}
+
+//访问实例使用的协议，http或https
+func (instance *ServiceInstance) Scheme() string {
+	if instance.Secure {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,105 @@
+package discover
+
+import (
+	"github.com/hashicorp/consul/api"
+	"reflect"
+	"testing"
+)
+
+func TestServiceInstanceAddress(t *testing.T) {
+	tests := []struct {
+		instance ServiceInstance
+		address  string
+		scheme   string
+	}{
+		{ServiceInstance{Host: "127.0.0.1", Port: 10098}, "127.0.0.1:10098", "http"},
+		{ServiceInstance{Host: "oauth.internal", Port: 443, Secure: true}, "oauth.internal:443", "https"},
+		//IPv6地址需要加方括号
+		{ServiceInstance{Host: "::1", Port: 10098}, "[::1]:10098", "http"},
+	}
+	for _, tt := range tests {
+		if address := tt.instance.Address(); address != tt.address {
+			t.Errorf("Address() = %q, want %q", address, tt.address)
+		}
+		if scheme := tt.instance.Scheme(); scheme != tt.scheme {
+			t.Errorf("Scheme() = %q, want %q", scheme, tt.scheme)
+		}
+	}
+}
+
+func TestNewConsulServiceInstance(t *testing.T) {
+	passing := api.HealthChecks{{Status: api.HealthPassing}}
+	tests := []struct {
+		name  string
+		entry *api.ServiceEntry
+		want  *ServiceInstance
+	}{
+		{
+			"service address and metadata weight",
+			&api.ServiceEntry{
+				Node: &api.Node{Address: "10.0.0.1"},
+				Service: &api.AgentService{
+					ID: "oauth-1", Service: "oauth", Address: "10.0.0.2", Port: 10098,
+					Tags:    []string{"v1"},
+					Meta:    map[string]string{WeightMetaKey: "5", SecureMetaKey: "true"},
+					Weights: api.AgentWeights{Passing: 3},
+				},
+				Checks: passing,
+			},
+			&ServiceInstance{
+				ID: "oauth-1", Name: "oauth", Host: "10.0.0.2", Port: 10098,
+				Tags:   []string{"v1"},
+				Meta:   map[string]string{WeightMetaKey: "5", SecureMetaKey: "true"},
+				Weight: 5, Healthy: true, Secure: true,
+			},
+		},
+		{
+			//没有服务地址时使用节点地址，元数据中的权重无效时使用consul的权重
+			"node address and consul weight",
+			&api.ServiceEntry{
+				Node: &api.Node{Address: "10.0.0.1"},
+				Service: &api.AgentService{
+					ID: "oauth-2", Service: "oauth", Port: 10099,
+					Meta:    map[string]string{WeightMetaKey: "heavy"},
+					Weights: api.AgentWeights{Passing: 3},
+				},
+				Checks: api.HealthChecks{{Status: api.HealthWarning}},
+			},
+			&ServiceInstance{
+				ID: "oauth-2", Name: "oauth", Host: "10.0.0.1", Port: 10099,
+				Meta:   map[string]string{WeightMetaKey: "heavy"},
+				Weight: 3,
+			},
+		},
+		{
+			"default weight",
+			&api.ServiceEntry{
+				Service: &api.AgentService{ID: "oauth-3", Service: "oauth", Address: "10.0.0.3", Port: 10098},
+				Checks:  passing,
+			},
+			&ServiceInstance{ID: "oauth-3", Name: "oauth", Host: "10.0.0.3", Port: 10098, Weight: 1, Healthy: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newConsulServiceInstance(tt.entry); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("newConsulServiceInstance = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+//注册到consul时在元数据中标记HTTPS，不修改实例原有的元数据
+func TestConsulServiceMeta(t *testing.T) {
+	meta := map[string]string{"zone": "a"}
+	if got := consulServiceMeta(&ServiceInstance{Meta: meta}); !reflect.DeepEqual(got, meta) {
+		t.Fatalf("plain instance meta = %v, want %v", got, meta)
+	}
+	got := consulServiceMeta(&ServiceInstance{Meta: meta, Secure: true})
+	if want := map[string]string{"zone": "a", SecureMetaKey: "true"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("secure instance meta = %v, want %v", got, want)
+	}
+	if _, ok := meta[SecureMetaKey]; ok {
+		t.Fatal("instance meta is modified")
+	}
+}
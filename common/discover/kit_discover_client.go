@@ -1,24 +1,67 @@
 package discover
 
 import (
+	"context"
 	"github.com/go-kit/kit/sd/consul"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/api/watch"
 	"log"
 	"strconv"
 	"sync"
+	"time"
 )
 
+//consul注册时的健康检查与注销配置
+type ConsulRegistrationConfig struct {
+	//大于0时使用TTL检查，由进程内心跳按HealthCheck的结果上报状态；否则由consul请求HTTP健康检查地址
+	TTL time.Duration
+	//HTTP检查的间隔，同时也是心跳检测实例是否丢失的间隔
+	CheckInterval time.Duration
+	//HTTP检查的超时时间
+	CheckTimeout time.Duration
+	//检查持续失败超过该时间后consul自动注销实例
+	DeregisterCriticalServiceAfter time.Duration
+	//注销前先进入维护模式并等待该时间，让调用方停止发送新请求
+	DrainTimeout time.Duration
+	//TTL心跳上报的健康状态，为nil时视为健康
+	HealthCheck func() bool
+	//HTTPS检查时consul是否跳过证书验证，以及验证证书使用的服务器名
+	TLSSkipVerify bool
+	TLSServerName string
+}
+
+var DefaultConsulRegistrationConfig = ConsulRegistrationConfig{
+	CheckInterval:                  15 * time.Second,
+	CheckTimeout:                   5 * time.Second,
+	DeregisterCriticalServiceAfter: 30 * time.Second,
+}
+
+//本进程注册的实例
+type consulRegistration struct {
+	registration *api.AgentServiceRegistration
+	//停止心跳
+	cancel context.CancelFunc
+}
+
 type KitConsulDiscoverClient struct {
-	Host         string
-	Port         int
-	client       consul.Client
-	config       *api.Config
-	mutex        sync.Mutex
+	Host               string
+	Port               int
+	client             consul.Client
+	apiClient          *api.Client
+	config             *api.Config
+	registrationConfig ConsulRegistrationConfig
+	logger             *log.Logger
+	mutex              sync.Mutex
+	//实例ID -> 本进程注册的实例
+	registrations map[string]*consulRegistration
+	//服务名 -> 健康实例列表
 	instancesMap sync.Map
+	//服务名 -> 监控服务实例变化的watch
+	plans    map[string]*watch.Plan
+	watchers instanceWatchers
 }
 
-func NewKitDiscoverClient(consulHost string, consulPort int) (DiscoveryClient, error) {
+func NewKitDiscoverClient(consulHost string, consulPort int, registrationConfig ConsulRegistrationConfig, logger *log.Logger) (*KitConsulDiscoverClient, error) {
 	//通过host和port,组成config创建一个client
 	consulConfig := api.DefaultConfig()
 	consulConfig.Address = consulHost + ":" + strconv.Itoa(consulPort)
@@ -28,126 +71,320 @@ func NewKitDiscoverClient(consulHost string, consulPort int) (DiscoveryClient, e
 	}
 	client := consul.NewClient(apiClient)
 	return &KitConsulDiscoverClient{
-		Host:   consulHost,
-		Port:   consulPort,
-		config: consulConfig,
-		client: client,
+		Host:               consulHost,
+		Port:               consulPort,
+		config:             consulConfig,
+		client:             client,
+		apiClient:          apiClient,
+		registrationConfig: registrationConfig,
+		logger:             logger,
+		registrations:      make(map[string]*consulRegistration),
+		plans:              make(map[string]*watch.Plan),
 	}, err
 }
 
 //基于kit的consul服务注册
-func (consulC *KitConsulDiscoverClient) Register(serviceName, instanceId, healthCheckUrl string, instanceHost string, instancePort int, meta map[string]string, logger *log.Logger) bool {
+//注册成功后启动心跳：TTL检查时定期上报健康状态，并在consul丢失实例（如agent重启）时重新注册
+func (consulC *KitConsulDiscoverClient) Register(ctx context.Context, instance *ServiceInstance, healthCheckUrl string) error {
 	//构建服务实例元数据
 	serviceRegistration := &api.AgentServiceRegistration{
-		ID:      instanceId,
-		Name:    serviceName,
-		Address: instanceHost,
-		Port:    instancePort,
-		Meta:    meta,
-		Check: &api.AgentServiceCheck{
-			DeregisterCriticalServiceAfter: "30s",
-			HTTP:                           "http://" + instanceHost + ":" + strconv.Itoa(instancePort) + healthCheckUrl,
-			Interval:                       "15s",
-		},
+		ID:      instance.ID,
+		Name:    instance.Name,
+		Tags:    instance.Tags,
+		Address: instance.Host,
+		Port:    instance.Port,
+		Meta:    consulServiceMeta(instance),
+		Check:   consulC.check(instance, healthCheckUrl),
+	}
+	if instance.Weight > 0 {
+		serviceRegistration.Weights = &api.AgentWeights{
+			Passing: instance.Weight,
+			Warning: 1,
+		}
 	}
 
 	//发送服务注册到 consul
-	err := consulC.client.Register(serviceRegistration)
-	if err != nil {
-		log.Println("register service error")
-		return false
+	if err := consulC.register(ctx, serviceRegistration); err != nil {
+		return err
 	}
 
-	log.Println("register service success")
-	return true
+	heartbeatCtx, cancel := context.WithCancel(context.Background())
+	consulC.mutex.Lock()
+	if previous, ok := consulC.registrations[instance.ID]; ok {
+		previous.cancel()
+	}
+	consulC.registrations[instance.ID] = &consulRegistration{
+		registration: serviceRegistration,
+		cancel:       cancel,
+	}
+	consulC.mutex.Unlock()
+	go consulC.heartbeat(heartbeatCtx, serviceRegistration)
+	return nil
 }
 
 //基于kit的consul注销
-func (consulC *KitConsulDiscoverClient) Deregister(instanceId string, logger *log.Logger) bool {
-	//构建包含服务实例ID的元数据
-	serviceRegisteration := &api.AgentServiceRegistration{
-		ID: instanceId,
+//配置了DrainTimeout时先进入维护模式，调用方的健康实例列表中不再包含该实例，等待后再注销
+func (consulC *KitConsulDiscoverClient) Deregister(ctx context.Context, instanceId string) error {
+	consulC.mutex.Lock()
+	registration, ok := consulC.registrations[instanceId]
+	delete(consulC.registrations, instanceId)
+	consulC.mutex.Unlock()
+	if ok {
+		registration.cancel()
 	}
 
-	//发送服务注销到consul
-	err := consulC.client.Deregister(serviceRegisteration)
-	if err != nil {
-		logger.Println("deregister service err")
-		return false
+	agent := consulC.apiClient.Agent()
+	if drain := consulC.registrationConfig.DrainTimeout; drain > 0 {
+		if err := agent.EnableServiceMaintenanceOpts(instanceId, "draining", (&api.QueryOptions{}).WithContext(ctx)); err != nil {
+			consulC.logger.Println("enable service maintenance error:", err)
+		} else {
+			select {
+			case <-time.After(drain):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	//发送服务注销到consul，等待drain后ctx可能已经结束，因此不使用ctx
+	return agent.ServiceDeregister(instanceId)
+}
+
+//构建健康检查
+func (consulC *KitConsulDiscoverClient) check(instance *ServiceInstance, healthCheckUrl string) *api.AgentServiceCheck {
+	config := consulC.registrationConfig
+	check := &api.AgentServiceCheck{
+		CheckID: "service:" + instance.ID,
+	}
+	if config.DeregisterCriticalServiceAfter > 0 {
+		check.DeregisterCriticalServiceAfter = config.DeregisterCriticalServiceAfter.String()
+	}
+	if config.TTL > 0 {
+		check.TTL = config.TTL.String()
+		return check
+	}
+	check.HTTP = instance.Scheme() + "://" + instance.Address() + healthCheckUrl
+	if instance.Secure {
+		check.TLSSkipVerify = config.TLSSkipVerify
+		check.TLSServerName = config.TLSServerName
+	}
+	check.Interval = config.CheckInterval.String()
+	if config.CheckTimeout > 0 {
+		check.Timeout = config.CheckTimeout.String()
+	}
+	return check
+}
+
+func (consulC *KitConsulDiscoverClient) register(ctx context.Context, registration *api.AgentServiceRegistration) error {
+	opts := api.ServiceRegisterOpts{}.WithContext(ctx)
+	if err := consulC.apiClient.Agent().ServiceRegisterOpts(registration, opts); err != nil {
+		return err
+	}
+	if consulC.registrationConfig.TTL > 0 {
+		//TTL检查注册后处于critical状态，立即上报一次
+		return consulC.updateTTL(ctx, registration.Check.CheckID)
+	}
+	return nil
+}
+
+//按HealthCheck的结果上报TTL检查状态
+func (consulC *KitConsulDiscoverClient) updateTTL(ctx context.Context, checkID string) error {
+	status, output := api.HealthPassing, "ok"
+	if healthCheck := consulC.registrationConfig.HealthCheck; healthCheck != nil && !healthCheck() {
+		status, output = api.HealthCritical, "health check failed"
+	}
+	return consulC.apiClient.Agent().UpdateTTLOpts(checkID, output, status, (&api.QueryOptions{}).WithContext(ctx))
+}
+
+//定期上报TTL或检查实例是否仍在agent中，失败时重新注册，直到ctx结束
+func (consulC *KitConsulDiscoverClient) heartbeat(ctx context.Context, registration *api.AgentServiceRegistration) {
+	interval := consulC.registrationConfig.CheckInterval
+	if ttl := consulC.registrationConfig.TTL; ttl > 0 {
+		//在TTL内至少上报两次，容忍一次失败
+		interval = ttl / 3
+	}
+	if interval <= 0 {
+		interval = DefaultConsulRegistrationConfig.CheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var err error
+		if consulC.registrationConfig.TTL > 0 {
+			err = consulC.updateTTL(ctx, registration.Check.CheckID)
+		} else {
+			_, _, err = consulC.apiClient.Agent().Service(registration.ID, (&api.QueryOptions{}).WithContext(ctx))
+		}
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		//agent重启或实例被注销后，实例和检查都已丢失，重新注册
+		consulC.logger.Println("service heartbeat error, register again:", err)
+		if err := consulC.register(ctx, registration); err != nil {
+			consulC.logger.Println("register service error:", err)
+		}
 	}
-	log.Println("deregister service success")
-	return true
 }
 
 //基于kit的服务发现
-func (consulC *KitConsulDiscoverClient) DiscoverServices(serviceName string, logger *log.Logger) []interface{} {
+func (consulC *KitConsulDiscoverClient) DiscoverServices(ctx context.Context, serviceName string) ([]*ServiceInstance, error) {
 	//该服务已监控并缓存
 	instanceList, ok := consulC.instancesMap.Load(serviceName)
 	if ok {
-		return instanceList.([]interface{})
+		return instanceList.([]*ServiceInstance), nil
 	}
 
 	//无缓存时
 	consulC.mutex.Lock()
+	defer consulC.mutex.Unlock()
 	//再次检查是否监控
 	instanceList, ok = consulC.instancesMap.Load(serviceName)
 	if ok {
-		return instanceList.([]interface{})
-	} else {
-		//注册并run 一个watch
-		go func() {
-			//使用consul服务实例来监控某个服务名的服务实例是否变化
-			params := make(map[string]interface{})
-			params["type"] = "service"
-			params["service"] = serviceName
-			//保留处理程序是为了向后兼容，但仅支持基于
-			//在索引参数上。 要支持基于哈希的监视，请设置HybridHandler。
-			plan, _ := watch.Parse(params)
-			plan.Handler = func(u uint64, i interface{}) {
-				if i == nil {
-					return
-				}
-				v, ok := i.([]*api.ServiceEntry)
-				if !ok {
-					return //数据异常
-				}
-				//没有服务实例在线
-				if len(v) == 0 {
-					consulC.instancesMap.Store(serviceName, []interface{}{})
-				}
-
-				var healthServices []interface{}
-				for _, service := range v {
-					//maintenance > critical > warning > passing
-					//当服务实例的状态为passing时
-					if service.Checks.AggregatedStatus() == api.HealthPassing {
-						//将此实例加入健康实例列表中
-						healthServices = append(healthServices, service.Service)
-					}
-				}
-				consulC.instancesMap.Store(serviceName, healthServices)
-			}
-			defer plan.Stop()
-			//run a watch plan
-			plan.Run(consulC.config.Address)
-
-		}()
+		return instanceList.([]*ServiceInstance), nil
 	}
-	defer consulC.mutex.Unlock()
 
-	//根据服务名请求服务列表
-	entries, _, err := consulC.client.Service(serviceName, "", false, nil)
+	//根据服务名请求健康的服务列表
+	entries, _, err := consulC.client.Service(serviceName, "", true, (&api.QueryOptions{}).WithContext(ctx))
 	if err != nil {
-		//没有可用的服务实例,注册此服务名称
-		consulC.instancesMap.Store(serviceName, []interface{}{})
-		logger.Println("discover service error")
-		return nil
+		return nil, err
 	}
-
-	instances := make([]interface{}, len(entries))
+	instances := make([]*ServiceInstance, len(entries))
 	for i := 0; i < len(instances); i++ {
-		instances[i] = entries[i].Service
+		instances[i] = newConsulServiceInstance(entries[i])
 	}
 	consulC.instancesMap.Store(serviceName, instances)
-	return instances
+
+	if err := consulC.watch(serviceName); err != nil {
+		//监控失败时不缓存，下次发现时重试
+		consulC.instancesMap.Delete(serviceName)
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (consulC *KitConsulDiscoverClient) Watch(ctx context.Context, serviceName string) (<-chan []*ServiceInstance, error) {
+	instances, err := consulC.DiscoverServices(ctx, serviceName)
+	if err != nil {
+		return nil, err
+	}
+	return consulC.watchers.add(ctx, serviceName, instances), nil
+}
+
+//停止全部心跳和watch，并关闭订阅通道
+func (consulC *KitConsulDiscoverClient) Close() error {
+	consulC.mutex.Lock()
+	for _, registration := range consulC.registrations {
+		registration.cancel()
+	}
+	for serviceName, plan := range consulC.plans {
+		plan.Stop()
+		delete(consulC.plans, serviceName)
+		consulC.instancesMap.Delete(serviceName)
+	}
+	consulC.mutex.Unlock()
+	consulC.watchers.close()
+	return nil
+}
+
+//使用consul的watch监控某个服务名的服务实例是否变化，调用方需持有锁
+func (consulC *KitConsulDiscoverClient) watch(serviceName string) error {
+	params := make(map[string]interface{})
+	params["type"] = "service"
+	params["service"] = serviceName
+	params["passingonly"] = true
+	plan, err := watch.Parse(params)
+	if err != nil {
+		return err
+	}
+	//保留处理程序是为了向后兼容，但仅支持基于
+	//在索引参数上。 要支持基于哈希的监视，请设置HybridHandler。
+	plan.Handler = func(u uint64, i interface{}) {
+		if i == nil {
+			return
+		}
+		v, ok := i.([]*api.ServiceEntry)
+		if !ok {
+			return //数据异常
+		}
+
+		healthServices := make([]*ServiceInstance, 0, len(v))
+		for _, service := range v {
+			//maintenance > critical > warning > passing
+			//当服务实例的状态为passing时
+			if service.Checks.AggregatedStatus() == api.HealthPassing {
+				//将此实例加入健康实例列表中
+				healthServices = append(healthServices, newConsulServiceInstance(service))
+			}
+		}
+		consulC.instancesMap.Store(serviceName, healthServices)
+		consulC.watchers.notify(serviceName, healthServices)
+	}
+	consulC.plans[serviceName] = plan
+
+	//run a watch plan，Close时通过plan.Stop结束
+	go func() {
+		if err := plan.RunWithClientAndHclog(consulC.apiClient, nil); err != nil {
+			consulC.logger.Println("watch service error:", err)
+		}
+		consulC.mutex.Lock()
+		if consulC.plans[serviceName] == plan {
+			//watch异常结束，清除缓存以便下次发现时重新建立
+			delete(consulC.plans, serviceName)
+			consulC.instancesMap.Delete(serviceName)
+		}
+		consulC.mutex.Unlock()
+	}()
+	return nil
+}
+
+//将consul的服务条目转换为ServiceInstance
+func newConsulServiceInstance(entry *api.ServiceEntry) *ServiceInstance {
+	service := entry.Service
+	host := service.Address
+	if host == "" && entry.Node != nil {
+		//服务未指定地址时使用所在节点的地址
+		host = entry.Node.Address
+	}
+	return &ServiceInstance{
+		ID:      service.ID,
+		Name:    service.Service,
+		Host:    host,
+		Port:    service.Port,
+		Tags:    service.Tags,
+		Meta:    service.Meta,
+		Weight:  consulServiceWeight(service),
+		Healthy: entry.Checks.AggregatedStatus() == api.HealthPassing,
+		Secure:  service.Meta[SecureMetaKey] == "true",
+	}
+}
+
+//consul的服务没有协议字段，使用HTTPS的实例在元数据中标记
+func consulServiceMeta(instance *ServiceInstance) map[string]string {
+	if !instance.Secure {
+		return instance.Meta
+	}
+	meta := make(map[string]string, len(instance.Meta)+1)
+	for key, value := range instance.Meta {
+		meta[key] = value
+	}
+	meta[SecureMetaKey] = "true"
+	return meta
+}
+
+//获取实例的权重，优先使用元数据中的weight，其次使用consul的Weights.Passing，默认为1
+func consulServiceWeight(service *api.AgentService) int {
+	if value, ok := service.Meta[WeightMetaKey]; ok {
+		if weight, err := strconv.Atoi(value); err == nil {
+			return weight
+		}
+	}
+	if service.Weights.Passing > 0 {
+		return service.Weights.Passing
+	}
+	return 1
 }
@@ -0,0 +1,530 @@
+package discover
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/hashicorp/consul/api"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+模拟consul agent的HTTP接口，只实现KitConsulDiscoverClient用到的部分
+健康服务查询支持阻塞查询，实例或检查状态变化时返回
+*/
+type fakeConsul struct {
+	mutex sync.Mutex
+	index uint64
+	//实例或检查状态变化时关闭并替换，唤醒阻塞查询
+	changed  chan struct{}
+	services map[string]*api.AgentServiceRegistration
+	//检查ID -> 状态
+	checks map[string]string
+	//处于维护模式的实例ID
+	maintenance map[string]bool
+	server      *httptest.Server
+}
+
+func newFakeConsul(t *testing.T) *fakeConsul {
+	f := &fakeConsul{
+		index:       1,
+		changed:     make(chan struct{}),
+		services:    make(map[string]*api.AgentServiceRegistration),
+		checks:      make(map[string]string),
+		maintenance: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/service/register", f.register)
+	mux.HandleFunc("/v1/agent/service/deregister/", f.deregister)
+	mux.HandleFunc("/v1/agent/service/maintenance/", f.enableMaintenance)
+	mux.HandleFunc("/v1/agent/service/", f.service)
+	mux.HandleFunc("/v1/agent/check/update/", f.updateCheck)
+	mux.HandleFunc("/v1/health/service/", f.health)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+//连接fakeConsul的客户端，测试结束时关闭
+func (f *fakeConsul) newClient(t *testing.T, config ConsulRegistrationConfig) *KitConsulDiscoverClient {
+	t.Helper()
+	address, err := url.Parse(f.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(address.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewKitDiscoverClient(address.Hostname(), port, config, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+//调用方需持有锁
+func (f *fakeConsul) bump() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsul) register(w http.ResponseWriter, r *http.Request) {
+	registration := &api.AgentServiceRegistration{}
+	if err := json.NewDecoder(r.Body).Decode(registration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.services[registration.ID] = registration
+	if check := registration.Check; check != nil {
+		//TTL检查注册后处于critical状态，HTTP检查视为已通过
+		status := api.HealthPassing
+		if check.TTL != "" {
+			status = api.HealthCritical
+		}
+		f.checks[check.CheckID] = status
+	}
+	f.bump()
+}
+
+func (f *fakeConsul) deregister(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	registration, ok := f.services[id]
+	if !ok {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	delete(f.services, id)
+	delete(f.maintenance, id)
+	if registration.Check != nil {
+		delete(f.checks, registration.Check.CheckID)
+	}
+	f.bump()
+}
+
+func (f *fakeConsul) enableMaintenance(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/maintenance/")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.services[id]; !ok {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	f.maintenance[id] = r.URL.Query().Get("enable") == "true"
+	f.bump()
+}
+
+func (f *fakeConsul) service(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/")
+	f.mutex.Lock()
+	registration, ok := f.services[id]
+	f.mutex.Unlock()
+	if !ok {
+		http.Error(w, "unknown service", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(&api.AgentService{ID: registration.ID, Service: registration.Name})
+}
+
+func (f *fakeConsul) updateCheck(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")
+	update := struct{ Status, Output string }{}
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.checks[id]; !ok {
+		http.Error(w, "unknown check", http.StatusNotFound)
+		return
+	}
+	f.checks[id] = update.Status
+	f.bump()
+}
+
+//模拟agent重启，已注册的实例和检查全部丢失
+func (f *fakeConsul) restart() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.services = make(map[string]*api.AgentServiceRegistration)
+	f.checks = make(map[string]string)
+	f.maintenance = make(map[string]bool)
+	f.bump()
+}
+
+//实例的检查状态，实例未注册时返回false
+func (f *fakeConsul) status(id string) (string, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	registration, ok := f.services[id]
+	if !ok {
+		return "", false
+	}
+	if f.maintenance[id] {
+		return api.HealthMaint, true
+	}
+	return f.checks[registration.Check.CheckID], true
+}
+
+//等待实例的检查进入期望的状态
+func (f *fakeConsul) waitStatus(t *testing.T, id, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, _ := f.status(id)
+		if status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status of %s = %q, want %q", id, status, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *fakeConsul) health(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	if index, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64); err == nil {
+		//阻塞查询，直到索引变化或请求取消
+		f.mutex.Lock()
+		current, changed := f.index, f.changed
+		f.mutex.Unlock()
+		if index >= current {
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+
+	f.mutex.Lock()
+	entries := make([]*api.ServiceEntry, 0)
+	for _, registration := range f.services {
+		if registration.Name != name {
+			continue
+		}
+		status := api.HealthPassing
+		if registration.Check != nil {
+			status = f.checks[registration.Check.CheckID]
+		}
+		if f.maintenance[registration.ID] {
+			status = api.HealthMaint
+		}
+		if r.URL.Query().Get(api.HealthPassing) == "1" && status != api.HealthPassing {
+			continue
+		}
+		service := &api.AgentService{
+			ID:      registration.ID,
+			Service: registration.Name,
+			Address: registration.Address,
+			Port:    registration.Port,
+			Tags:    registration.Tags,
+			Meta:    registration.Meta,
+		}
+		if registration.Weights != nil {
+			service.Weights = *registration.Weights
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Address: "127.0.0.1"},
+			Service: service,
+			Checks:  api.HealthChecks{{Status: status}},
+		})
+	}
+	index := f.index
+	f.mutex.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	json.NewEncoder(w).Encode(entries)
+}
+
+//watch首次查询的结果可能与订阅时的列表相同，接收到期望的实例为止
+func receiveUntil(t *testing.T, ch <-chan []*ServiceInstance, want ...string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case instances, ok := <-ch:
+			if !ok {
+				t.Fatal("watch channel is closed")
+			}
+			got := ids(instances)
+			sort.Strings(got)
+			if reflect.DeepEqual(got, want) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("instances %v are not received", want)
+		}
+	}
+}
+
+func TestKitDiscoverClientWatch(t *testing.T) {
+	consul := newFakeConsul(t)
+	client := consul.newClient(t, DefaultConsulRegistrationConfig)
+	ctx := context.Background()
+	first := &ServiceInstance{ID: "oauth-1", Name: "oauth", Host: "127.0.0.1", Port: 10098, Weight: 1}
+	if err := client.Register(ctx, first, "/health/ready"); err != nil {
+		t.Fatal(err)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := client.Watch(watchCtx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(receiveInstances(t, ch)); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("initial instances = %v", got)
+	}
+	other, err := client.Watch(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveInstances(t, other)
+
+	//consul中的实例变化后通知订阅者，并更新DiscoverServices的缓存
+	second := &ServiceInstance{ID: "oauth-2", Name: "oauth", Host: "127.0.0.1", Port: 10099, Weight: 1}
+	if err := client.Register(ctx, second, "/health/ready"); err != nil {
+		t.Fatal(err)
+	}
+	receiveUntil(t, ch, "oauth-1", "oauth-2")
+	instances, err := client.DiscoverServices(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 {
+		t.Fatalf("DiscoverServices after Register = %v", ids(instances))
+	}
+
+	//ctx结束后通道关闭，其他订阅者继续收到变化
+	cancel()
+	expectClosed(t, ch)
+	receiveUntil(t, other, "oauth-1", "oauth-2")
+	if err := client.Deregister(ctx, "oauth-2"); err != nil {
+		t.Fatal(err)
+	}
+	receiveUntil(t, other, "oauth-1")
+
+	//Close停止watch并关闭全部通道
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, other)
+	client.mutex.Lock()
+	plans := len(client.plans)
+	client.mutex.Unlock()
+	if plans != 0 {
+		t.Fatalf("%d watch plans are still running after Close", plans)
+	}
+}
+
+//consul不可用时返回错误而不是空列表，也不缓存结果
+func TestKitDiscoverClientErrors(t *testing.T) {
+	consul := newFakeConsul(t)
+	client := consul.newClient(t, DefaultConsulRegistrationConfig)
+	consul.server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := client.DiscoverServices(ctx, "oauth"); err == nil {
+		t.Fatal("DiscoverServices succeeded without consul")
+	}
+	if _, ok := client.instancesMap.Load("oauth"); ok {
+		t.Fatal("failed discovery is cached")
+	}
+	if _, err := client.Watch(ctx, "oauth"); err == nil {
+		t.Fatal("Watch succeeded without consul")
+	}
+	if err := client.Register(ctx, &ServiceInstance{ID: "oauth-1", Name: "oauth", Host: "127.0.0.1", Port: 10098}, "/health/ready"); err == nil {
+		t.Fatal("Register succeeded without consul")
+	}
+	if err := client.Deregister(ctx, "oauth-1"); err == nil {
+		t.Fatal("Deregister succeeded without consul")
+	}
+}
+
+func TestConsulCheck(t *testing.T) {
+	instance := &ServiceInstance{ID: "oauth-1", Name: "oauth", Host: "10.0.0.1", Port: 10098}
+	secure := &ServiceInstance{ID: "oauth-2", Name: "oauth", Host: "10.0.0.2", Port: 10099, Secure: true}
+	tests := []struct {
+		name     string
+		config   ConsulRegistrationConfig
+		instance *ServiceInstance
+		want     *api.AgentServiceCheck
+	}{
+		{
+			"http",
+			DefaultConsulRegistrationConfig,
+			instance,
+			&api.AgentServiceCheck{
+				CheckID: "service:oauth-1", HTTP: "http://10.0.0.1:10098/health/ready",
+				Interval: "15s", Timeout: "5s", DeregisterCriticalServiceAfter: "30s",
+			},
+		},
+		{
+			"https",
+			ConsulRegistrationConfig{CheckInterval: 10 * time.Second, TLSSkipVerify: true, TLSServerName: "oauth.internal"},
+			secure,
+			&api.AgentServiceCheck{
+				CheckID: "service:oauth-2", HTTP: "https://10.0.0.2:10099/health/ready",
+				Interval: "10s", TLSSkipVerify: true, TLSServerName: "oauth.internal",
+			},
+		},
+		{
+			//TTL检查不请求实例
+			"ttl",
+			ConsulRegistrationConfig{TTL: 30 * time.Second, CheckInterval: 10 * time.Second, DeregisterCriticalServiceAfter: time.Minute},
+			instance,
+			&api.AgentServiceCheck{CheckID: "service:oauth-1", TTL: "30s", DeregisterCriticalServiceAfter: "1m0s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &KitConsulDiscoverClient{registrationConfig: tt.config}
+			if got := client.check(tt.instance, "/health/ready"); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("check = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKitDiscoverClientTTLHeartbeat(t *testing.T) {
+	consul := newFakeConsul(t)
+	var mutex sync.Mutex
+	healthy := true
+	config := ConsulRegistrationConfig{
+		TTL:           150 * time.Millisecond,
+		CheckInterval: time.Hour,
+		HealthCheck: func() bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return healthy
+		},
+	}
+	setHealthy := func(value bool) {
+		mutex.Lock()
+		healthy = value
+		mutex.Unlock()
+	}
+	client := consul.newClient(t, config)
+	ctx := context.Background()
+	if err := client.Register(ctx, &ServiceInstance{ID: "oauth-1", Name: "oauth", Host: "127.0.0.1", Port: 10098}, "/health/ready"); err != nil {
+		t.Fatal(err)
+	}
+	//注册后立即上报，不等待第一次心跳
+	if status, _ := consul.status("oauth-1"); status != api.HealthPassing {
+		t.Fatalf("status after Register = %q, want %q", status, api.HealthPassing)
+	}
+
+	//心跳按HealthCheck的结果上报
+	setHealthy(false)
+	consul.waitStatus(t, "oauth-1", api.HealthCritical)
+	setHealthy(true)
+	consul.waitStatus(t, "oauth-1", api.HealthPassing)
+
+	//agent丢失实例后重新注册
+	consul.restart()
+	consul.waitStatus(t, "oauth-1", api.HealthPassing)
+
+	//注销后停止心跳，不再重新注册
+	if err := client.Deregister(ctx, "oauth-1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * config.TTL)
+	if _, ok := consul.status("oauth-1"); ok {
+		t.Fatal("deregistered instance is registered again")
+	}
+}
+
+//HTTP检查时心跳查询agent中的实例，实例丢失后重新注册
+func TestKitDiscoverClientReregister(t *testing.T) {
+	consul := newFakeConsul(t)
+	client := consul.newClient(t, ConsulRegistrationConfig{CheckInterval: 20 * time.Millisecond})
+	if err := client.Register(context.Background(), &ServiceInstance{ID: "oauth-1", Name: "oauth", Host: "127.0.0.1", Port: 10098}, "/health/ready"); err != nil {
+		t.Fatal(err)
+	}
+	consul.restart()
+	consul.waitStatus(t, "oauth-1", api.HealthPassing)
+
+	//Close停止心跳
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	consul.restart()
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := consul.status("oauth-1"); ok {
+		t.Fatal("instance is registered again after Close")
+	}
+}
+
+func TestKitDiscoverClientDrain(t *testing.T) {
+	consul := newFakeConsul(t)
+	drain := 200 * time.Millisecond
+	client := consul.newClient(t, ConsulRegistrationConfig{CheckInterval: time.Hour, DrainTimeout: drain})
+	ctx := context.Background()
+	for _, id := range []string{"oauth-1", "oauth-2"} {
+		if err := client.Register(ctx, &ServiceInstance{ID: id, Name: "oauth", Host: "127.0.0.1", Port: 10098}, "/health/ready"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ch, err := client.Watch(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiveUntil(t, ch, "oauth-1", "oauth-2")
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Deregister(ctx, "oauth-1")
+	}()
+	//drain期间实例仍在agent中，但已不在健康实例列表中
+	receiveUntil(t, ch, "oauth-2")
+	if status, ok := consul.status("oauth-1"); !ok || status != api.HealthMaint {
+		t.Fatalf("status during drain = %q, %v", status, ok)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < drain {
+		t.Fatalf("Deregister returned after %v, want at least %v", elapsed, drain)
+	}
+	if _, ok := consul.status("oauth-1"); ok {
+		t.Fatal("instance is not deregistered after drain")
+	}
+
+	//ctx结束时不再等待drain，仍然注销实例
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	start = time.Now()
+	if err := client.Deregister(cancelled, "oauth-2"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= drain {
+		t.Fatalf("Deregister with a cancelled context waited %v", elapsed)
+	}
+	if _, ok := consul.status("oauth-2"); ok {
+		t.Fatal("instance is not deregistered with a cancelled context")
+	}
+}
@@ -0,0 +1,136 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrInvalidStaticInstance = errors.New("invalid static service instance")
+
+/**
+基于静态实例列表的服务发现，不依赖注册中心，适用于本地开发和无法访问注册中心的环境
+Register注册的实例只保存在本进程内，同样可以被DiscoverServices发现
+*/
+type StaticDiscoveryClient struct {
+	mutex sync.RWMutex
+	//配置的实例
+	instances []*ServiceInstance
+	//本进程注册的实例，实例ID -> 实例
+	registered map[string]*ServiceInstance
+	watchers   instanceWatchers
+}
+
+func NewStaticDiscoveryClient(instances []*ServiceInstance) *StaticDiscoveryClient {
+	return &StaticDiscoveryClient{
+		instances:  instances,
+		registered: make(map[string]*ServiceInstance),
+	}
+}
+
+func (c *StaticDiscoveryClient) Register(ctx context.Context, instance *ServiceInstance, healthCheckUrl string) error {
+	registered := *instance
+	registered.Healthy = true
+	c.mutex.Lock()
+	c.registered[instance.ID] = &registered
+	c.mutex.Unlock()
+	c.notify(instance.Name)
+	return nil
+}
+
+func (c *StaticDiscoveryClient) Deregister(ctx context.Context, instanceId string) error {
+	c.mutex.Lock()
+	instance, ok := c.registered[instanceId]
+	delete(c.registered, instanceId)
+	c.mutex.Unlock()
+	if !ok {
+		return ErrInstanceNotRegistered
+	}
+	c.notify(instance.Name)
+	return nil
+}
+
+func (c *StaticDiscoveryClient) DiscoverServices(ctx context.Context, serviceName string) ([]*ServiceInstance, error) {
+	return c.discover(serviceName), nil
+}
+
+func (c *StaticDiscoveryClient) Watch(ctx context.Context, serviceName string) (<-chan []*ServiceInstance, error) {
+	return c.watchers.add(ctx, serviceName, c.discover(serviceName)), nil
+}
+
+func (c *StaticDiscoveryClient) Close() error {
+	c.watchers.close()
+	return nil
+}
+
+func (c *StaticDiscoveryClient) discover(serviceName string) []*ServiceInstance {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	var instances []*ServiceInstance
+	for _, instance := range c.instances {
+		if instance.Name == serviceName && instance.Healthy {
+			instances = append(instances, instance)
+		}
+	}
+	for _, instance := range c.registered {
+		if instance.Name == serviceName {
+			instances = append(instances, instance)
+		}
+	}
+	return instances
+}
+
+//替换配置的实例列表，并通知订阅者
+func (c *StaticDiscoveryClient) update(instances []*ServiceInstance) {
+	c.mutex.Lock()
+	c.instances = instances
+	c.mutex.Unlock()
+	for _, serviceName := range c.watchers.serviceNames() {
+		c.notify(serviceName)
+	}
+}
+
+func (c *StaticDiscoveryClient) notify(serviceName string) {
+	c.watchers.notify(serviceName, c.discover(serviceName))
+}
+
+/**
+解析静态实例列表，格式为逗号分隔的 服务名=host:port，如
+oauth=127.0.0.1:10098,oauth=127.0.0.1:10099
+使用HTTPS的实例在地址前加上https://，如 oauth=https://127.0.0.1:10098
+*/
+func ParseStaticInstances(value string) ([]*ServiceInstance, error) {
+	var instances []*ServiceInstance
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, ErrInvalidStaticInstance
+		}
+		address := strings.TrimPrefix(parts[1], "https://")
+		host, portValue, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, ErrInvalidStaticInstance
+		}
+		port, err := strconv.Atoi(portValue)
+		if err != nil {
+			return nil, ErrInvalidStaticInstance
+		}
+		instances = append(instances, &ServiceInstance{
+			ID:      parts[0] + "-" + address,
+			Name:    parts[0],
+			Host:    host,
+			Port:    port,
+			Weight:  1,
+			Healthy: true,
+			Secure:  address != parts[1],
+		})
+	}
+	return instances, nil
+}
@@ -0,0 +1,86 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseStaticInstances(t *testing.T) {
+	instances, err := ParseStaticInstances(" oauth=127.0.0.1:10098, oauth=https://[::1]:10099,,orders=orders.internal:8080 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*ServiceInstance{
+		{ID: "oauth-127.0.0.1:10098", Name: "oauth", Host: "127.0.0.1", Port: 10098, Weight: 1, Healthy: true},
+		{ID: "oauth-[::1]:10099", Name: "oauth", Host: "::1", Port: 10099, Weight: 1, Healthy: true, Secure: true},
+		{ID: "orders-orders.internal:8080", Name: "orders", Host: "orders.internal", Port: 8080, Weight: 1, Healthy: true},
+	}
+	if !reflect.DeepEqual(instances, want) {
+		t.Fatalf("ParseStaticInstances = %+v, want %+v", instances, want)
+	}
+	if instances, err := ParseStaticInstances(""); err != nil || len(instances) != 0 {
+		t.Fatalf("empty list = %v, %v", instances, err)
+	}
+
+	for _, value := range []string{
+		"127.0.0.1:10098",
+		"=127.0.0.1:10098",
+		"oauth=127.0.0.1",
+		"oauth=127.0.0.1:http",
+		"oauth=127.0.0.1:10098,orders",
+	} {
+		if _, err := ParseStaticInstances(value); err != ErrInvalidStaticInstance {
+			t.Errorf("ParseStaticInstances(%q) error = %v, want %v", value, err, ErrInvalidStaticInstance)
+		}
+	}
+}
+
+func TestStaticDiscoveryClient(t *testing.T) {
+	ctx := context.Background()
+	c := NewStaticDiscoveryClient([]*ServiceInstance{
+		{ID: "oauth-1", Name: "oauth", Healthy: true},
+		{ID: "oauth-2", Name: "oauth", Healthy: false},
+		{ID: "orders-1", Name: "orders", Healthy: true},
+	})
+	defer c.Close()
+
+	//只返回指定服务的健康实例
+	if got := instanceIds(t, c, "oauth"); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("oauth instances = %v", got)
+	}
+
+	//本进程注册的实例视为健康
+	if err := c.Register(ctx, &ServiceInstance{ID: "oauth-3", Name: "oauth"}, "/health"); err != nil {
+		t.Fatal(err)
+	}
+	if got := instanceIds(t, c, "oauth"); !reflect.DeepEqual(got, []string{"oauth-1", "oauth-3"}) {
+		t.Fatalf("oauth instances after Register = %v", got)
+	}
+	if err := c.Deregister(ctx, "oauth-3"); err != nil {
+		t.Fatal(err)
+	}
+	if got := instanceIds(t, c, "oauth"); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("oauth instances after Deregister = %v", got)
+	}
+	if err := c.Deregister(ctx, "oauth-3"); !errors.Is(err, ErrInstanceNotRegistered) {
+		t.Fatalf("Deregister twice error = %v, want %v", err, ErrInstanceNotRegistered)
+	}
+	if got := instanceIds(t, c, "unknown"); len(got) != 0 {
+		t.Fatalf("unknown service instances = %v", got)
+	}
+}
+
+func instanceIds(t *testing.T, c DiscoveryClient, serviceName string) []string {
+	t.Helper()
+	instances, err := c.DiscoverServices(context.Background(), serviceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	return ids
+}
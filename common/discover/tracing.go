@@ -0,0 +1,58 @@
+package discover
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"security/common/tracing"
+)
+
+//为服务注册、注销和发现创建span的装饰器
+type TracingDiscoveryClient struct {
+	next DiscoveryClient
+}
+
+func NewTracingDiscoveryClient(next DiscoveryClient) DiscoveryClient {
+	return &TracingDiscoveryClient{next: next}
+}
+
+func (c *TracingDiscoveryClient) Register(ctx context.Context, instance *ServiceInstance, healthCheckUrl string) (err error) {
+	ctx, span := tracing.Start(ctx, "DiscoveryClient.Register",
+		attribute.String("service.name", instance.Name),
+		attribute.String("service.instance.id", instance.ID),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return c.next.Register(ctx, instance, healthCheckUrl)
+}
+
+func (c *TracingDiscoveryClient) Deregister(ctx context.Context, instanceId string) (err error) {
+	ctx, span := tracing.Start(ctx, "DiscoveryClient.Deregister", attribute.String("service.instance.id", instanceId))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return c.next.Deregister(ctx, instanceId)
+}
+
+func (c *TracingDiscoveryClient) DiscoverServices(ctx context.Context, serviceName string) (instances []*ServiceInstance, err error) {
+	ctx, span := tracing.Start(ctx, "DiscoveryClient.DiscoverServices", attribute.String("service.name", serviceName))
+	defer func() {
+		span.SetAttributes(attribute.Int("service.instances", len(instances)))
+		tracing.End(span, err)
+	}()
+	return c.next.DiscoverServices(ctx, serviceName)
+}
+
+//span只覆盖订阅的建立，不包括之后的通知
+func (c *TracingDiscoveryClient) Watch(ctx context.Context, serviceName string) (instances <-chan []*ServiceInstance, err error) {
+	_, span := tracing.Start(ctx, "DiscoveryClient.Watch", attribute.String("service.name", serviceName))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	//订阅的生命周期由调用方的ctx决定，不使用span的ctx以免后台的请求挂在已结束的span下
+	return c.next.Watch(ctx, serviceName)
+}
+
+func (c *TracingDiscoveryClient) Close() error {
+	return c.next.Close()
+}
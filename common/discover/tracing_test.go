@@ -0,0 +1,46 @@
+package discover
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/codes"
+	"security/common/tracing"
+	"testing"
+)
+
+func TestTracingDiscoveryClient(t *testing.T) {
+	provider, exporter := tracing.NewInMemoryTracerProvider("security-test")
+	tracing.Install(provider)
+	defer provider.Shutdown(context.Background())
+
+	ctx := context.Background()
+	c := NewTracingDiscoveryClient(NewStaticDiscoveryClient([]*ServiceInstance{{ID: "oauth-1", Name: "oauth", Healthy: true}}))
+	defer c.Close()
+	if _, err := c.DiscoverServices(ctx, "oauth"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Deregister(ctx, "oauth-2"); err != ErrInstanceNotRegistered {
+		t.Fatalf("Deregister error = %v, want %v", err, ErrInstanceNotRegistered)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans are recorded, want 2", len(spans))
+	}
+	discover, deregister := spans[0], spans[1]
+	if discover.Name != "DiscoveryClient.DiscoverServices" || discover.Status.Code == codes.Error {
+		t.Fatalf("discover span = %s %+v", discover.Name, discover.Status)
+	}
+	//记录发现的实例数量
+	var found bool
+	for _, kv := range discover.Attributes {
+		if kv.Key == "service.instances" && kv.Value.AsInt64() == 1 {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("discover attributes = %v", discover.Attributes)
+	}
+	if deregister.Name != "DiscoveryClient.Deregister" || deregister.Status.Code != codes.Error {
+		t.Fatalf("deregister span = %s %+v", deregister.Name, deregister.Status)
+	}
+}
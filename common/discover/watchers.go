@@ -0,0 +1,89 @@
+package discover
+
+import (
+	"context"
+	"sync"
+)
+
+//服务实例变化的订阅者，供各个DiscoveryClient实现Watch
+type instanceWatchers struct {
+	mutex sync.Mutex
+	//服务名 -> 订阅通道
+	watchers map[string]map[chan []*ServiceInstance]struct{}
+}
+
+//添加订阅者，先发送当前的实例列表，ctx结束后移除并关闭通道
+func (w *instanceWatchers) add(ctx context.Context, serviceName string, current []*ServiceInstance) <-chan []*ServiceInstance {
+	ch := make(chan []*ServiceInstance, 1)
+	ch <- current
+
+	w.mutex.Lock()
+	if w.watchers == nil {
+		w.watchers = make(map[string]map[chan []*ServiceInstance]struct{})
+	}
+	if w.watchers[serviceName] == nil {
+		w.watchers[serviceName] = make(map[chan []*ServiceInstance]struct{})
+	}
+	w.watchers[serviceName][ch] = struct{}{}
+	w.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+		if _, ok := w.watchers[serviceName][ch]; ok {
+			delete(w.watchers[serviceName], ch)
+			close(ch)
+		}
+	}()
+	return ch
+}
+
+//通知服务的全部订阅者，未被消费的旧列表会被替换
+func (w *instanceWatchers) notify(serviceName string, instances []*ServiceInstance) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for ch := range w.watchers[serviceName] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- instances
+	}
+}
+
+//有订阅者的服务名
+func (w *instanceWatchers) serviceNames() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	names := make([]string, 0, len(w.watchers))
+	for name, watchers := range w.watchers {
+		if len(watchers) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+//关闭全部订阅通道
+func (w *instanceWatchers) close() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, watchers := range w.watchers {
+		for ch := range watchers {
+			close(ch)
+		}
+	}
+	w.watchers = nil
+}
+
+//过滤出健康的实例
+func healthyInstances(instances []*ServiceInstance) []*ServiceInstance {
+	healthy := make([]*ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		if instance.Healthy {
+			healthy = append(healthy, instance)
+		}
+	}
+	return healthy
+}
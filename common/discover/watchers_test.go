@@ -0,0 +1,145 @@
+package discover
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//在超时前从通道中接收一个实例列表
+func receiveInstances(t *testing.T, ch <-chan []*ServiceInstance) []*ServiceInstance {
+	t.Helper()
+	select {
+	case instances, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel is closed")
+		}
+		return instances
+	case <-time.After(5 * time.Second):
+		t.Fatal("no instances are received")
+	}
+	return nil
+}
+
+func expectClosed(t *testing.T, ch <-chan []*ServiceInstance) {
+	t.Helper()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("watch channel is not closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch channel is not closed")
+	}
+}
+
+func expectNoInstances(t *testing.T, ch <-chan []*ServiceInstance) {
+	t.Helper()
+	select {
+	case instances := <-ch:
+		t.Fatalf("unexpected instances %v", instances)
+	default:
+	}
+}
+
+func ids(instances []*ServiceInstance) []string {
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID)
+	}
+	return ids
+}
+
+func TestInstanceWatchers(t *testing.T) {
+	var w instanceWatchers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := []*ServiceInstance{{ID: "oauth-1"}}
+	ch := w.add(ctx, "oauth", first)
+	other := w.add(context.Background(), "orders", nil)
+
+	//先收到当前的实例列表
+	if got := receiveInstances(t, ch); !reflect.DeepEqual(got, first) {
+		t.Fatalf("initial instances = %v", ids(got))
+	}
+	if got := receiveInstances(t, other); len(got) != 0 {
+		t.Fatalf("initial orders instances = %v", ids(got))
+	}
+
+	//未被消费的旧列表被替换，只保留最新的
+	w.notify("oauth", []*ServiceInstance{{ID: "oauth-2"}})
+	w.notify("oauth", []*ServiceInstance{{ID: "oauth-3"}})
+	if got := receiveInstances(t, ch); !reflect.DeepEqual(ids(got), []string{"oauth-3"}) {
+		t.Fatalf("instances after notify = %v", ids(got))
+	}
+	expectNoInstances(t, ch)
+	//其他服务的订阅者不受影响
+	expectNoInstances(t, other)
+
+	//ctx结束后通道关闭，不再被通知
+	cancel()
+	expectClosed(t, ch)
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(w.serviceNames(), []string{"orders"}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("serviceNames = %v, want [orders]", w.serviceNames())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.notify("oauth", first)
+
+	//close关闭全部通道
+	w.close()
+	expectClosed(t, other)
+	if names := w.serviceNames(); len(names) != 0 {
+		t.Fatalf("serviceNames after close = %v", names)
+	}
+}
+
+func TestStaticDiscoveryClientWatch(t *testing.T) {
+	ctx := context.Background()
+	c := NewStaticDiscoveryClient([]*ServiceInstance{{ID: "oauth-1", Name: "oauth", Healthy: true}})
+	ch, err := c.Watch(ctx, "oauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(receiveInstances(t, ch)); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("initial instances = %v", got)
+	}
+
+	if err := c.Register(ctx, &ServiceInstance{ID: "oauth-2", Name: "oauth"}, "/health"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(receiveInstances(t, ch)); !reflect.DeepEqual(got, []string{"oauth-1", "oauth-2"}) {
+		t.Fatalf("instances after Register = %v", got)
+	}
+	//其他服务的注册不通知
+	if err := c.Register(ctx, &ServiceInstance{ID: "orders-1", Name: "orders"}, "/health"); err != nil {
+		t.Fatal(err)
+	}
+	expectNoInstances(t, ch)
+
+	if err := c.Deregister(ctx, "oauth-2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids(receiveInstances(t, ch)); !reflect.DeepEqual(got, []string{"oauth-1"}) {
+		t.Fatalf("instances after Deregister = %v", got)
+	}
+	//注销未注册的实例返回错误，也不通知
+	if err := c.Deregister(ctx, "oauth-2"); err != ErrInstanceNotRegistered {
+		t.Fatalf("Deregister unknown instance error = %v, want %v", err, ErrInstanceNotRegistered)
+	}
+	expectNoInstances(t, ch)
+
+	//替换配置的实例时通知，不健康的实例被过滤
+	c.update([]*ServiceInstance{{ID: "oauth-3", Name: "oauth", Healthy: true}, {ID: "oauth-4", Name: "oauth"}})
+	if got := ids(receiveInstances(t, ch)); !reflect.DeepEqual(got, []string{"oauth-3"}) {
+		t.Fatalf("instances after update = %v", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expectClosed(t, ch)
+}
@@ -0,0 +1,104 @@
+package loadbalance
+
+import (
+	"context"
+	"hash/crc32"
+	"math/rand"
+	"security/common/discover"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//每个实例在哈希环上的虚拟节点数
+const DefaultReplicas = 160
+
+/**
+一致性哈希负载均衡
+根据ctx中的哈希键（如用户ID、令牌值）选择实例，相同键的请求总是落在同一实例上，实例增减时只影响少部分键；
+没有哈希键的请求随机选择
+*/
+type ConsistentHashLoadBalance struct {
+	replicas int
+	mutex    sync.Mutex
+	//当前哈希环对应的实例集合，实例变化时重建
+	signature string
+	hashes    []uint32
+	//虚拟节点 -> 实例ID，选择时再从本次的实例列表中取出实例，使用最新的实例信息
+	nodes map[uint32]string
+}
+
+func NewConsistentHashLoadBalance(replicas int) *ConsistentHashLoadBalance {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &ConsistentHashLoadBalance{
+		replicas: replicas,
+	}
+}
+
+func (lb *ConsistentHashLoadBalance) SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error) {
+	if len(services) == 0 {
+		return nil, ErrNoInstance
+	}
+	key, ok := HashKeyFromContext(ctx)
+	if !ok {
+		return services[rand.Intn(len(services))], nil
+	}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	lb.build(services)
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(lb.hashes), func(i int) bool {
+		return lb.hashes[i] >= hash
+	})
+	if i == len(lb.hashes) {
+		i = 0
+	}
+	id := lb.nodes[lb.hashes[i]]
+	for _, service := range services {
+		if service.ID == id {
+			return service, nil
+		}
+	}
+	return nil, ErrNoInstance
+}
+
+func (lb *ConsistentHashLoadBalance) Done(service *discover.ServiceInstance, latency time.Duration, err error) {
+}
+
+//实例集合发生变化时重建哈希环，调用方需持有锁
+func (lb *ConsistentHashLoadBalance) build(services []*discover.ServiceInstance) {
+	ids := make([]string, len(services))
+	for i, service := range services {
+		ids[i] = service.ID
+	}
+	sort.Strings(ids)
+	signature := strings.Join(ids, ",")
+	if signature == lb.signature {
+		return
+	}
+
+	hashes := make([]uint32, 0, len(services)*lb.replicas)
+	nodes := make(map[uint32]string, len(services)*lb.replicas)
+	for _, service := range services {
+		for i := 0; i < lb.replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(service.ID + "#" + strconv.Itoa(i)))
+			if _, exist := nodes[hash]; exist {
+				continue
+			}
+			nodes[hash] = service.ID
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return hashes[i] < hashes[j]
+	})
+	lb.signature = signature
+	lb.hashes = hashes
+	lb.nodes = nodes
+}
@@ -0,0 +1,85 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"security/common/discover"
+	"testing"
+)
+
+//每个键选中的实例ID
+func hashAssignments(t *testing.T, lb LoadBalance, services []*discover.ServiceInstance, keys int) map[string]string {
+	t.Helper()
+	assignments := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		service, err := lb.SelectService(WithHashKey(context.Background(), key), services)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assignments[key] = service.ID
+	}
+	return assignments
+}
+
+func TestConsistentHashStableAssignment(t *testing.T) {
+	lb := NewConsistentHashLoadBalance(0)
+	before := hashAssignments(t, lb, testInstances(1, 1, 1), 1000)
+
+	//相同的键总是选中同一实例，实例列表刷新不改变结果
+	if again := hashAssignments(t, lb, testInstances(1, 1, 1), 1000); fmt.Sprint(again) != fmt.Sprint(before) {
+		t.Fatal("assignments changed for the same instances")
+	}
+	counts := make(map[string]int)
+	for _, id := range before {
+		counts[id]++
+	}
+	for id, count := range counts {
+		if count < 200 {
+			t.Fatalf("instance %s got %d of 1000 keys, counts %v", id, count, counts)
+		}
+	}
+
+	//c下线后只有原本落在c上的键重新分配
+	after := hashAssignments(t, lb, testInstances(1, 1), 1000)
+	for key, id := range before {
+		if id != "c" && after[key] != id {
+			t.Fatalf("key %s moved from %s to %s", key, id, after[key])
+		}
+		if after[key] == "c" {
+			t.Fatalf("key %s assigned to removed instance", key)
+		}
+	}
+}
+
+func TestConsistentHashWithoutKey(t *testing.T) {
+	lb := NewConsistentHashLoadBalance(0)
+	counts := make(map[rune]int)
+	for _, id := range selectSequence(t, lb, testInstances(1, 1), 100) {
+		counts[id]++
+	}
+	//没有哈希键的请求随机选择
+	if counts['a'] == 0 || counts['b'] == 0 {
+		t.Fatalf("counts = %v, want both instances selected", counts)
+	}
+	if _, err := lb.SelectService(WithHashKey(context.Background(), "user"), nil); err != ErrNoInstance {
+		t.Fatalf("no instance error = %v, want %v", err, ErrNoInstance)
+	}
+}
+
+func TestConsistentHashReturnsLatestInstance(t *testing.T) {
+	lb := NewConsistentHashLoadBalance(0)
+	ctx := WithHashKey(context.Background(), "user-1")
+	if _, err := lb.SelectService(ctx, testInstances(1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	//实例不变时不重建哈希环，但返回本次列表中的实例
+	services := testInstances(1, 1)
+	service, err := lb.SelectService(ctx, services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service != services[0] && service != services[1] {
+		t.Fatalf("selected %p, want an instance from the current list", service)
+	}
+}
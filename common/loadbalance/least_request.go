@@ -0,0 +1,62 @@
+package loadbalance
+
+import (
+	"context"
+	"math/rand"
+	"security/common/discover"
+	"sync"
+	"time"
+)
+
+/**
+最少在途请求负载均衡
+选择当前未完成请求数最少的实例，数量相同时随机选择
+*/
+type LeastRequestLoadBalance struct {
+	mutex sync.Mutex
+	//实例ID -> 在途请求数
+	outstanding map[string]int
+}
+
+func NewLeastRequestLoadBalance() *LeastRequestLoadBalance {
+	return &LeastRequestLoadBalance{
+		outstanding: make(map[string]int),
+	}
+}
+
+func (lb *LeastRequestLoadBalance) SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error) {
+	if len(services) == 0 {
+		return nil, ErrNoInstance
+	}
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	var best *discover.ServiceInstance
+	ties := 0
+	for _, service := range services {
+		switch {
+		case best == nil || lb.outstanding[service.ID] < lb.outstanding[best.ID]:
+			best = service
+			ties = 1
+		case lb.outstanding[service.ID] == lb.outstanding[best.ID]:
+			//蓄水池抽样，在数量相同的实例中等概率选择
+			ties++
+			if rand.Intn(ties) == 0 {
+				best = service
+			}
+		}
+	}
+	lb.outstanding[best.ID]++
+	return best, nil
+}
+
+func (lb *LeastRequestLoadBalance) Done(service *discover.ServiceInstance, latency time.Duration, err error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	if lb.outstanding[service.ID] <= 1 {
+		//没有在途请求时删除，避免已下线实例的记录残留
+		delete(lb.outstanding, service.ID)
+		return
+	}
+	lb.outstanding[service.ID]--
+}
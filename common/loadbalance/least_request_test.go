@@ -0,0 +1,49 @@
+package loadbalance
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLeastRequestSelectsIdleInstance(t *testing.T) {
+	lb := NewLeastRequestLoadBalance()
+	services := testInstances(1, 1, 1)
+	ctx := context.Background()
+
+	//没有完成的请求时依次分散到每个实例
+	counts := make(map[rune]int)
+	for _, id := range selectSequence(t, lb, services, 9) {
+		counts[id]++
+	}
+	if counts['a'] != 3 || counts['b'] != 3 || counts['c'] != 3 {
+		t.Fatalf("counts = %v, want 3 requests on each instance", counts)
+	}
+
+	//b的请求完成后，新的请求发往b
+	for i := 0; i < 2; i++ {
+		lb.Done(services[1], 0, nil)
+	}
+	for i := 0; i < 2; i++ {
+		service, err := lb.SelectService(ctx, services)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if service.ID != "b" {
+			t.Fatalf("selected %s, want b", service.ID)
+		}
+	}
+
+	//所有请求完成后不保留记录
+	for _, service := range services {
+		for i := 0; i < 3; i++ {
+			lb.Done(service, 0, nil)
+		}
+	}
+	if len(lb.outstanding) != 0 {
+		t.Fatalf("outstanding = %v, want empty", lb.outstanding)
+	}
+
+	if _, err := lb.SelectService(ctx, nil); err != ErrNoInstance {
+		t.Fatalf("no instance error = %v, want %v", err, ErrNoInstance)
+	}
+}
@@ -1,14 +1,33 @@
 package loadbalance
 
 import (
+	"context"
 	"errors"
-	"github.com/hashicorp/consul/api"
 	"math/rand"
+	"security/common/discover"
+	"sync"
+	"time"
 )
 
 //负载均衡器
 type LoadBalance interface {
-	SelectService(service []*api.AgentService) (*api.AgentService, error)
+	//从服务实例中选择一个，ctx中可以通过WithHashKey携带请求的哈希键
+	SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error)
+	//请求完成后回报结果，用于统计在途请求数和响应延迟，每次SelectService成功后都必须调用一次
+	Done(service *discover.ServiceInstance, latency time.Duration, err error)
+}
+
+type hashKey struct{}
+
+//为请求设置哈希键，一致性哈希负载均衡会将相同键的请求发往同一实例
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, key)
+}
+
+//获取请求的哈希键
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKey{}).(string)
+	return key, ok
 }
 
 type RandomLoadBalance struct {
@@ -17,12 +36,71 @@ type RandomLoadBalance struct {
 var ErrNoInstance = errors.New("service instance are not existed")
 
 //随机负载均衡
-func (rb *RandomLoadBalance) SelectService(services []*api.AgentService) (*api.AgentService, error) {
+func (rb *RandomLoadBalance) SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error) {
 	if services == nil || len(services) == 0 {
 		return nil, ErrNoInstance
 	}
 	return services[rand.Intn(len(services))], nil
 }
 
+func (rb *RandomLoadBalance) Done(service *discover.ServiceInstance, latency time.Duration, err error) {
+}
+
+/**
+平滑加权轮询负载均衡（与Nginx的实现一致）
+每次选择时，每个实例的当前权重加上自身权重，选出当前权重最大的实例，并将其当前权重减去权重总和；
+这样权重为5、1、1的实例会按a a b a c a a的顺序被选中，而不是连续选中a
+*/
 type WeightRoundRobinLoadBalance struct {
+	mutex sync.Mutex
+	//实例ID -> 当前权重
+	currentWeights map[string]int
+}
+
+func NewWeightRoundRobinLoadBalance() *WeightRoundRobinLoadBalance {
+	return &WeightRoundRobinLoadBalance{
+		currentWeights: make(map[string]int),
+	}
+}
+
+func (wb *WeightRoundRobinLoadBalance) SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error) {
+	if len(services) == 0 {
+		return nil, ErrNoInstance
+	}
+
+	wb.mutex.Lock()
+	defer wb.mutex.Unlock()
+	if wb.currentWeights == nil {
+		wb.currentWeights = make(map[string]int)
+	}
+
+	var best *discover.ServiceInstance
+	total := 0
+	present := make(map[string]bool, len(services))
+	for _, service := range services {
+		weight := service.Weight
+		present[service.ID] = true
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		wb.currentWeights[service.ID] += weight
+		if best == nil || wb.currentWeights[service.ID] > wb.currentWeights[best.ID] {
+			best = service
+		}
+	}
+	//实例列表变化后，清除已下线实例的状态
+	for id := range wb.currentWeights {
+		if !present[id] {
+			delete(wb.currentWeights, id)
+		}
+	}
+	if best == nil {
+		return nil, ErrNoInstance
+	}
+	wb.currentWeights[best.ID] -= total
+	return best, nil
+}
+
+func (wb *WeightRoundRobinLoadBalance) Done(service *discover.ServiceInstance, latency time.Duration, err error) {
 }
@@ -0,0 +1,100 @@
+package loadbalance
+
+import (
+	"context"
+	"security/common/discover"
+	"strings"
+	"testing"
+)
+
+//按id=权重生成实例，每次调用返回新的实例指针，与服务发现刷新后的列表一致
+func testInstances(weights ...int) []*discover.ServiceInstance {
+	services := make([]*discover.ServiceInstance, len(weights))
+	for i, weight := range weights {
+		services[i] = &discover.ServiceInstance{ID: string(rune('a' + i)), Name: "oauth", Weight: weight}
+	}
+	return services
+}
+
+//连续选择n次，返回选中实例ID组成的序列
+func selectSequence(t *testing.T, lb LoadBalance, services []*discover.ServiceInstance, n int) string {
+	t.Helper()
+	var sequence strings.Builder
+	for i := 0; i < n; i++ {
+		service, err := lb.SelectService(context.Background(), services)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sequence.WriteString(service.ID)
+	}
+	return sequence.String()
+}
+
+func TestWeightRoundRobinSmooth(t *testing.T) {
+	lb := NewWeightRoundRobinLoadBalance()
+	//权重为5、1、1时不会连续选中同一实例超过两次，每轮的选中次数与权重一致
+	if sequence := selectSequence(t, lb, testInstances(5, 1, 1), 14); sequence != "aabacaaaabacaa" {
+		t.Fatalf("sequence = %s, want aabacaaaabacaa", sequence)
+	}
+}
+
+func TestWeightRoundRobinDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+		counts  map[rune]int
+	}{
+		{"weighted", []int{3, 2, 1}, map[rune]int{'a': 300, 'b': 200, 'c': 100}},
+		{"equal", []int{1, 1}, map[rune]int{'a': 300, 'b': 300}},
+		//权重小于等于0的实例不会被选中
+		{"zero weight", []int{2, 0, 1}, map[rune]int{'a': 400, 'c': 200}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counts := make(map[rune]int)
+			for _, id := range selectSequence(t, NewWeightRoundRobinLoadBalance(), testInstances(test.weights...), 600) {
+				counts[id]++
+			}
+			if len(counts) != len(test.counts) {
+				t.Fatalf("counts = %v, want %v", counts, test.counts)
+			}
+			for id, count := range test.counts {
+				if counts[id] != count {
+					t.Fatalf("counts = %v, want %v", counts, test.counts)
+				}
+			}
+		})
+	}
+
+	if _, err := NewWeightRoundRobinLoadBalance().SelectService(context.Background(), testInstances(0, -1)); err != ErrNoInstance {
+		t.Fatalf("no positive weight error = %v, want %v", err, ErrNoInstance)
+	}
+}
+
+func TestWeightRoundRobinInstancesChange(t *testing.T) {
+	lb := NewWeightRoundRobinLoadBalance()
+	if sequence := selectSequence(t, lb, testInstances(5, 1, 1), 3); sequence != "aab" {
+		t.Fatalf("sequence = %s, want aab", sequence)
+	}
+
+	//c下线后a、b保留当前权重继续轮询，而不是从头开始（从头开始时为aaab）
+	if sequence := selectSequence(t, lb, testInstances(5, 1), 6); sequence != "aaaaab" {
+		t.Fatalf("sequence after removal = %s, want aaaaab", sequence)
+	}
+	if _, ok := lb.currentWeights["c"]; ok {
+		t.Fatal("state of removed instance is kept")
+	}
+
+	//实例顺序变化时状态仍按实例ID保留，一轮中的选中次数与权重一致
+	lb = NewWeightRoundRobinLoadBalance()
+	services := testInstances(2, 3, 4)
+	reversed := []*discover.ServiceInstance{services[2], services[1], services[0]}
+	sequence := selectSequence(t, lb, testInstances(2, 3, 4), 4) + selectSequence(t, lb, reversed, 5)
+	counts := make(map[rune]int)
+	for _, id := range sequence {
+		counts[id]++
+	}
+	if counts['a'] != 2 || counts['b'] != 3 || counts['c'] != 4 {
+		t.Fatalf("sequence = %s, want a, b, c selected 2, 3, 4 times", sequence)
+	}
+}
@@ -0,0 +1,144 @@
+package loadbalance
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"security/common/discover"
+	"sync"
+	"time"
+)
+
+const (
+	//EWMA的衰减时间常数，越大对历史延迟越敏感
+	p2cDecayTime = 10 * time.Second
+	//请求失败时按该延迟计入，使出错的实例被降权
+	p2cErrorPenalty = 2 * time.Second
+)
+
+/**
+两次随机选择（Power of Two Choices）负载均衡
+随机挑选两个实例，比较 指数加权移动平均延迟 x (在途请求数 + 1)，选择负载较低的一个；
+还没有延迟样本的实例使用其他实例的平均延迟，都没有样本时只比较在途请求数
+*/
+type P2CLoadBalance struct {
+	mutex sync.Mutex
+	stats map[string]*p2cStat
+	now   func() time.Time
+}
+
+type p2cStat struct {
+	//在途请求数
+	outstanding int
+	//延迟的指数加权移动平均，纳秒
+	ewma float64
+	//最后一次更新ewma的时间
+	lastUpdate time.Time
+}
+
+func NewP2CLoadBalance() *P2CLoadBalance {
+	return &P2CLoadBalance{
+		stats: make(map[string]*p2cStat),
+		now:   time.Now,
+	}
+}
+
+func (lb *P2CLoadBalance) SelectService(ctx context.Context, services []*discover.ServiceInstance) (*discover.ServiceInstance, error) {
+	if len(services) == 0 {
+		return nil, ErrNoInstance
+	}
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	best := services[0]
+	if len(services) > 1 {
+		i := rand.Intn(len(services))
+		j := rand.Intn(len(services) - 1)
+		if j >= i {
+			j++
+		}
+		best = services[i]
+		seed := lb.seedEWMA(services)
+		if lb.load(services[j], seed) < lb.load(best, seed) {
+			best = services[j]
+		}
+	}
+	lb.stat(best.ID).outstanding++
+	lb.prune(services)
+	return best, nil
+}
+
+func (lb *P2CLoadBalance) Done(service *discover.ServiceInstance, latency time.Duration, err error) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+	stat := lb.stat(service.ID)
+	if stat.outstanding > 0 {
+		stat.outstanding--
+	}
+	if err != nil && latency < p2cErrorPenalty {
+		latency = p2cErrorPenalty
+	}
+	now := lb.now()
+	if stat.lastUpdate.IsZero() {
+		stat.ewma = float64(latency)
+	} else {
+		//距离上次更新越久，历史值的权重越低
+		beta := math.Exp(-float64(now.Sub(stat.lastUpdate)) / float64(p2cDecayTime))
+		stat.ewma = stat.ewma*beta + float64(latency)*(1-beta)
+	}
+	stat.lastUpdate = now
+}
+
+//seed为没有延迟样本时使用的ewma，调用方需持有锁
+func (lb *P2CLoadBalance) load(service *discover.ServiceInstance, seed float64) float64 {
+	stat, ok := lb.stats[service.ID]
+	if !ok {
+		return seed
+	}
+	ewma := stat.ewma
+	if stat.lastUpdate.IsZero() {
+		ewma = seed
+	}
+	return ewma * float64(stat.outstanding+1)
+}
+
+//有延迟样本的实例的平均ewma，都没有样本时为1，调用方需持有锁
+func (lb *P2CLoadBalance) seedEWMA(services []*discover.ServiceInstance) float64 {
+	sum, count := 0.0, 0
+	for _, service := range services {
+		if stat, ok := lb.stats[service.ID]; ok && !stat.lastUpdate.IsZero() {
+			sum += stat.ewma
+			count++
+		}
+	}
+	if count == 0 || sum == 0 {
+		return 1
+	}
+	return sum / float64(count)
+}
+
+//调用方需持有锁
+func (lb *P2CLoadBalance) stat(id string) *p2cStat {
+	stat, ok := lb.stats[id]
+	if !ok {
+		stat = &p2cStat{}
+		lb.stats[id] = stat
+	}
+	return stat
+}
+
+//清除已下线且没有在途请求的实例，调用方需持有锁
+func (lb *P2CLoadBalance) prune(services []*discover.ServiceInstance) {
+	if len(lb.stats) <= len(services) {
+		return
+	}
+	present := make(map[string]bool, len(services))
+	for _, service := range services {
+		present[service.ID] = true
+	}
+	for id, stat := range lb.stats {
+		if !present[id] && stat.outstanding == 0 {
+			delete(lb.stats, id)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestP2CPrefersLowerLatency(t *testing.T) {
+	lb := NewP2CLoadBalance()
+	services := testInstances(1, 1)
+	lb.Done(services[0], 10*time.Millisecond, nil)
+	lb.Done(services[1], time.Second, nil)
+
+	//a的在途请求数达到两者延迟之比之前，总是选择a
+	if sequence := selectSequence(t, lb, services, 50); sequence != strings.Repeat("a", 50) {
+		t.Fatalf("sequence = %s, want only a", sequence)
+	}
+}
+
+func TestP2CErrorPenalty(t *testing.T) {
+	lb := NewP2CLoadBalance()
+	services := testInstances(1, 1)
+	lb.Done(services[0], 10*time.Millisecond, errors.New("unavailable"))
+	lb.Done(services[1], 100*time.Millisecond, nil)
+
+	//失败的请求按惩罚延迟计入
+	service, err := lb.SelectService(context.Background(), services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.ID != "b" {
+		t.Fatalf("selected %s, want b", service.ID)
+	}
+}
+
+func TestP2CColdStart(t *testing.T) {
+	lb := NewP2CLoadBalance()
+	services := testInstances(1, 1)
+	lb.Done(services[0], 100*time.Millisecond, nil)
+
+	//新实例使用已有实例的平均延迟，与a交替承担请求，而不是接收全部请求
+	counts := make(map[rune]int)
+	for _, id := range selectSequence(t, lb, services, 100) {
+		counts[id]++
+	}
+	if counts['a'] < 49 || counts['b'] < 49 {
+		t.Fatalf("counts = %v, want requests split between a and b", counts)
+	}
+
+	//都没有延迟样本时按在途请求数选择
+	lb = NewP2CLoadBalance()
+	counts = make(map[rune]int)
+	for _, id := range selectSequence(t, lb, services, 100) {
+		counts[id]++
+	}
+	if counts['a'] < 49 || counts['b'] < 49 {
+		t.Fatalf("counts without samples = %v, want requests split between a and b", counts)
+	}
+}
+
+func TestP2CPrunesRemovedInstances(t *testing.T) {
+	lb := NewP2CLoadBalance()
+	services := testInstances(1, 1, 1)
+	for _, service := range services {
+		lb.Done(service, time.Millisecond, nil)
+	}
+	//下线的实例在没有在途请求时删除统计
+	selectSequence(t, lb, services[:2], 1)
+	if _, ok := lb.stats["c"]; ok || len(lb.stats) != 2 {
+		t.Fatalf("stats = %v, want c pruned", lb.stats)
+	}
+}
@@ -0,0 +1,34 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+var ErrNoCA = errors.New("no certificate found in CA file")
+
+/**
+访问HTTPS服务的客户端TLS配置
+caFile为空时使用系统根证书，serverName为空时使用请求地址中的主机名
+*/
+func NewClientConfig(caFile, serverName string, skipVerify bool) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         serverName,
+		InsecureSkipVerify: skipVerify,
+	}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%w: %s", ErrNoCA, caFile)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,209 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownClientAuth   = errors.New("unknown client auth mode, supported: none, request, optional, require")
+	ErrNoClientCA          = errors.New("no certificate found in client CA file")
+	ErrNoClientCertificate = errors.New("no client certificate")
+)
+
+/**
+解析客户端证书的验证方式
+none：不请求客户端证书；request：请求客户端证书但握手时不验证，由应用验证（如自签名证书）；
+optional：客户端提供证书时必须通过验证；require：必须提供并通过验证的客户端证书
+*/
+func ParseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch value {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, ErrUnknownClientAuth
+}
+
+/**
+可以热加载的服务端证书和客户端CA
+每次握手时使用当前的证书和CA，文件修改后通过Watch自动重新加载，新连接立即使用新证书；
+加载失败时（如证书和私钥只更新了一个）保留原有的证书，并在下次检查时重试
+*/
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	clientAuth   tls.ClientAuthType
+	logger       *log.Logger
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	//各文件上次加载时的修改时间
+	modTimes map[string]time.Time
+}
+
+//clientAuth为request时clientCAFile可以为空，此时VerifyClientCertificate总是失败
+func NewReloader(certFile, keyFile, clientCAFile string, clientAuth tls.ClientAuthType, logger *log.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		clientAuth:   clientAuth,
+		logger:       logger,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//重新加载证书、私钥和客户端CA，全部加载成功后才替换
+func (r *Reloader) Reload() error {
+	modTimes, err := r.stat()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate %s: %v", r.certFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		data, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%w: %s", ErrNoClientCA, r.clientCAFile)
+		}
+	}
+	r.mutex.Lock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.mutex.Unlock()
+	return nil
+}
+
+//定期检查证书文件的修改时间，发生变化时重新加载，直到ctx结束
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTimes, err := r.stat()
+			if err != nil {
+				r.logger.Println("stat certificate file error:", err)
+				continue
+			}
+			if !r.changed(modTimes) {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				r.logger.Println("reload certificate error:", err)
+				continue
+			}
+			r.logger.Println("certificate reloaded")
+		}
+	}
+}
+
+//当前的服务端证书
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+/**
+服务端的TLS配置，最低版本为TLS 1.2
+证书和客户端CA在握手时通过GetConfigForClient获取，因此重新加载后不需要重启监听
+*/
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.certificate},
+				ClientAuth:   r.clientAuth,
+			}
+			//request时不发送可接受的CA列表，否则持有自签名证书的客户端可能不提供证书
+			if r.clientAuth >= tls.VerifyClientCertIfGiven {
+				config.ClientCAs = r.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+//使用客户端CA验证证书链，certificates[0]为客户端证书，其余为中间证书
+func (r *Reloader) VerifyClientCertificate(certificates []*x509.Certificate) error {
+	r.mutex.RLock()
+	clientCAs := r.clientCAs
+	r.mutex.RUnlock()
+	if clientCAs == nil {
+		return ErrNoClientCA
+	}
+	if len(certificates) == 0 {
+		return ErrNoClientCertificate
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func (r *Reloader) stat() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, path := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *Reloader) changed(modTimes map[string]time.Time) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for path, modTime := range modTimes {
+		if !modTime.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
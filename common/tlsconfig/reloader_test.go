@@ -0,0 +1,229 @@
+package tlsconfig
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+//parent为nil时生成自签名的CA证书
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+type testFiles struct {
+	certFile, keyFile, caFile string
+}
+
+func newTestFiles(t *testing.T) testFiles {
+	dir := t.TempDir()
+	return testFiles{
+		certFile: filepath.Join(dir, "server.crt"),
+		keyFile:  filepath.Join(dir, "server.key"),
+		caFile:   filepath.Join(dir, "ca.crt"),
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (f testFiles) write(t *testing.T, certPEM, keyPEM []byte) {
+	t.Helper()
+	writeTestFile(t, f.certFile, certPEM)
+	writeTestFile(t, f.keyFile, keyPEM)
+}
+
+func currentCertificate(t *testing.T, r *Reloader) []byte {
+	t.Helper()
+	certificate, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate.Certificate[0]
+}
+
+func TestReloaderReload(t *testing.T) {
+	files := newTestFiles(t)
+	first := newTestCertificate(t, "first", nil)
+	files.write(t, first.certPEM, first.keyPEM)
+	r, err := NewReloader(files.certFile, files.keyFile, "", tls.NoClientCert, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(currentCertificate(t, r), first.certificate.Raw) {
+		t.Fatal("initial certificate is not served")
+	}
+
+	second := newTestCertificate(t, "second", nil)
+	files.write(t, second.certPEM, second.keyPEM)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(currentCertificate(t, r), second.certificate.Raw) {
+		t.Fatal("reloaded certificate is not served")
+	}
+
+	//证书和私钥不匹配（如只更新了一个文件）时保留原有的证书
+	third := newTestCertificate(t, "third", nil)
+	files.write(t, third.certPEM, second.keyPEM)
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload accepted a mismatched certificate and key")
+	}
+	if !bytes.Equal(currentCertificate(t, r), second.certificate.Raw) {
+		t.Fatal("previous certificate is lost after a failed reload")
+	}
+	//新连接的配置同样使用原有的证书
+	config, err := r.TLSConfig().GetConfigForClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(config.Certificates[0].Certificate[0], second.certificate.Raw) {
+		t.Fatal("connection config does not use the previous certificate")
+	}
+
+	files.write(t, []byte("not a certificate"), []byte("not a key"))
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload accepted malformed files")
+	}
+	if !bytes.Equal(currentCertificate(t, r), second.certificate.Raw) {
+		t.Fatal("previous certificate is lost after loading malformed files")
+	}
+}
+
+func TestReloaderClientCA(t *testing.T) {
+	files := newTestFiles(t)
+	server := newTestCertificate(t, "server", nil)
+	files.write(t, server.certPEM, server.keyPEM)
+	ca := newTestCertificate(t, "ca", nil)
+	writeTestFile(t, files.caFile, ca.certPEM)
+	r, err := NewReloader(files.certFile, files.keyFile, files.caFile, tls.VerifyClientCertIfGiven, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newTestCertificate(t, "client", ca)
+	if err := r.VerifyClientCertificate([]*x509.Certificate{client.certificate}); err != nil {
+		t.Fatalf("client certificate signed by the client CA: %v", err)
+	}
+	if err := r.VerifyClientCertificate([]*x509.Certificate{newTestCertificate(t, "client", nil).certificate}); err == nil {
+		t.Fatal("self-signed client certificate is accepted")
+	}
+	if err := r.VerifyClientCertificate(nil); err != ErrNoClientCertificate {
+		t.Fatalf("VerifyClientCertificate without certificate error = %v, want %v", err, ErrNoClientCertificate)
+	}
+
+	//CA文件中没有证书时保留原有的CA
+	writeTestFile(t, files.caFile, []byte("no certificates"))
+	if err := r.Reload(); err == nil {
+		t.Fatal("Reload accepted an empty client CA file")
+	}
+	if err := r.VerifyClientCertificate([]*x509.Certificate{client.certificate}); err != nil {
+		t.Fatalf("previous client CA is lost after a failed reload: %v", err)
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	files := newTestFiles(t)
+	first := newTestCertificate(t, "first", nil)
+	files.write(t, first.certPEM, first.keyPEM)
+	r, err := NewReloader(files.certFile, files.keyFile, "", tls.NoClientCert, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	second := newTestCertificate(t, "second", nil)
+	files.write(t, second.certPEM, second.keyPEM)
+	deadline := time.Now().Add(5 * time.Second)
+	for !bytes.Equal(currentCertificate(t, r), second.certificate.Raw) {
+		if time.Now().After(deadline) {
+			t.Fatal("changed certificate files are not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		value string
+		want  tls.ClientAuthType
+		err   error
+	}{
+		{"", tls.NoClientCert, nil},
+		{"none", tls.NoClientCert, nil},
+		{"request", tls.RequestClientCert, nil},
+		{"optional", tls.VerifyClientCertIfGiven, nil},
+		{"require", tls.RequireAndVerifyClientCert, nil},
+		{"always", tls.NoClientCert, ErrUnknownClientAuth},
+	}
+	for _, tt := range tests {
+		if got, err := ParseClientAuth(tt.value); got != tt.want || err != tt.err {
+			t.Errorf("ParseClientAuth(%q) = %v, %v, want %v, %v", tt.value, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+//RFC 6238附录B的SHA1测试秘钥"12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateCode(t *testing.T) {
+	//RFC 6238附录B的测试向量，取后6位
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		code, err := GenerateCode(rfcSecret, Step(time.Unix(test.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Errorf("GenerateCode at %d = %s, want %s", test.unix, code, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+	codeAt := func(step int64) string {
+		code, err := GenerateCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	tests := []struct {
+		name string
+		code string
+		skew int
+		step int64
+		ok   bool
+	}{
+		{"current step", codeAt(current), 1, current, true},
+		{"previous step within skew", codeAt(current - 1), 1, current - 1, true},
+		{"next step within skew", codeAt(current + 1), 1, current + 1, true},
+		{"outside skew", codeAt(current - 2), 1, 0, false},
+		{"no skew", codeAt(current - 1), 0, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"wrong length", codeAt(current)[:5], 1, 0, false},
+		{"empty", "", 1, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, test.code, now, test.skew)
+			if ok != test.ok || step != test.step {
+				t.Fatalf("Validate = %d, %v; want %d, %v", step, ok, test.step, test.ok)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	if _, ok := Validate("not base32!", "123456", time.Now(), 1); ok {
+		t.Fatal("Validate accepted a code for an invalid secret")
+	}
+}
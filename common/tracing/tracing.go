@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+)
+
+/**
+基于OpenTelemetry的链路追踪
+各层通过Tracer()创建span，未调用Install时使用otel默认的空实现，不产生任何开销；
+Install设置全局的TracerProvider和W3C Trace Context、Baggage传播器
+*/
+
+//创建span时使用的instrumentation名称
+const InstrumentationName = "security"
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+//设置全局的TracerProvider和W3C传播器
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+//将span以JSON格式批量写入w，用于调试
+func NewStdoutTracerProvider(serviceName string, w io.Writer) (*sdktrace.TracerProvider, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(newResource(serviceName)),
+	), nil
+}
+
+//span结束时同步写入内存，用于测试中检查产生的span
+func NewInMemoryTracerProvider(serviceName string) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(newResource(serviceName)),
+	), exporter
+}
+
+func newResource(serviceName string) *resource.Resource {
+	return resource.NewSchemaless(attribute.String("service.name", serviceName))
+}
+
+//创建子span
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+//记录错误并结束span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//上游请求头中的W3C trace context
+const (
+	testTraceId      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentSpanId = "00f067aa0ba902b7"
+	testTraceparent  = "00-" + testTraceId + "-" + testParentSpanId + "-01"
+)
+
+func installInMemory(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	provider, exporter := NewInMemoryTracerProvider("security-test")
+	Install(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanByName(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q is not recorded", name)
+	return tracetest.SpanStub{}
+}
+
+func attributeValue(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func newTestHandler(e endpoint.Endpoint) http.Handler {
+	e = Middleware("auth", func(next endpoint.Endpoint) endpoint.Endpoint { return next })(EndpointMiddleware("order")(e))
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/orders/{id}").Handler(kithttp.NewServer(
+		e,
+		func(ctx context.Context, r *http.Request) (interface{}, error) { return nil, nil },
+		kithttp.EncodeJSONResponse,
+		ServerOptions()...,
+	))
+	return r
+}
+
+func TestServerSpans(t *testing.T) {
+	exporter := installInMemory(t)
+	handler := newTestHandler(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return map[string]string{"id": "1"}, nil
+	})
+	r := httptest.NewRequest(http.MethodGet, "/orders/1", nil)
+	r.Header.Set("traceparent", testTraceparent)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	//span名称使用路由模板而不是实际路径
+	server := spanByName(t, spans, "GET /orders/{id}")
+	if server.SpanContext.TraceID().String() != testTraceId || server.Parent.SpanID().String() != testParentSpanId {
+		t.Fatalf("server span is not a child of the upstream span: trace %s parent %s", server.SpanContext.TraceID(), server.Parent.SpanID())
+	}
+	if route, _ := attributeValue(server, "http.route"); route.AsString() != "/orders/{id}" {
+		t.Fatalf("http.route = %q", route.AsString())
+	}
+	if code, _ := attributeValue(server, "http.status_code"); code.AsInt64() != http.StatusOK {
+		t.Fatalf("http.status_code = %d", code.AsInt64())
+	}
+	if server.Status.Code == codes.Error {
+		t.Fatal("successful request is marked as an error")
+	}
+
+	//中间件和endpoint的span依次嵌套在路由的span下
+	middleware := spanByName(t, spans, "middleware auth")
+	order := spanByName(t, spans, "endpoint order")
+	if middleware.Parent.SpanID() != server.SpanContext.SpanID() {
+		t.Fatal("middleware span is not a child of the server span")
+	}
+	if order.Parent.SpanID() != middleware.SpanContext.SpanID() {
+		t.Fatal("endpoint span is not a child of the middleware span")
+	}
+}
+
+func TestServerSpansRecordErrors(t *testing.T) {
+	exporter := installInMemory(t)
+	failure := errors.New("order store unavailable")
+	handler := newTestHandler(func(ctx context.Context, request interface{}) (interface{}, error) {
+		return nil, failure
+	})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	spans := exporter.GetSpans()
+	//没有上游trace context时开始新的trace
+	server := spanByName(t, spans, "GET /orders/{id}")
+	if server.Parent.IsValid() {
+		t.Fatal("server span has a parent without traceparent header")
+	}
+	if server.Status.Code != codes.Error {
+		t.Fatal("5xx response is not marked as an error")
+	}
+	for _, name := range []string{"middleware auth", "endpoint order"} {
+		span := spanByName(t, spans, name)
+		if span.Status.Code != codes.Error || span.Status.Description != failure.Error() {
+			t.Fatalf("%s status = %+v", name, span.Status)
+		}
+		if len(span.Events) != 1 || span.Events[0].Name != "exception" {
+			t.Fatalf("%s error is not recorded: %+v", name, span.Events)
+		}
+	}
+}
+
+func TestInjectHTTPHeader(t *testing.T) {
+	installInMemory(t)
+	ctx, span := Start(context.Background(), "client")
+	defer span.End()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	InjectHTTPHeader(ctx, r)
+	want := span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String()
+	if traceparent := r.Header.Get("traceparent"); !strings.Contains(traceparent, want) {
+		t.Fatalf("traceparent = %q, want trace and span %s", traceparent, want)
+	}
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+//go-kit服务端选项，需放在其它ServerBefore之前，使后续的请求处理都记录在路由的span中
+func ServerOptions() []kithttp.ServerOption {
+	return []kithttp.ServerOption{
+		kithttp.ServerBefore(HTTPServerBefore()),
+		kithttp.ServerFinalizer(HTTPServerFinalizer()),
+	}
+}
+
+//从请求头中提取上游的trace context和baggage，并开始路由的服务端span，span名称为 方法 路由模板
+func HTTPServerBefore() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx, _ = Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		return ctx
+	}
+}
+
+//记录响应状态码并结束路由的span，5xx视为错误
+func HTTPServerFinalizer() kithttp.ServerFinalizerFunc {
+	return func(ctx context.Context, code int, r *http.Request) {
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.Int("http.status_code", code))
+		if code >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+		span.End()
+	}
+}
+
+//将trace context和baggage写入请求头，传递给下游服务
+func InjectHTTPHeader(ctx context.Context, r *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+}
+
+//为endpoint中间件创建span，span覆盖该中间件及其后的处理
+func Middleware(name string, middleware endpoint.Middleware) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		wrapped := middleware(next)
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := Start(ctx, "middleware "+name)
+			defer func() {
+				End(span, err)
+			}()
+			return wrapped(ctx, request)
+		}
+	}
+}
+
+//为endpoint创建span
+func EndpointMiddleware(name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := Start(ctx, "endpoint "+name)
+			defer func() {
+				End(span, err)
+			}()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+/**
+使用环境变量覆盖配置，target为结构体指针
+变量名为 prefix_ 加上字段YAML路径的大写形式，切片中的结构体使用下标，如 PREFIX_USERS_USERS_0_PASSWORD，
+下标等于当前长度时追加一个元素；字符串切片和map使用逗号分隔，map的元素为key=value
+environ的格式与os.Environ相同
+*/
+func ApplyEnv(target interface{}, prefix string, environ []string) error {
+	env := make(map[string]string, len(environ))
+	for _, item := range environ {
+		if parts := strings.SplitN(item, "=", 2); len(parts) == 2 && strings.HasPrefix(parts[0], prefix+"_") {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return applyEnv(reflect.ValueOf(target).Elem(), prefix, env)
+}
+
+func applyEnv(value reflect.Value, key string, env map[string]string) error {
+	switch {
+	case value.Kind() == reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if err := applyEnv(value.Field(i), key+"_"+strings.ToUpper(name), env); err != nil {
+				return err
+			}
+		}
+		return nil
+	case value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; ; i++ {
+			elementKey := key + "_" + strconv.Itoa(i)
+			if i == value.Len() {
+				if !hasPrefix(env, elementKey+"_") {
+					return nil
+				}
+				value.Set(reflect.Append(value, reflect.New(value.Type().Elem()).Elem()))
+			}
+			if err := applyEnv(value.Index(i), elementKey, env); err != nil {
+				return err
+			}
+		}
+	}
+
+	raw, ok := env[key]
+	if !ok {
+		return nil
+	}
+	if err := setValue(value, raw); err != nil {
+		return fmt.Errorf("environment variable %s: %v", key, err)
+	}
+	return nil
+}
+
+func setValue(value reflect.Value, raw string) error {
+	if value.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(n)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		value.Set(reflect.ValueOf(splitList(raw)))
+	case reflect.Map:
+		if value.Type().Key().Kind() != reflect.String || value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", value.Type())
+		}
+		meta := make(map[string]string)
+		for _, item := range splitList(raw) {
+			parts := strings.SplitN(item, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("%q is not key=value", item)
+			}
+			meta[parts[0]] = parts[1]
+		}
+		value.Set(reflect.ValueOf(meta))
+	default:
+		return fmt.Errorf("unsupported type %s", value.Type())
+	}
+	return nil
+}
+
+func hasPrefix(env map[string]string, prefix string) bool {
+	for key := range env {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//解析逗号分隔的列表，忽略空白项
+func splitList(value string) []string {
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,175 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Token.SigningKeys = []SigningKeyConfig{{Id: "old", Secret: "old-secret"}}
+	environ := []string{
+		"SECURITY_SERVER_LISTEN=:8080",
+		"SECURITY_SERVER_ADVERTISE_PORT=18080",
+		"SECURITY_SERVER_TAGS=auth, primary,,",
+		"SECURITY_SERVER_META=zone=a,version=1=2",
+		"SECURITY_TOKEN_ACCESS_TOKEN_LIFETIME=90s",
+		"SECURITY_TOKEN_SIGNING_KEYS_0_SECRET=new-secret",
+		//下标等于当前长度时追加
+		"SECURITY_TOKEN_SIGNING_KEYS_1_ID=next",
+		"SECURITY_TOKEN_SIGNING_KEYS_1_SECRET=next-secret",
+		//跳过下标时不追加
+		"SECURITY_USERS_USERS_1_USERNAME=skipped",
+		"SECURITY_SECURITY_BEARER_TOKEN_FORM=true",
+		"SECURITY_CLIENTS_CLIENTS_0_ID=app",
+		"SECURITY_CLIENTS_CLIENTS_0_REFRESH_TOKEN_LIFETIME=1h",
+		//其他前缀和没有值的变量被忽略
+		"OTHER_SERVER_NAME=other",
+		"SECURITY_SERVER_NAME",
+	}
+	if err := ApplyEnv(config, EnvPrefix, environ); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Server.Listen != ":8080" || config.Server.AdvertisePort != 18080 {
+		t.Fatalf("server = %q/%d", config.Server.Listen, config.Server.AdvertisePort)
+	}
+	if config.Server.Name != "oauth" {
+		t.Fatalf("server.name = %q, want default", config.Server.Name)
+	}
+	if want := []string{"auth", "primary"}; !reflect.DeepEqual(config.Server.Tags, want) {
+		t.Fatalf("server.tags = %q, want %q", config.Server.Tags, want)
+	}
+	if want := map[string]string{"zone": "a", "version": "1=2"}; !reflect.DeepEqual(config.Server.Meta, want) {
+		t.Fatalf("server.meta = %v, want %v", config.Server.Meta, want)
+	}
+	if config.Token.AccessTokenLifetime != 90*time.Second {
+		t.Fatalf("token.access_token_lifetime = %v", config.Token.AccessTokenLifetime)
+	}
+	wantKeys := []SigningKeyConfig{{Id: "old", Secret: "new-secret"}, {Id: "next", Secret: "next-secret"}}
+	if !reflect.DeepEqual(config.Token.SigningKeys, wantKeys) {
+		t.Fatalf("token.signing_keys = %+v, want %+v", config.Token.SigningKeys, wantKeys)
+	}
+	if len(config.Users.Users) != 0 {
+		t.Fatalf("users.users = %+v, want none", config.Users.Users)
+	}
+	if !config.Security.BearerTokenForm {
+		t.Fatal("security.bearer_token_form is not set")
+	}
+	if len(config.Clients.Clients) != 1 || config.Clients.Clients[0].Id != "app" || config.Clients.Clients[0].RefreshTokenLifetime != time.Hour {
+		t.Fatalf("clients.clients = %+v", config.Clients.Clients)
+	}
+}
+
+func TestApplyEnvMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+	}{
+		{"int", "SECURITY_SERVER_ADVERTISE_PORT=http"},
+		{"int64", "SECURITY_USERS_USERS_0_ID=one"},
+		{"bool", "SECURITY_SECURITY_BEARER_TOKEN_QUERY=maybe"},
+		{"duration", "SECURITY_TOKEN_ACCESS_TOKEN_LIFETIME=30"},
+		{"map", "SECURITY_SERVER_META=zone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ApplyEnv(DefaultServerConfig(), EnvPrefix, []string{tt.env})
+			if err == nil {
+				t.Fatalf("ApplyEnv accepted %s", tt.env)
+			}
+			//错误中包含变量名，便于定位
+			if name := strings.SplitN(tt.env, "=", 2)[0]; !strings.Contains(err.Error(), name) {
+				t.Fatalf("error %q does not name %s", err, name)
+			}
+		})
+	}
+}
+
+func validTestConfig() *ServerConfig {
+	config := DefaultServerConfig()
+	config.Token.SigningKeys = []SigningKeyConfig{{Id: "v1", Secret: strings.Repeat("k", MinSigningKeyLength)}}
+	config.Users.Users = []UserEntry{{Id: 1, Username: "alice", Password: "password"}}
+	config.Clients.Clients = []ClientEntry{{Id: "app", Secret: "secret", Grants: []string{"password"}}}
+	return config
+}
+
+func TestValidate(t *testing.T) {
+	if err := validTestConfig().Validate(); err != nil {
+		t.Fatalf("valid configuration: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *ServerConfig)
+		problem string
+	}{
+		{"no signing key", func(c *ServerConfig) { c.Token.SigningKeys = nil }, "token.signing_keys: at least one key"},
+		{"short signing key", func(c *ServerConfig) { c.Token.SigningKeys[0].Secret = "short" }, "token.signing_keys[0].secret"},
+		{"missing key id", func(c *ServerConfig) {
+			c.Token.SigningKeys = append(c.Token.SigningKeys, SigningKeyConfig{Secret: strings.Repeat("n", MinSigningKeyLength)})
+		}, "token.signing_keys[1].id: required"},
+		{"duplicate key id", func(c *ServerConfig) {
+			c.Token.SigningKeys = append(c.Token.SigningKeys, c.Token.SigningKeys[0])
+		}, "duplicate key id"},
+		{"refresh shorter than access", func(c *ServerConfig) { c.Token.RefreshTokenLifetime = time.Minute }, "token.refresh_token_lifetime"},
+		{"listen address", func(c *ServerConfig) { c.Server.Listen = "10098" }, "server.listen"},
+		{"advertise port", func(c *ServerConfig) { c.Server.AdvertisePort = 70000 }, "server.advertise_port"},
+		{"half tls", func(c *ServerConfig) { c.Server.TLS.CertFile = "server.crt" }, "server.tls: cert_file and key_file"},
+		{"client auth without ca", func(c *ServerConfig) {
+			c.Server.TLS = TLSConfig{CertFile: "server.crt", KeyFile: "server.key", ClientAuth: "optional"}
+		}, "server.tls.client_ca_file"},
+		{"duplicate user", func(c *ServerConfig) { c.Users.Users = append(c.Users.Users, c.Users.Users[0]) }, "duplicate user"},
+		{"unsupported grant", func(c *ServerConfig) { c.Grants = append(c.Grants, "implicit") }, "grants[2]"},
+		{"client grant not enabled", func(c *ServerConfig) { c.Clients.Clients[0].Grants = []string{"client_credentials"} }, "is not enabled in grants"},
+		{"client without secret", func(c *ServerConfig) { c.Clients.Clients[0].Secret = "" }, "clients.clients[0].secret"},
+		{"unsupported discovery", func(c *ServerConfig) { c.Discovery.Type = "zookeeper" }, "discovery.type"},
+		{"audit file without key", func(c *ServerConfig) { c.Audit.File = "audit.log" }, "audit.file_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validTestConfig()
+			tt.modify(config)
+			err := config.Validate()
+			validationError, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Validate error = %v, want *ValidationError", err)
+			}
+			if !strings.Contains(validationError.Error(), tt.problem) {
+				t.Fatalf("problems %q do not contain %q", validationError.Problems, tt.problem)
+			}
+		})
+	}
+
+	//一次报告全部问题
+	config := validTestConfig()
+	config.Token.SigningKeys = nil
+	config.Store.Type = "redis"
+	if err, ok := config.Validate().(*ValidationError); !ok || len(err.Problems) != 2 {
+		t.Fatalf("Validate = %v, want two problems", err)
+	}
+}
+
+//仓库中的示例配置加上环境变量即可通过校验
+func TestLoadServerConfigWithEnv(t *testing.T) {
+	t.Setenv("SECURITY_TOKEN_SIGNING_KEYS_0_SECRET", strings.Repeat("e", MinSigningKeyLength))
+	t.Setenv("SECURITY_DISCOVERY_TYPE", "static")
+	t.Setenv("SECURITY_DISCOVERY_STATIC", "oauth=127.0.0.1:10098")
+	config, err := LoadServerConfig("server.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Token.SigningKeys[0].Id != "dev" || config.Token.SigningKeys[0].Secret != strings.Repeat("e", MinSigningKeyLength) {
+		t.Fatalf("token.signing_keys[0] = %+v", config.Token.SigningKeys[0])
+	}
+	if config.Discovery.Type != "static" {
+		t.Fatalf("discovery.type = %q", config.Discovery.Type)
+	}
+
+	t.Setenv("SECURITY_SERVER_ADVERTISE_PORT", "port")
+	if _, err := LoadServerConfig("server.yaml"); err == nil {
+		t.Fatal("LoadServerConfig accepted a malformed environment variable")
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+配置文件的热加载
+配置文件修改或收到信号（如SIGHUP）时重新加载并校验配置，校验通过后依次调用注册的处理函数；
+加载失败时保留原有配置。只有处理函数应用的部分（如用户、客户端、签名秘钥）会生效，监听地址等配置仍需重启
+*/
+type ServerConfigWatcher struct {
+	path    string
+	logger  *log.Logger
+	mutex   sync.Mutex
+	modTime time.Time
+	//处理函数，收到校验通过的新配置
+	handlers []func(*ServerConfig) error
+}
+
+func NewServerConfigWatcher(path string, logger *log.Logger) *ServerConfigWatcher {
+	w := &ServerConfigWatcher{
+		path:   path,
+		logger: logger,
+	}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	return w
+}
+
+//注册新配置的处理函数，需在Watch之前调用
+func (w *ServerConfigWatcher) OnReload(handler func(*ServerConfig) error) {
+	w.handlers = append(w.handlers, handler)
+}
+
+//重新加载配置并应用，处理函数失败时继续调用其余的处理函数，返回最后一个错误
+func (w *ServerConfigWatcher) Reload() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if info, err := os.Stat(w.path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	config, err := LoadServerConfig(w.path)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, handler := range w.handlers {
+		if err := handler(config); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+//定期检查配置文件的修改时间，发生变化或收到signals中的信号时重新加载，直到ctx结束
+func (w *ServerConfigWatcher) Watch(ctx context.Context, interval time.Duration, signals <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		case <-ticker.C:
+			//只使用环境变量时没有可检查的文件
+			if w.path == "" {
+				continue
+			}
+			info, err := os.Stat(w.path)
+			if err != nil {
+				w.logger.Println("stat configuration file error:", err)
+				continue
+			}
+			w.mutex.Lock()
+			modTime := w.modTime
+			w.mutex.Unlock()
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+		}
+		if err := w.Reload(); err != nil {
+			w.logger.Println("reload configuration error:", err)
+			continue
+		}
+		w.logger.Println("configuration reloaded")
+	}
+}
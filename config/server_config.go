@@ -0,0 +1,504 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"security/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+授权服务器的配置
+先使用默认值，再读取YAML配置文件，最后使用环境变量覆盖，示例见config/server.yaml；
+环境变量名为 SECURITY_ 加上字段的YAML路径，以下划线连接并转为大写，
+如SECURITY_SERVER_LISTEN、SECURITY_TOKEN_SIGNING_KEYS_0_SECRET
+*/
+
+//环境变量的前缀
+const EnvPrefix = "SECURITY"
+
+//HS256秘钥的最小长度，字节
+const MinSigningKeyLength = 32
+
+type ServerConfig struct {
+	Server    ListenerConfig  `yaml:"server"`
+	Token     TokenConfig     `yaml:"token"`
+	Store     StoreConfig     `yaml:"store"`
+	Users     UserConfig      `yaml:"users"`
+	Clients   ClientConfig    `yaml:"clients"`
+	Grants    []string        `yaml:"grants"`
+	Security  SecurityConfig  `yaml:"security"`
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Audit     AuditConfig     `yaml:"audit"`
+}
+
+type ListenerConfig struct {
+	//服务名，注册到服务发现时使用
+	Name string `yaml:"name"`
+	//监听地址，如:10098
+	Listen string `yaml:"listen"`
+	//注册到服务发现的地址，端口为0时使用监听端口
+	AdvertiseHost string            `yaml:"advertise_host"`
+	AdvertisePort int               `yaml:"advertise_port"`
+	Tags          []string          `yaml:"tags"`
+	Meta          map[string]string `yaml:"meta"`
+	//停止时进入维护模式并等待的时间
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	TLS          TLSConfig     `yaml:"tls"`
+}
+
+//配置了证书时使用HTTPS，证书文件修改后自动重新加载
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	//验证客户端证书的CA
+	ClientCAFile string `yaml:"client_ca_file"`
+	//none、request、optional或require
+	ClientAuth string `yaml:"client_auth"`
+}
+
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+type TokenConfig struct {
+	//第一个秘钥用于签发，全部秘钥都可以用于验证，便于轮换
+	SigningKeys []SigningKeyConfig `yaml:"signing_keys"`
+	//客户端未配置令牌有效期时使用的默认值
+	AccessTokenLifetime  time.Duration `yaml:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration `yaml:"refresh_token_lifetime"`
+}
+
+type SigningKeyConfig struct {
+	//写入JWT头部的kid
+	Id     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+type StoreConfig struct {
+	//令牌存储：jwt
+	Type string `yaml:"type"`
+}
+
+type UserConfig struct {
+	//用户信息来源：memory
+	Type  string      `yaml:"type"`
+	Users []UserEntry `yaml:"users"`
+}
+
+type UserEntry struct {
+	Id          int64    `yaml:"id"`
+	Username    string   `yaml:"username"`
+	Password    string   `yaml:"password"`
+	Authorities []string `yaml:"authorities"`
+}
+
+type ClientConfig struct {
+	//客户端信息来源：memory
+	Type    string        `yaml:"type"`
+	Clients []ClientEntry `yaml:"clients"`
+}
+
+type ClientEntry struct {
+	Id          string   `yaml:"id"`
+	Secret      string   `yaml:"secret"`
+	RedirectUri string   `yaml:"redirect_uri"`
+	Grants      []string `yaml:"grants"`
+	Scopes      []string `yaml:"scopes"`
+	//为0时使用token中的默认值
+	AccessTokenLifetime  time.Duration `yaml:"access_token_lifetime"`
+	RefreshTokenLifetime time.Duration `yaml:"refresh_token_lifetime"`
+	//令牌端点的认证方式：为空时使用secret，或tls_client_auth、self_signed_tls_client_auth
+	AuthMethod string `yaml:"auth_method"`
+	//tls_client_auth时证书的主题，如 CN=orders,O=example
+	TLSClientAuthSubjectDN string `yaml:"tls_client_auth_subject_dn"`
+	//self_signed_tls_client_auth时证书的SHA-256指纹，base64url编码
+	TLSClientCertificateThumbprints []string `yaml:"tls_client_certificate_thumbprints"`
+	//访问令牌绑定客户端证书（cnf.x5t#S256）
+	CertificateBoundAccessTokens bool `yaml:"certificate_bound_access_tokens"`
+}
+
+type SecurityConfig struct {
+	//路由策略文件
+	PolicyFile string `yaml:"policy_file"`
+	//角色继承，如 Admin > Simple
+	RoleHierarchy string `yaml:"role_hierarchy"`
+	//必须绑定TOTP二次验证的角色
+	MFARequiredAuthorities []string `yaml:"mfa_required_authorities"`
+	//TOTP绑定的保存文件，为空时只保存在内存中，重启后丢失
+	MFAEnrollmentFile string `yaml:"mfa_enrollment_file"`
+	//是否接受表单请求体和URL查询参数中的access_token
+	BearerTokenForm  bool `yaml:"bearer_token_form"`
+	BearerTokenQuery bool `yaml:"bearer_token_query"`
+}
+
+type DiscoveryConfig struct {
+	//consul、etcd、static或file
+	Type   string       `yaml:"type"`
+	Consul ConsulConfig `yaml:"consul"`
+	Etcd   EtcdConfig   `yaml:"etcd"`
+	//static时的服务实例，如 oauth=127.0.0.1:10098,oauth=127.0.0.1:10099
+	Static string `yaml:"static"`
+	//file时的服务实例文件
+	File string `yaml:"file"`
+}
+
+type ConsulConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	//大于0时使用TTL检查，由进程内心跳维持
+	CheckTTL        time.Duration `yaml:"check_ttl"`
+	CheckInterval   time.Duration `yaml:"check_interval"`
+	CheckTimeout    time.Duration `yaml:"check_timeout"`
+	DeregisterAfter time.Duration `yaml:"deregister_after"`
+	//HTTPS检查时consul是否跳过证书验证，以及验证使用的服务器名
+	CheckTLSSkipVerify bool   `yaml:"check_tls_skip_verify"`
+	CheckTLSServerName string `yaml:"check_tls_server_name"`
+}
+
+type EtcdConfig struct {
+	Endpoints []string      `yaml:"endpoints"`
+	Prefix    string        `yaml:"prefix"`
+	LeaseTTL  time.Duration `yaml:"lease_ttl"`
+	//HTTPS自检时是否跳过证书验证、验证使用的服务器名和CA证书，CA为空时使用系统根证书
+	CheckTLSSkipVerify bool   `yaml:"check_tls_skip_verify"`
+	CheckTLSServerName string `yaml:"check_tls_server_name"`
+	CheckTLSCAFile     string `yaml:"check_tls_ca_file"`
+}
+
+type TracingConfig struct {
+	//none或stdout
+	Exporter string `yaml:"exporter"`
+}
+
+type AuditConfig struct {
+	//哈希链JSON文件
+	File string `yaml:"file"`
+	//计算哈希链的HMAC秘钥，与签名秘钥一样通过环境变量SECURITY_AUDIT_FILE_KEY注入
+	FileKey string `yaml:"file_key"`
+	//local或network://host:port
+	Syslog  string `yaml:"syslog"`
+	Webhook string `yaml:"webhook"`
+}
+
+//配置校验失败，包含全部问题
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+//默认配置，不包含签名秘钥、用户和客户端
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Server: ListenerConfig{
+			Name:          "oauth",
+			Listen:        ":10098",
+			AdvertiseHost: "127.0.0.1",
+		},
+		Token: TokenConfig{
+			AccessTokenLifetime:  30 * time.Minute,
+			RefreshTokenLifetime: 5 * time.Hour,
+		},
+		Store:   StoreConfig{Type: "jwt"},
+		Users:   UserConfig{Type: "memory"},
+		Clients: ClientConfig{Type: "memory"},
+		Grants:  []string{"password", "refresh_token"},
+		Security: SecurityConfig{
+			PolicyFile:             "./config/policy.yaml",
+			RoleHierarchy:          "Admin > Simple",
+			MFARequiredAuthorities: []string{"Admin"},
+			MFAEnrollmentFile:      "./mfa_enrollments.json",
+		},
+		Discovery: DiscoveryConfig{
+			Type: "consul",
+			Consul: ConsulConfig{
+				Host:            "127.0.0.1",
+				Port:            8500,
+				CheckInterval:   15 * time.Second,
+				CheckTimeout:    5 * time.Second,
+				DeregisterAfter: 30 * time.Second,
+			},
+			Etcd: EtcdConfig{
+				Endpoints: []string{"127.0.0.1:2379"},
+				Prefix:    "/services",
+				LeaseTTL:  15 * time.Second,
+			},
+			File: "./config/instances.yaml",
+		},
+		Tracing: TracingConfig{Exporter: "none"},
+	}
+}
+
+//加载配置文件并应用环境变量，path为空时只使用默认值和环境变量
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	config := DefaultServerConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		//未知的字段视为错误，避免拼写错误的配置被忽略
+		if err := yaml.UnmarshalStrict(data, config); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", path, err)
+		}
+	}
+	if err := ApplyEnv(config, EnvPrefix, os.Environ()); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//支持的授权类型
+var supportedGrants = map[string]bool{
+	"password":           true,
+	"refresh_token":      true,
+	"client_credentials": true,
+}
+
+func (c *ServerConfig) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Server.Name == "" {
+		fail("server.name: must not be empty")
+	}
+	if _, port, err := net.SplitHostPort(c.Server.Listen); err != nil {
+		fail("server.listen: %q is not a host:port address", c.Server.Listen)
+	} else if _, err := strconv.Atoi(port); err != nil && c.Server.AdvertisePort == 0 {
+		fail("server.listen: port %q is not numeric, set server.advertise_port", port)
+	}
+	if c.Server.AdvertiseHost == "" {
+		fail("server.advertise_host: must not be empty")
+	}
+	if c.Server.AdvertisePort < 0 || c.Server.AdvertisePort > 65535 {
+		fail("server.advertise_port: %d is out of range", c.Server.AdvertisePort)
+	}
+
+	if tlsConfig := c.Server.TLS; tlsConfig.CertFile != "" || tlsConfig.KeyFile != "" {
+		if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" {
+			fail("server.tls: cert_file and key_file must be set together")
+		}
+	} else if tlsConfig.ClientCAFile != "" || tlsConfig.ClientAuth != "" && tlsConfig.ClientAuth != "none" {
+		fail("server.tls: client certificates require cert_file and key_file")
+	}
+	switch c.Server.TLS.ClientAuth {
+	case "", "none", "request":
+	case "optional", "require":
+		if c.Server.TLS.ClientCAFile == "" {
+			fail("server.tls.client_ca_file: required when client_auth is %s", c.Server.TLS.ClientAuth)
+		}
+		//consul的HTTP检查和etcd的自检请求不携带客户端证书
+		if c.Server.TLS.ClientAuth == "require" && (c.Discovery.Type == "etcd" || c.Discovery.Type == "consul" && c.Discovery.Consul.CheckTTL <= 0) {
+			fail("server.tls.client_auth: require rejects the HTTPS health checks of %s discovery, use optional or discovery.consul.check_ttl", c.Discovery.Type)
+		}
+	default:
+		fail("server.tls.client_auth: unsupported mode %q, supported: none, request, optional, require", c.Server.TLS.ClientAuth)
+	}
+
+	if len(c.Token.SigningKeys) == 0 {
+		fail("token.signing_keys: at least one key is required, e.g. set %s_TOKEN_SIGNING_KEYS_0_SECRET", EnvPrefix)
+	}
+	keyIds := make(map[string]bool)
+	for i, key := range c.Token.SigningKeys {
+		if len(key.Secret) < MinSigningKeyLength {
+			fail("token.signing_keys[%d].secret: must be at least %d bytes", i, MinSigningKeyLength)
+		}
+		if key.Id == "" && len(c.Token.SigningKeys) > 1 {
+			fail("token.signing_keys[%d].id: required when more than one key is configured", i)
+		}
+		if keyIds[key.Id] {
+			fail("token.signing_keys[%d].id: duplicate key id %q", i, key.Id)
+		}
+		keyIds[key.Id] = true
+	}
+	if c.Token.AccessTokenLifetime < time.Second {
+		fail("token.access_token_lifetime: must be at least 1s")
+	}
+	if c.Token.RefreshTokenLifetime < c.Token.AccessTokenLifetime {
+		fail("token.refresh_token_lifetime: must not be shorter than access_token_lifetime")
+	}
+
+	if c.Store.Type != "jwt" {
+		fail("store.type: unsupported token store %q, supported: jwt", c.Store.Type)
+	}
+
+	if c.Users.Type != "memory" {
+		fail("users.type: unsupported user backend %q, supported: memory", c.Users.Type)
+	}
+	usernames := make(map[string]bool)
+	for i, user := range c.Users.Users {
+		if user.Username == "" {
+			fail("users.users[%d].username: must not be empty", i)
+		} else if usernames[user.Username] {
+			fail("users.users[%d].username: duplicate user %q", i, user.Username)
+		}
+		usernames[user.Username] = true
+		if user.Password == "" {
+			fail("users.users[%d].password: must not be empty", i)
+		}
+	}
+
+	for i, grant := range c.Grants {
+		if !supportedGrants[grant] {
+			fail("grants[%d]: unsupported grant type %q", i, grant)
+		}
+	}
+
+	if c.Clients.Type != "memory" {
+		fail("clients.type: unsupported client backend %q, supported: memory", c.Clients.Type)
+	}
+	clientIds := make(map[string]bool)
+	for i, client := range c.Clients.Clients {
+		if client.Id == "" {
+			fail("clients.clients[%d].id: must not be empty", i)
+		} else if clientIds[client.Id] {
+			fail("clients.clients[%d].id: duplicate client %q", i, client.Id)
+		}
+		clientIds[client.Id] = true
+		clientAuth := c.Server.TLS.ClientAuth
+		switch client.AuthMethod {
+		case "":
+			if client.Secret == "" {
+				fail("clients.clients[%d].secret: must not be empty", i)
+			}
+		case model.TLSClientAuth:
+			if client.TLSClientAuthSubjectDN == "" {
+				fail("clients.clients[%d].tls_client_auth_subject_dn: required for %s", i, client.AuthMethod)
+			}
+			if c.Server.TLS.ClientCAFile == "" || clientAuth == "" || clientAuth == "none" {
+				fail("clients.clients[%d].auth_method: %s requires server.tls.client_ca_file and client_auth", i, client.AuthMethod)
+			}
+		case model.SelfSignedTLSClientAuth:
+			if len(client.TLSClientCertificateThumbprints) == 0 {
+				fail("clients.clients[%d].tls_client_certificate_thumbprints: required for %s", i, client.AuthMethod)
+			}
+			//optional和require在握手时拒绝不是客户端CA签发的证书
+			if clientAuth != "request" {
+				fail("clients.clients[%d].auth_method: %s requires server.tls.client_auth request", i, client.AuthMethod)
+			}
+		default:
+			fail("clients.clients[%d].auth_method: unsupported method %q, supported: %s, %s", i, client.AuthMethod, model.TLSClientAuth, model.SelfSignedTLSClientAuth)
+		}
+		if client.CertificateBoundAccessTokens && (clientAuth == "" || clientAuth == "none") {
+			fail("clients.clients[%d].certificate_bound_access_tokens: requires server.tls.client_auth", i)
+		}
+		for _, grant := range client.Grants {
+			if !c.GrantEnabled(grant) {
+				fail("clients.clients[%d].grants: grant type %q is not enabled in grants", i, grant)
+			}
+		}
+		if client.AccessTokenLifetime < 0 || client.RefreshTokenLifetime < 0 {
+			fail("clients.clients[%d]: token lifetimes must not be negative", i)
+		}
+	}
+
+	switch c.Discovery.Type {
+	case "consul":
+		if c.Discovery.Consul.Host == "" || c.Discovery.Consul.Port <= 0 {
+			fail("discovery.consul: host and port are required")
+		}
+	case "etcd":
+		if len(c.Discovery.Etcd.Endpoints) == 0 {
+			fail("discovery.etcd.endpoints: at least one endpoint is required")
+		}
+	case "static":
+		if c.Discovery.Static == "" {
+			fail("discovery.static: instances are required, e.g. %s=127.0.0.1:10098", c.Server.Name)
+		}
+	case "file":
+		if c.Discovery.File == "" {
+			fail("discovery.file: path is required")
+		}
+	default:
+		fail("discovery.type: unsupported discovery %q, supported: consul, etcd, static, file", c.Discovery.Type)
+	}
+
+	if c.Tracing.Exporter != "none" && c.Tracing.Exporter != "stdout" {
+		fail("tracing.exporter: unsupported exporter %q, supported: none, stdout", c.Tracing.Exporter)
+	}
+	if c.Audit.File != "" && len(c.Audit.FileKey) < MinSigningKeyLength {
+		fail("audit.file_key: must be at least %d bytes when audit.file is set", MinSigningKeyLength)
+	}
+	if c.Audit.Syslog != "" && c.Audit.Syslog != "local" && !strings.Contains(c.Audit.Syslog, "://") {
+		fail("audit.syslog: %q must be local or network://host:port", c.Audit.Syslog)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+func (c *ServerConfig) GrantEnabled(grantType string) bool {
+	for _, grant := range c.Grants {
+		if grant == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+//注册到服务发现的端口
+func (c *ServerConfig) AdvertisePort() int {
+	if c.Server.AdvertisePort > 0 {
+		return c.Server.AdvertisePort
+	}
+	_, port, _ := net.SplitHostPort(c.Server.Listen)
+	value, _ := strconv.Atoi(port)
+	return value
+}
+
+func (c *ServerConfig) UserDetails() []*model.UserDetails {
+	users := make([]*model.UserDetails, 0, len(c.Users.Users))
+	for _, user := range c.Users.Users {
+		users = append(users, &model.UserDetails{
+			UserId:      user.Id,
+			UserName:    user.Username,
+			Password:    user.Password,
+			Authorities: user.Authorities,
+		})
+	}
+	return users
+}
+
+//未配置有效期的客户端使用token中的默认值
+func (c *ServerConfig) ClientDetails() []*model.ClientDetails {
+	clients := make([]*model.ClientDetails, 0, len(c.Clients.Clients))
+	for _, client := range c.Clients.Clients {
+		accessTokenLifetime, refreshTokenLifetime := client.AccessTokenLifetime, client.RefreshTokenLifetime
+		if accessTokenLifetime == 0 {
+			accessTokenLifetime = c.Token.AccessTokenLifetime
+		}
+		if refreshTokenLifetime == 0 {
+			refreshTokenLifetime = c.Token.RefreshTokenLifetime
+		}
+		clients = append(clients, &model.ClientDetails{
+			ClientId:                        client.Id,
+			ClientSecret:                    client.Secret,
+			AccessTokenValiditySeconds:      int(accessTokenLifetime / time.Second),
+			RefreshTokenValiditySeconds:     int(refreshTokenLifetime / time.Second),
+			RegisteredRedirectUri:           client.RedirectUri,
+			AuthorizedGrantTypes:            client.Grants,
+			Scope:                           client.Scopes,
+			TokenEndpointAuthMethod:         client.AuthMethod,
+			TLSClientAuthSubjectDN:          client.TLSClientAuthSubjectDN,
+			TLSClientCertificateThumbprints: client.TLSClientCertificateThumbprints,
+			CertificateBoundAccessTokens:    client.CertificateBoundAccessTokens,
+		})
+	}
+	return clients
+}
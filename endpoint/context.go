@@ -0,0 +1,60 @@
+package endpoint
+
+import (
+	"context"
+	"security/model"
+)
+
+//context中的key，使用私有类型避免与其他包冲突
+type contextKey int
+
+const (
+	oauth2DetailsKey contextKey = iota
+	oauth2ClientDetailsKey
+	oauth2ErrorKey
+	requestRouteKey
+)
+
+//保存已认证的客户端信息
+func NewClientDetailsContext(ctx context.Context, details *model.ClientDetails) context.Context {
+	return context.WithValue(ctx, oauth2ClientDetailsKey, details)
+}
+
+//获取已认证的客户端信息
+func ClientDetailsFromContext(ctx context.Context) (*model.ClientDetails, bool) {
+	details, ok := ctx.Value(oauth2ClientDetailsKey).(*model.ClientDetails)
+	return details, ok && details != nil
+}
+
+//保存访问令牌绑定的用户信息和客户端信息
+func NewOAuth2DetailsContext(ctx context.Context, details *model.OAuth2Details) context.Context {
+	return context.WithValue(ctx, oauth2DetailsKey, details)
+}
+
+//获取访问令牌绑定的用户信息和客户端信息
+func OAuth2DetailsFromContext(ctx context.Context) (*model.OAuth2Details, bool) {
+	details, ok := ctx.Value(oauth2DetailsKey).(*model.OAuth2Details)
+	return details, ok && details != nil
+}
+
+//保存认证过程中的错误
+func NewErrorContext(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, oauth2ErrorKey, err)
+}
+
+//获取认证过程中的错误
+func ErrorFromContext(ctx context.Context) (error, bool) {
+	err, ok := ctx.Value(oauth2ErrorKey).(error)
+	return err, ok
+}
+
+//保存请求匹配到的路由
+func NewRequestRouteContext(ctx context.Context, route *RequestRoute) context.Context {
+	return context.WithValue(ctx, requestRouteKey, route)
+}
+
+//获取请求匹配到的路由
+func RequestRouteFromContext(ctx context.Context) (*RequestRoute, bool) {
+	route, ok := ctx.Value(requestRouteKey).(*RequestRoute)
+	return route, ok && route != nil
+}
@@ -0,0 +1,105 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"security/model"
+	"security/service"
+	"testing"
+)
+
+func TestContextAccessors(t *testing.T) {
+	ctx := context.Background()
+	if _, ok := ClientDetailsFromContext(ctx); ok {
+		t.Fatal("empty context has client details")
+	}
+	if _, ok := OAuth2DetailsFromContext(ctx); ok {
+		t.Fatal("empty context has oauth2 details")
+	}
+	if _, ok := ErrorFromContext(ctx); ok {
+		t.Fatal("empty context has an error")
+	}
+	if _, ok := RequestRouteFromContext(ctx); ok {
+		t.Fatal("empty context has a route")
+	}
+
+	client := &model.ClientDetails{ClientId: "app"}
+	details := &model.OAuth2Details{Client: client, User: &model.UserDetails{UserName: "alice"}}
+	route := &RequestRoute{Path: "/simple", Method: "GET"}
+	failure := errors.New("failure")
+	ctx = NewClientDetailsContext(ctx, client)
+	ctx = NewOAuth2DetailsContext(ctx, details)
+	ctx = NewErrorContext(ctx, failure)
+	ctx = NewRequestRouteContext(ctx, route)
+
+	//每种值使用独立的key，互不覆盖
+	if got, ok := ClientDetailsFromContext(ctx); !ok || got != client {
+		t.Fatalf("ClientDetailsFromContext = %v, %v", got, ok)
+	}
+	if got, ok := OAuth2DetailsFromContext(ctx); !ok || got != details {
+		t.Fatalf("OAuth2DetailsFromContext = %v, %v", got, ok)
+	}
+	if got, ok := ErrorFromContext(ctx); !ok || got != failure {
+		t.Fatalf("ErrorFromContext = %v, %v", got, ok)
+	}
+	if got, ok := RequestRouteFromContext(ctx); !ok || got != route {
+		t.Fatalf("RequestRouteFromContext = %v, %v", got, ok)
+	}
+}
+
+func TestContextAccessorsRejectNilAndForeignKeys(t *testing.T) {
+	ctx := NewClientDetailsContext(context.Background(), nil)
+	ctx = NewOAuth2DetailsContext(ctx, nil)
+	ctx = NewRequestRouteContext(ctx, nil)
+	if _, ok := ClientDetailsFromContext(ctx); ok {
+		t.Fatal("nil client details are reported as present")
+	}
+	if _, ok := OAuth2DetailsFromContext(ctx); ok {
+		t.Fatal("nil oauth2 details are reported as present")
+	}
+	if _, ok := RequestRouteFromContext(ctx); ok {
+		t.Fatal("nil route is reported as present")
+	}
+
+	//其他包使用同名的字符串key写入的值不可见
+	ctx = context.WithValue(context.Background(), "OAuth2Details", &model.OAuth2Details{})
+	ctx = context.WithValue(ctx, contextKey(-1), &model.ClientDetails{})
+	if _, ok := OAuth2DetailsFromContext(ctx); ok {
+		t.Fatal("value stored under a string key is visible")
+	}
+	if _, ok := ClientDetailsFromContext(ctx); ok {
+		t.Fatal("value stored under another key is visible")
+	}
+}
+
+//客户端信息和令牌信息分开保存，资源端点只读取令牌信息
+func TestResourceEndpointsReadOAuth2Details(t *testing.T) {
+	svc := service.NewCommonService()
+	simple := MakeOAuth2AuthorizationMiddleware(log.NewNopLogger())(MakeSimpleEndpoint(svc))
+	client := &model.ClientDetails{ClientId: "app"}
+
+	//只有客户端信息时返回错误而不是panic
+	ctx := NewClientDetailsContext(context.Background(), client)
+	if _, err := simple(ctx, nil); err != ErrInvalidUserRequest {
+		t.Fatalf("simple endpoint without oauth2 details error = %v, want %v", err, ErrInvalidUserRequest)
+	}
+	if _, err := MakeAdminEndpoint(svc)(ctx, nil); err != ErrInvalidUserRequest {
+		t.Fatalf("admin endpoint without oauth2 details error = %v, want %v", err, ErrInvalidUserRequest)
+	}
+
+	ctx = NewOAuth2DetailsContext(ctx, &model.OAuth2Details{Client: client, User: &model.UserDetails{UserName: "alice"}})
+	response, err := simple(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := response.(*SimpleResponse).Result; result != svc.SimpleData("alice") {
+		t.Fatalf("result = %q", result)
+	}
+
+	//认证过程中的错误优先返回
+	failure := errors.New("failure")
+	if _, err := simple(NewErrorContext(ctx, failure), nil); err != failure {
+		t.Fatalf("error from context = %v, want %v", err, failure)
+	}
+}
@@ -3,23 +3,22 @@ package endpoint
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"net/http"
 	"security/model"
 	"security/service"
-)
-
-const (
-	OAuth2DetailsKey       = "OAuthDetails"
-	OAuth2ClientDetailsKey = "OAuthClientDetails"
-	OAuth2ErrorKey         = "OAuth2Error"
+	"strings"
+	"time"
 )
 
 var (
 	ErrInvalidClientRequest = errors.New("invalid client message")
 	ErrInvalidUserRequest   = errors.New("invalid user message")
 	ErrNotPermit            = errors.New("not permit")
+	ErrInvalidToken         = errors.New("invalid access token")
+	ErrUnauthorizedClient   = errors.New("client is not authorized to use this grant type")
 )
 
 type OAuth2Endpoints struct {
@@ -28,6 +27,8 @@ type OAuth2Endpoints struct {
 	HealthCheckEndpoint endpoint.Endpoint
 	SimpleEndpoint      endpoint.Endpoint
 	AdminEndpoint       endpoint.Endpoint
+	MFAEnrollEndpoint   endpoint.Endpoint
+	IntrospectEndpoint  endpoint.Endpoint
 }
 
 type TokenRequest struct {
@@ -35,9 +36,13 @@ type TokenRequest struct {
 	Reader    *http.Request
 }
 
+//RFC 6749 5.1节定义的令牌响应
 type TokenReponse struct {
-	AccessToken *model.OAuth2Token `json:"access_token"`
-	Error       string             `json:"error"`
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
 }
 
 //令牌认证
@@ -48,11 +53,11 @@ func MakeClientAuthorizationMiddleware(logger log.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 			//请求上下文是否存在错误
-			if err, ok := ctx.Value(OAuth2ErrorKey).(error); ok {
+			if err, ok := ErrorFromContext(ctx); ok {
 				return nil, err
 			}
 			//验证客户端信息和用户信息是否存在，不存在则拒绝访问
-			if _, ok := ctx.Value(OAuth2ClientDetailsKey).(*model.ClientDetails); !ok {
+			if _, ok := ClientDetailsFromContext(ctx); !ok {
 				return nil, ErrInvalidClientRequest
 			}
 			return next(ctx, request)
@@ -68,18 +73,47 @@ func MakeClientAuthorizationMiddleware(logger log.Logger) endpoint.Middleware {
 func MakeTokenEndpoint(grant service.TokenGrant, detailsService service.ClientDetailsService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(*TokenRequest)
-		token, err := grant.Grant(ctx, req.GrantType, ctx.Value(OAuth2ClientDetailsKey).(*model.ClientDetails), req.Reader)
-		var errString = ""
+		clientDetails, ok := ClientDetailsFromContext(ctx)
+		if !ok {
+			return nil, ErrInvalidClientRequest
+		}
+		//客户端只能使用注册时允许的授权类型
+		if !isAuthorizedGrantType(clientDetails, req.GrantType) {
+			return nil, ErrUnauthorizedClient
+		}
+		token, err := grant.Grant(ctx, req.GrantType, clientDetails, req.Reader)
 		if err != nil {
-			errString = err.Error()
+			return nil, err
 		}
-		return TokenReponse{
-			AccessToken: token,
-			Error:       errString,
-		}, nil
+		return NewTokenResponse(token, clientDetails), nil
 	}
 }
 
+//将访问令牌转换为标准的令牌响应
+func NewTokenResponse(token *model.OAuth2Token, clientDetails *model.ClientDetails) TokenReponse {
+	response := TokenReponse{
+		AccessToken: token.TokenValue,
+		TokenType:   "bearer",
+		Scope:       strings.Join(clientDetails.Scope, " "),
+	}
+	if token.ExpiresTime != nil {
+		response.ExpiresIn = int64(time.Until(*token.ExpiresTime).Seconds())
+	}
+	if token.RefreshToken != nil {
+		response.RefreshToken = token.RefreshToken.TokenValue
+	}
+	return response
+}
+
+func isAuthorizedGrantType(clientDetails *model.ClientDetails, grantType string) bool {
+	for _, value := range clientDetails.AuthorizedGrantTypes {
+		if value == grantType {
+			return true
+		}
+	}
+	return false
+}
+
 type CheckTokenRequest struct {
 	Token         string
 	ClientDetails model.ClientDetails
@@ -87,7 +121,6 @@ type CheckTokenRequest struct {
 
 type CheckTokenResponse struct {
 	OAuthDetails *model.OAuth2Details `json:"o_auth_details"`
-	Error        string               `json:"error"`
 }
 
 //将请求中的tokenValue传递给TokenService.GetOAuth2DetailsByAccessToken方法以验证token的有效性
@@ -95,52 +128,121 @@ func MakeCheckTokenEndpoint(tokenService service.TokenService) endpoint.Endpoint
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(*CheckTokenRequest)
 		//根据访问令牌获取用户信息和客户端信息
-		tokenDetails, err := tokenService.GetOAuth2DetailsByAccessToken(req.Token)
-		var errString = ""
+		tokenDetails, err := tokenService.GetOAuth2DetailsByAccessToken(ctx, req.Token)
 		if err != nil {
-			errString = err.Error()
+			return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 		}
 		return CheckTokenResponse{
 			OAuthDetails: tokenDetails,
-			Error:        errString,
 		}, nil
 	}
 }
 
-type HealthRequest struct {
+type IntrospectRequest struct {
+	Token string
 }
-type HealthReponse struct {
-	Status bool `json:"status"`
+
+//RFC 7662 2.2节定义的内省响应，令牌无效时只返回active为false
+type IntrospectResponse struct {
+	Active      bool                `json:"active"`
+	Scope       string              `json:"scope,omitempty"`
+	ClientId    string              `json:"client_id,omitempty"`
+	Username    string              `json:"username,omitempty"`
+	TokenType   string              `json:"token_type,omitempty"`
+	Exp         int64               `json:"exp,omitempty"`
+	Authorities []string            `json:"authorities,omitempty"`
+	Amr         []string            `json:"amr,omitempty"`
+	Cnf         *model.Confirmation `json:"cnf,omitempty"`
 }
 
-func MakeHealthCheckEndpoint(svc service.Service) endpoint.Endpoint {
+//RFC 7662 令牌内省，供资源服务器校验访问令牌
+//令牌无效或已过期时不返回错误，返回active为false，避免泄露令牌无效的原因
+func MakeIntrospectEndpoint(tokenService service.TokenService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		status := svc.HealthCheck()
-		return HealthReponse{
-			Status: status,
+		req := request.(*IntrospectRequest)
+		details, err := tokenService.GetOAuth2DetailsByAccessToken(ctx, req.Token)
+		if err != nil {
+			return IntrospectResponse{Active: false}, nil
+		}
+		introspection := IntrospectResponse{
+			Active:    true,
+			TokenType: "bearer",
+			Amr:       details.AuthenticationMethods,
+		}
+		if details.Client != nil {
+			introspection.ClientId = details.Client.ClientId
+			introspection.Scope = strings.Join(details.Client.Scope, " ")
+		}
+		if details.User != nil {
+			introspection.Username = details.User.UserName
+			introspection.Authorities = details.User.Authorities
+		}
+		if !details.ExpiresAt.IsZero() {
+			introspection.Exp = details.ExpiresAt.Unix()
+		}
+		if details.CertificateThumbprint != "" {
+			introspection.Cnf = &model.Confirmation{X5tS256: details.CertificateThumbprint}
+		}
+		return introspection, nil
+	}
+}
+
+type MFAEnrollRequest struct {
+	Username string
+	Password string
+	Code     string
+	IP       string
+}
+
+type MFAEnrollResponse struct {
+	Enrollment *model.TOTPEnrollment `json:"enrollment"`
+}
+
+//验证用户凭证后为用户生成等待确认的TOTP秘钥和恢复码，再次请求并提供新秘钥的一次性密码后绑定生效
+func MakeMFAEnrollEndpoint(mfaService service.MFAService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(*MFAEnrollRequest)
+		enrollment, err := mfaService.Enroll(ctx, req.Username, req.Password, req.Code, req.IP)
+		if err != nil {
+			return nil, err
+		}
+		return MFAEnrollResponse{
+			Enrollment: enrollment,
 		}, nil
 	}
 }
 
+type HealthRequest struct {
+	//true时执行就绪检查，否则执行存活检查
+	Ready bool
+}
+
+//执行存活或就绪检查，返回各组件的状态
+func MakeHealthCheckEndpoint(healthService service.HealthService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(*HealthRequest)
+		if req.Ready {
+			return healthService.Ready(ctx), nil
+		}
+		return healthService.Live(ctx), nil
+	}
+}
+
 //访问资源服务器受保护的资源的端点，不仅需要请求中携带有效的访问令牌，
 //还需要访问令牌对应的用户和客户端具备足够的权限
 //在transport层中makeOAuth2AuthroizationContext请求处理器中获得了用户信息和客户端信息，
-//可以根据他们具备的权限等级，判断是否具备访问点的权限
-func MakeAuthorityAuthorizationMiddleware(authority string, logger log.Logger) endpoint.Middleware {
+//权限判断委托给AuthorityPolicy，authority可以是角色，也可以是权限字符串
+func MakeAuthorityAuthorizationMiddleware(policy service.AuthorityPolicy, authority string, logger log.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-			if err, ok := ctx.Value(OAuth2ErrorKey).(error); ok {
+			if err, ok := ErrorFromContext(ctx); ok {
 				return nil, err
 			}
-			if details, ok := ctx.Value(OAuth2ClientDetailsKey).(*model.OAuth2Details); !ok {
+			if details, ok := OAuth2DetailsFromContext(ctx); !ok {
 				return nil, ErrInvalidClientRequest
-			} else {
-				for _, value := range details.User.Authorities {
-					//权限检查
-					if value == authority {
-						return next(ctx, request)
-					}
-				}
+			} else if policy.IsGranted(ctx, details.User, authority) {
+				//权限检查
+				return next(ctx, request)
 			}
 			return nil, ErrNotPermit
 
@@ -148,6 +250,33 @@ func MakeAuthorityAuthorizationMiddleware(authority string, logger log.Logger) e
 	}
 }
 
+//请求匹配到的路由，由transport层写入context
+type RequestRoute struct {
+	//路由模板
+	Path   string
+	Method string
+}
+
+//根据路由策略统一鉴权，策略按请求的路由和方法匹配，判断令牌对应的用户和客户端是否允许访问
+func MakeRoutePolicyMiddleware(policy service.RoutePolicyService, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			if err, ok := ErrorFromContext(ctx); ok {
+				return nil, err
+			}
+			details, ok := OAuth2DetailsFromContext(ctx)
+			if !ok {
+				return nil, ErrInvalidUserRequest
+			}
+			route, ok := RequestRouteFromContext(ctx)
+			if !ok || !policy.IsPermitted(ctx, route.Path, route.Method, details) {
+				return nil, ErrNotPermit
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
 //Simple 和 Admin
 
 type SimpleRequest struct {
@@ -170,7 +299,11 @@ type AdminResponse struct {
 //从context中获取用户和客户端信息
 func MakeSimpleEndpoint(svc service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		result := svc.SimpleData(ctx.Value(OAuth2DetailsKey).(*model.OAuth2Details).User.UserName)
+		details, ok := OAuth2DetailsFromContext(ctx)
+		if !ok {
+			return nil, ErrInvalidUserRequest
+		}
+		result := svc.SimpleData(details.User.UserName)
 		return &SimpleResponse{
 			Result: result,
 		}, nil
@@ -181,7 +314,11 @@ func MakeSimpleEndpoint(svc service.Service) endpoint.Endpoint {
 //从context中获取用户和客户端信息
 func MakeAdminEndpoint(svc service.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-		result := svc.AdminData(ctx.Value(OAuth2DetailsKey).(*model.OAuth2Details).User.UserName)
+		details, ok := OAuth2DetailsFromContext(ctx)
+		if !ok {
+			return nil, ErrInvalidUserRequest
+		}
+		result := svc.AdminData(details.User.UserName)
 		return &AdminResponse{
 			Result: result,
 		}, nil
@@ -192,10 +329,10 @@ func MakeAdminEndpoint(svc service.Service) endpoint.Endpoint {
 func MakeOAuth2AuthorizationMiddleware(logger log.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
-			if err, ok := ctx.Value(OAuth2ErrorKey).(error); !ok {
+			if err, ok := ErrorFromContext(ctx); ok {
 				return nil, err
 			}
-			if _, ok := ctx.Value(OAuth2ClientDetailsKey).(*model.OAuth2Details); !ok {
+			if _, ok := OAuth2DetailsFromContext(ctx); !ok {
 				return nil, ErrInvalidUserRequest
 			}
 			return next(ctx, request)
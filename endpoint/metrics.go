@@ -0,0 +1,71 @@
+package endpoint
+
+import (
+	"context"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	//未注册的授权类型
+	GrantTypeOther = "other"
+	//未通过认证的客户端
+	ClientIdUnknown = "unknown"
+)
+
+//端点的请求计数和延迟
+//requestCount的标签为endpoint、grant_type、client_id、outcome，requestLatency的标签为endpoint、grant_type、outcome
+//标签值只使用已注册的授权类型和已认证的客户端ID，避免任意请求参数产生无限的指标序列
+type EndpointMetrics struct {
+	RequestCount   metrics.Counter
+	RequestLatency metrics.Histogram
+	//已注册的授权类型，其他值记为other
+	GrantTypes []string
+}
+
+//统计端点的请求次数和延迟，令牌端点按授权类型区分，客户端ID从请求上下文中获取
+func MakeMetricsMiddleware(name string, m *EndpointMetrics) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func(begin time.Time) {
+				grantType := ""
+				if req, ok := request.(*TokenRequest); ok {
+					grantType = m.grantTypeLabel(req.GrantType)
+				}
+				outcome := OutcomeSuccess
+				if err != nil {
+					outcome = OutcomeFailure
+				}
+				m.RequestCount.With("endpoint", name, "grant_type", grantType, "client_id", requestClientId(ctx), "outcome", outcome).Add(1)
+				m.RequestLatency.With("endpoint", name, "grant_type", grantType, "outcome", outcome).Observe(time.Since(begin).Seconds())
+			}(time.Now())
+			return next(ctx, request)
+		}
+	}
+}
+
+func (m *EndpointMetrics) grantTypeLabel(grantType string) string {
+	for _, value := range m.GrantTypes {
+		if value == grantType {
+			return grantType
+		}
+	}
+	return GrantTypeOther
+}
+
+//令牌端点从已验证的客户端信息中获取，资源端点从访问令牌绑定的客户端信息中获取，认证失败时不使用请求中声明的客户端ID
+func requestClientId(ctx context.Context) string {
+	if _, ok := ErrorFromContext(ctx); ok {
+		return ClientIdUnknown
+	}
+	if clientDetails, ok := ClientDetailsFromContext(ctx); ok {
+		return clientDetails.ClientId
+	}
+	if details, ok := OAuth2DetailsFromContext(ctx); ok && details.Client != nil {
+		return details.Client.ClientId
+	}
+	return ClientIdUnknown
+}
@@ -0,0 +1,65 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/metrics"
+	"security/model"
+	"testing"
+)
+
+//记录最近一次使用的标签
+type labelRecorder struct {
+	labels map[string]string
+}
+
+func (r *labelRecorder) With(labelValues ...string) metrics.Counter {
+	r.labels = make(map[string]string)
+	for i := 0; i+1 < len(labelValues); i += 2 {
+		r.labels[labelValues[i]] = labelValues[i+1]
+	}
+	return r
+}
+
+func (r *labelRecorder) Add(delta float64) {
+}
+
+type discardHistogram struct{}
+
+func (h discardHistogram) With(labelValues ...string) metrics.Histogram {
+	return h
+}
+
+func (h discardHistogram) Observe(value float64) {
+}
+
+func TestMetricsLabels(t *testing.T) {
+	errClient := errors.New("client authentication failed")
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		grantType string
+		labels    map[string]string
+	}{
+		{"registered grant", NewClientDetailsContext(context.Background(), &model.ClientDetails{ClientId: "app"}), "password", map[string]string{"grant_type": "password", "client_id": "app"}},
+		{"unregistered grant", NewClientDetailsContext(context.Background(), &model.ClientDetails{ClientId: "app"}), "random-grant-8f3a", map[string]string{"grant_type": GrantTypeOther, "client_id": "app"}},
+		//认证失败的请求不使用声明的客户端ID
+		{"failed client authentication", NewErrorContext(context.Background(), errClient), "random-grant-8f3a", map[string]string{"grant_type": GrantTypeOther, "client_id": ClientIdUnknown}},
+		{"no client", context.Background(), "password", map[string]string{"grant_type": "password", "client_id": ClientIdUnknown}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := &labelRecorder{}
+			m := &EndpointMetrics{RequestCount: counter, RequestLatency: discardHistogram{}, GrantTypes: []string{"password", "refresh_token"}}
+			next := func(ctx context.Context, request interface{}) (interface{}, error) {
+				return nil, nil
+			}
+			MakeMetricsMiddleware("token", m)(next)(test.ctx, &TokenRequest{GrantType: test.grantType})
+			for key, value := range test.labels {
+				if counter.labels[key] != value {
+					t.Fatalf("labels = %v, want %s=%s", counter.labels, key, value)
+				}
+			}
+		})
+	}
+}
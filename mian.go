@@ -4,18 +4,24 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	uuid "github.com/satori/go.uuid"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"net/http"
 	"os"
 	"os/signal"
+	"security/common/audit"
 	"security/common/discover"
+	"security/common/tlsconfig"
+	"security/common/tracing"
 	"security/config"
 	"security/endpoint"
-	"security/model"
 	"security/service"
 	"security/transport"
-	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 /**
@@ -25,28 +31,142 @@ import (
 func main() {
 	//依次构建service层、endpoint层、transport层
 	var (
-		servicePort = flag.Int("service.port", 10098, "service port")
-		serviceHost = flag.String("service.host", "127.0.0.1", "service host")
-		consulPort  = flag.Int("consul.port", 8500, "consul port")
-		consulHost  = flag.String("consul.host", "127.0.0.1", "consul host")
-		serviceName = flag.String("service.name", "oauth", "service name")
+		configFile  = flag.String("config", "./config/server.yaml", "configuration file, values can be overridden by SECURITY_* environment variables")
+		auditVerify = flag.String("audit.verify", "", "verify the hash chain of an audit file and exit")
 	)
 	flag.Parse()
 
+	//加载配置，校验失败时列出全部问题
+	serverConfig, err := config.LoadServerConfig(*configFile)
+	if err != nil {
+		config.Logger.Println("load configuration failed:", err)
+		os.Exit(-1)
+	}
+
+	//使用配置中的audit.file_key校验哈希链
+	if *auditVerify != "" {
+		count, err := audit.VerifyFile(*auditVerify, []byte(serverConfig.Audit.FileKey))
+		if err != nil {
+			fmt.Printf("audit file verification failed after %d records: %v\n", count, err)
+			os.Exit(1)
+		}
+		fmt.Printf("audit file verified: %d records\n", count)
+		return
+	}
+	serviceName := serverConfig.Server.Name
+
 	ctx := context.Background()
 	errChan := make(chan error)
 
-	//发现服务
-	var discoveryClient discover.DiscoveryClient
+	//链路追踪，未启用时各层的span为空实现
+	var tracerProvider *sdktrace.TracerProvider
+	switch serverConfig.Tracing.Exporter {
+	case "stdout":
+		provider, err := tracing.NewStdoutTracerProvider(serviceName, os.Stdout)
+		if err != nil {
+			config.Logger.Println("create tracer provider failed:", err)
+			os.Exit(-1)
+		}
+		tracerProvider = provider
+		tracing.Install(tracerProvider)
+	}
 
-	discoveryClient, err := discover.NewKitDiscoverClient(*consulHost, *consulPort)
+	//审计日志，未配置Sink时不写入
+	auditRecorder, err := newAuditRecorder(serverConfig.Audit, serviceName)
 	if err != nil {
-		config.Logger.Println("get consul client failed")
+		config.Logger.Println("create audit sink failed:", err)
 		os.Exit(-1)
 	}
 
+	//指标，通过/metrics暴露
+	fieldKeys := []string{"endpoint", "grant_type", "client_id", "outcome"}
+	endpointMetrics := &endpoint.EndpointMetrics{
+		RequestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "security",
+			Subsystem: "oauth",
+			Name:      "request_count",
+			Help:      "Number of requests received.",
+		}, fieldKeys),
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "security",
+			Subsystem: "oauth",
+			Name:      "request_latency_seconds",
+			Help:      "Request latency in seconds.",
+			Buckets:   stdprometheus.DefBuckets,
+		}, []string{"endpoint", "grant_type", "outcome"}),
+		//与注册的授权类型一致
+		GrantTypes: serverConfig.Grants,
+	}
+	activeTokens := kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Namespace: "security",
+		Subsystem: "oauth",
+		Name:      "active_access_tokens",
+		Help:      "Number of issued access tokens that have not expired.",
+	}, nil)
+	failedLogins := kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Namespace: "security",
+		Subsystem: "oauth",
+		Name:      "failed_login_count",
+		Help:      "Number of failed login attempts.",
+	}, []string{"locked"})
+
+	svc := service.NewCommonService()
+	//健康检查，各组件创建后注册自己的检查
+	healthService := service.NewHealthService(3 * time.Second)
+
+	//发现服务
+	var discoveryClient discover.DiscoveryClient
+
+	discoveryConfig := serverConfig.Discovery
+	switch discoveryConfig.Type {
+	case "consul":
+		discoveryClient, err = discover.NewKitDiscoverClient(discoveryConfig.Consul.Host, discoveryConfig.Consul.Port, discover.ConsulRegistrationConfig{
+			TTL:                            discoveryConfig.Consul.CheckTTL,
+			CheckInterval:                  discoveryConfig.Consul.CheckInterval,
+			CheckTimeout:                   discoveryConfig.Consul.CheckTimeout,
+			DeregisterCriticalServiceAfter: discoveryConfig.Consul.DeregisterAfter,
+			DrainTimeout:                   serverConfig.Server.DrainTimeout,
+			HealthCheck: func() bool {
+				return healthService.Ready(context.Background()).IsUp()
+			},
+			TLSSkipVerify: discoveryConfig.Consul.CheckTLSSkipVerify,
+			TLSServerName: discoveryConfig.Consul.CheckTLSServerName,
+		}, config.Logger)
+		if err != nil {
+			config.Logger.Println("get consul client failed")
+			os.Exit(-1)
+		}
+	case "etcd":
+		checkTLSConfig, err := tlsconfig.NewClientConfig(discoveryConfig.Etcd.CheckTLSCAFile, discoveryConfig.Etcd.CheckTLSServerName, discoveryConfig.Etcd.CheckTLSSkipVerify)
+		if err != nil {
+			config.Logger.Println("load etcd health check CA failed:", err)
+			os.Exit(-1)
+		}
+		etcdDiscoveryClient, err := discover.NewEtcdDiscoveryClient(discoveryConfig.Etcd.Endpoints, discoveryConfig.Etcd.Prefix, discoveryConfig.Etcd.LeaseTTL, checkTLSConfig, config.Logger)
+		if err != nil {
+			config.Logger.Println("get etcd client failed:", err)
+			os.Exit(-1)
+		}
+		discoveryClient = etcdDiscoveryClient
+	case "static":
+		instances, err := discover.ParseStaticInstances(discoveryConfig.Static)
+		if err != nil {
+			config.Logger.Println("parse static service instances failed:", err)
+			os.Exit(-1)
+		}
+		discoveryClient = discover.NewStaticDiscoveryClient(instances)
+	case "file":
+		fileDiscoveryClient, err := discover.NewFileDiscoveryClient(discoveryConfig.File, config.Logger)
+		if err != nil {
+			config.Logger.Println("load service instance file failed:", err)
+			os.Exit(-1)
+		}
+		go fileDiscoveryClient.WatchFile(ctx, 5*time.Second)
+		discoveryClient = fileDiscoveryClient
+	}
+	discoveryClient = discover.NewTracingDiscoveryClient(discoveryClient)
+
 	var (
-		svc service.Service
 		//令牌管理
 		tokenService service.TokenService
 		//令牌生成
@@ -59,70 +179,184 @@ func main() {
 		userDetailsService service.UserDetailsService
 		//客户端信息
 		clientDetailsService service.ClientDetailsService
+		//登录尝试限制
+		loginAttemptService service.LoginAttemptService
+		//二次验证
+		mfaService service.MFAService
+		//权限策略
+		authorityPolicy service.AuthorityPolicy
+		//路由策略
+		routePolicyService *service.FileRoutePolicyService
 	)
 
-	tokenEnhancer = service.NewJWTTokenEnhancer("secret")
-	tokenStore = service.NewJwtTokenStore(tokenEnhancer.(*service.JWTTokenEnhancer))
-	tokenService = service.NewTokenService(tokenStore, tokenEnhancer)
+	//签名秘钥、客户端和用户的快照，签名秘钥第一个用于签发
+	credentials, err := credentialsFromConfig(serverConfig)
+	if err != nil {
+		config.Logger.Println("load credentials failed:", err)
+		os.Exit(-1)
+	}
+	credentialsHolder := service.NewCredentialsHolder(credentials)
+	jwtTokenEnhancer := service.NewJWTTokenEnhancerWithCredentials(credentialsHolder)
+	tokenEnhancer = jwtTokenEnhancer
+	//令牌存储，目前只支持JWT
+	jwtTokenStore := service.NewJwtTokenStore(jwtTokenEnhancer)
+	//统计未过期的访问令牌数量
+	instrumentingTokenStore := service.NewInstrumentingTokenStore(jwtTokenStore, activeTokens)
+	go instrumentingTokenStore.Watch(ctx, 30*time.Second)
+	tokenStore = service.NewTracingTokenStore(service.NewAuditingTokenStore(instrumentingTokenStore, auditRecorder))
+	tokenService = service.NewTracingTokenService(service.NewTokenService(tokenStore, tokenEnhancer))
+
+	loginAttemptService = service.NewInMemoryLoginAttemptService(service.DefaultUserAttemptPolicy, service.DefaultIPAttemptPolicy, service.DefaultMaxLoginAttemptEntries)
+	loginAttemptService = service.NewInstrumentingLoginAttemptService(loginAttemptService, failedLogins)
 
 	//用户信息
-	userDetailsService = service.NewInMemoryUserDetailsService([]*model.UserDetails{{
-		UserName:    "simple",
-		Password:    "123456",
-		UserId:      1,
-		Authorities: []string{"Simple"},
-	},
-		{
-			UserName:    "admin",
-			Password:    "123456",
-			UserId:      1,
-			Authorities: []string{"Admin"},
-		},
-	})
+	userDetailsService = service.NewInMemoryUserDetailsServiceWithCredentials(credentialsHolder, loginAttemptService)
+	//指定角色的用户必须绑定TOTP二次验证
+	var mfaEnrollmentStore service.MFAEnrollmentStore
+	if serverConfig.Security.MFAEnrollmentFile != "" {
+		mfaEnrollmentStore, err = service.NewFileMFAEnrollmentStore(serverConfig.Security.MFAEnrollmentFile)
+		if err != nil {
+			config.Logger.Println("load mfa enrollments failed:", err)
+			os.Exit(-1)
+		}
+	} else {
+		config.Logger.Println("mfa enrollments are kept in memory and lost on restart")
+		mfaEnrollmentStore = service.NewMemoryMFAEnrollmentStore()
+	}
+	mfaService = service.NewTOTPMFAService(serviceName, serverConfig.Security.MFARequiredAuthorities, userDetailsService, loginAttemptService, mfaEnrollmentStore)
+
+	//HTTPS，证书文件修改或收到SIGHUP时重新加载
+	var certificateReloader *tlsconfig.Reloader
+	//tls_client_auth的客户端使用客户端CA验证证书
+	var certificateVerifier service.ClientCertificateVerifier
+	tlsConfig := serverConfig.Server.TLS
+	if tlsConfig.Enabled() {
+		clientAuth, err := tlsconfig.ParseClientAuth(tlsConfig.ClientAuth)
+		if err != nil {
+			config.Logger.Println("parse client auth failed:", err)
+			os.Exit(-1)
+		}
+		certificateReloader, err = tlsconfig.NewReloader(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile, clientAuth, config.Logger)
+		if err != nil {
+			config.Logger.Println("load certificate failed:", err)
+			os.Exit(-1)
+		}
+		certificateVerifier = certificateReloader.VerifyClientCertificate
+		go certificateReloader.Watch(ctx, 5*time.Second)
+	} else {
+		config.Logger.Println("TLS is disabled, client credentials and tokens are sent in cleartext")
+	}
+
 	//客户端信息
-	clientDetailsService = service.NewInMemoryClientDetailService([]*model.ClientDetails{{
-		"clientId",
-		"clientSecret",
-		1800,
-		18000,
-		"http://127.0.0.1",
-		[]string{"password", "refresh_token"},
-	},
+	clientDetailsService = service.NewInMemoryClientDetailServiceWithCredentials(credentialsHolder, certificateVerifier)
+
+	//配置文件修改或收到SIGHUP时重新加载签名秘钥、客户端和用户
+	//先构建完整的新快照再一次性替换，任何一部分无效时继续使用原有快照
+	configWatcher := config.NewServerConfigWatcher(*configFile, config.Logger)
+	configWatcher.OnReload(func(serverConfig *config.ServerConfig) error {
+		credentials, err := credentialsFromConfig(serverConfig)
+		if err != nil {
+			return err
+		}
+		credentialsHolder.Store(credentials)
+		return nil
 	})
+	if certificateReloader != nil {
+		configWatcher.OnReload(func(*config.ServerConfig) error {
+			return certificateReloader.Reload()
+		})
+	}
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
 
 	//token生成器
-	tokenGranter = service.NewComposeTokenGranter(map[string]service.TokenGrant{
+	//只注册配置中启用的授权类型
+	tokenGrantDict := make(map[string]service.TokenGrant)
+	if serverConfig.GrantEnabled("password") {
 		//访问令牌：用户密码令牌生成
-		"password": service.NewUsernamePasswordTokenGrant("password", userDetailsService, tokenService),
+		tokenGrantDict["password"] = service.NewUsernamePasswordTokenGrant("password", userDetailsService, tokenService, loginAttemptService, mfaService)
+	}
+	if serverConfig.GrantEnabled("refresh_token") {
 		//刷新令牌
-		"refresh_token": service.NewRefreshGranter("fresh_token", userDetailsService, tokenService),
-	})
+		tokenGrantDict["refresh_token"] = service.NewRefreshGranter("refresh_token", userDetailsService, tokenService)
+	}
+	if serverConfig.GrantEnabled("client_credentials") {
+		//客户端凭证
+		tokenGrantDict["client_credentials"] = service.NewClientCredentialsGranter("client_credentials", tokenService)
+	}
+	tokenGranter = service.NewComposeTokenGranter(tokenGrantDict)
+	tokenGranter = service.NewTracingTokenGrant(service.NewAuditingTokenGrant(tokenGranter, tokenService, auditRecorder))
 
-	svc = service.NewCommonService()
+	//角色继承，如Admin拥有Simple的全部权限
+	authorityPolicy, err = service.NewRoleHierarchyPolicy(serverConfig.Security.RoleHierarchy, nil)
+	if err != nil {
+		config.Logger.Println("create authority policy failed:", err)
+		os.Exit(-1)
+	}
+
+	//加载路由策略文件，并在文件修改后自动重新加载
+	routePolicyService, err = service.NewFileRoutePolicyService(serverConfig.Security.PolicyFile, authorityPolicy, config.Logger)
+	if err != nil {
+		config.Logger.Println("load route policy failed:", err)
+		os.Exit(-1)
+	}
+	go routePolicyService.Watch(ctx, 5*time.Second)
+
+	//认证中间件，每个中间件记录在单独的span中
+	oauth2AuthorizationMiddleware := tracing.Middleware("oauth2_authorization", endpoint.MakeOAuth2AuthorizationMiddleware(config.KitLogger))
+	clientAuthorizationMiddleware := tracing.Middleware("client_authorization", endpoint.MakeClientAuthorizationMiddleware(config.KitLogger))
 
 	//endpoint层
 	simpleEndpoint := endpoint.MakeSimpleEndpoint(svc)
+	simpleEndpoint = tracing.EndpointMiddleware("simple")(simpleEndpoint)
 	//认证
-	simpleEndpoint = endpoint.MakeOAuth2AuthorizationMiddleware(config.KitLogger)(simpleEndpoint)
+	simpleEndpoint = oauth2AuthorizationMiddleware(simpleEndpoint)
+	//鉴权由transport层根据路由策略统一处理
 
 	adminEndpoint := endpoint.MakeAdminEndpoint(svc)
+	adminEndpoint = tracing.EndpointMiddleware("admin")(adminEndpoint)
 	//认证
-	adminEndpoint = endpoint.MakeOAuth2AuthorizationMiddleware(config.KitLogger)(adminEndpoint)
-	//鉴权
-	adminEndpoint = endpoint.MakeAuthorityAuthorizationMiddleware("Admin", config.KitLogger)(adminEndpoint)
+	adminEndpoint = oauth2AuthorizationMiddleware(adminEndpoint)
+	//鉴权由transport层根据路由策略统一处理
 
 	//从context中获取到请求客户端信息，然后委托给tokengrant根据授权类型和用户凭证为客户端生成访问令牌并返回
 	tokenEndpoint := endpoint.MakeTokenEndpoint(tokenGranter, clientDetailsService)
+	tokenEndpoint = tracing.EndpointMiddleware("token")(tokenEndpoint)
 	//验证请求上下文中是否携带了客户端信息，如果请求中没有携带验证过的客户端信息，将直接返回错误给请求方
-	tokenEndpoint = endpoint.MakeClientAuthorizationMiddleware(config.KitLogger)(tokenEndpoint)
+	tokenEndpoint = clientAuthorizationMiddleware(tokenEndpoint)
 
 	//将请求中的tokenValue传递给TokenService.GetOAuth2DetailsByAccessToken方法以验证token的有效性
 	checkTokenEndpoint := endpoint.MakeCheckTokenEndpoint(tokenService)
+	checkTokenEndpoint = tracing.EndpointMiddleware("check_token")(checkTokenEndpoint)
 	//验证请求上下文中是否携带了客户端信息，如果请求中没有携带验证过的客户端信息，将直接返回错误给请求方
-	checkTokenEndpoint = endpoint.MakeClientAuthorizationMiddleware(config.KitLogger)(checkTokenEndpoint)
+	checkTokenEndpoint = clientAuthorizationMiddleware(checkTokenEndpoint)
+
+	//RFC 7662 令牌内省
+	introspectEndpoint := endpoint.MakeIntrospectEndpoint(tokenService)
+	introspectEndpoint = tracing.EndpointMiddleware("introspect")(introspectEndpoint)
+	introspectEndpoint = clientAuthorizationMiddleware(introspectEndpoint)
+
+	//绑定TOTP二次验证
+	mfaEnrollEndpoint := endpoint.MakeMFAEnrollEndpoint(mfaService)
+	mfaEnrollEndpoint = tracing.EndpointMiddleware("mfa_enroll")(mfaEnrollEndpoint)
+	mfaEnrollEndpoint = clientAuthorizationMiddleware(mfaEnrollEndpoint)
+
+	//就绪检查：令牌存储、签名秘钥、用户和客户端信息、服务发现
+	healthService.AddReadinessCheck("token_store", service.NewTokenStoreHealthCheck(jwtTokenStore, tokenEnhancer))
+	healthService.AddReadinessCheck("signing_key", service.NewSigningKeyHealthCheck(tokenEnhancer))
+	if checker, ok := userDetailsService.(service.HealthChecker); ok {
+		healthService.AddReadinessCheck("user_details", checker.HealthCheck)
+	}
+	if checker, ok := clientDetailsService.(service.HealthChecker); ok {
+		healthService.AddReadinessCheck("client_details", checker.HealthCheck)
+	}
+	healthService.AddReadinessCheck("discovery", func(ctx context.Context) error {
+		_, err := discoveryClient.DiscoverServices(ctx, serviceName)
+		return err
+	})
 
 	//创建健康检查的endpoint
-	healthEndpoint := endpoint.MakeHealthCheckEndpoint(svc)
+	healthEndpoint := endpoint.MakeHealthCheckEndpoint(healthService)
 
 	endpts := endpoint.OAuth2Endpoints{
 		TokenEndpoint:       tokenEndpoint,
@@ -130,25 +364,63 @@ func main() {
 		HealthCheckEndpoint: healthEndpoint,
 		SimpleEndpoint:      simpleEndpoint,
 		AdminEndpoint:       adminEndpoint,
+		MFAEnrollEndpoint:   mfaEnrollEndpoint,
+		IntrospectEndpoint:  introspectEndpoint,
+	}
+
+	//访问令牌的携带方式，Authorization请求头始终支持
+	bearerTokenMode := transport.BearerTokenHeader
+	if serverConfig.Security.BearerTokenForm {
+		bearerTokenMode |= transport.BearerTokenForm
+	}
+	if serverConfig.Security.BearerTokenQuery {
+		bearerTokenMode |= transport.BearerTokenQuery
 	}
 
 	//transport层
-	r := transport.MakeHttpHandler(ctx, endpts, tokenService, clientDetailsService, config.KitLogger)
+	//客户端认证和路由鉴权在transport层进行，结果写入审计日志
+	auditingClientDetailsService := service.NewAuditingClientDetailsService(clientDetailsService, auditRecorder)
+	auditingRoutePolicyService := service.NewAuditingRoutePolicyService(routePolicyService, auditRecorder)
+	r := transport.MakeHttpHandler(ctx, endpts, tokenService, auditingClientDetailsService, auditingRoutePolicyService, endpointMetrics, bearerTokenMode, config.KitLogger)
+
+	httpServer := &http.Server{
+		Addr:    serverConfig.Server.Listen,
+		Handler: r,
+	}
+	if certificateReloader != nil {
+		httpServer.TLSConfig = certificateReloader.TLSConfig()
+	}
+	go configWatcher.Watch(ctx, 5*time.Second, reloadSignals)
 
 	//实例的id
-	instanceId := *serviceName + "-" + uuid.NewV4().String()
+	instanceId := serviceName + "-" + uuid.NewV4().String()
 
 	//http server
 	go func() {
-		config.Logger.Println("http server start at port:" + strconv.Itoa(*servicePort))
+		config.Logger.Println("http server start at:", serverConfig.Server.Listen)
 		//注册服务
-		if !discoveryClient.Register(*serviceName, instanceId, "/health", *serviceHost, *servicePort, nil, config.Logger) {
+		instance := &discover.ServiceInstance{
+			ID:     instanceId,
+			Name:   serviceName,
+			Host:   serverConfig.Server.AdvertiseHost,
+			Port:   serverConfig.AdvertisePort(),
+			Tags:   serverConfig.Server.Tags,
+			Meta:   serverConfig.Server.Meta,
+			Weight: 1,
+			Secure: tlsConfig.Enabled(),
+		}
+		if err := discoveryClient.Register(ctx, instance, "/health/ready"); err != nil {
 			//注册失败
-			config.Logger.Println("use-string-service for service %s failed.", serviceName)
+			config.Logger.Printf("register service %s failed: %v", serviceName, err)
 			os.Exit(-1)
 		}
-		handler := r
-		errChan <- http.ListenAndServe(":"+strconv.Itoa(*servicePort), handler)
+		config.Logger.Println("register service success")
+		if tlsConfig.Enabled() {
+			//证书由TLSConfig提供
+			errChan <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		errChan <- httpServer.ListenAndServe()
 	}()
 
 	//停止
@@ -160,7 +432,70 @@ func main() {
 
 	//退出
 	error := <-errChan
-	//注销服务
-	discoveryClient.Deregister(instanceId, config.Logger)
+	//注销服务，等待drain的时间不计入超时
+	deregisterCtx, cancel := context.WithTimeout(ctx, serverConfig.Server.DrainTimeout+5*time.Second)
+	if err := discoveryClient.Deregister(deregisterCtx, instanceId); err != nil {
+		config.Logger.Println("deregister service error:", err)
+	}
+	cancel()
+	//等待正在处理的请求完成，之后不会再产生审计事件
+	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		config.Logger.Println("shutdown http server error:", err)
+	}
+	cancel()
+	discoveryClient.Close()
+	//投递webhook队列中剩余的审计事件
+	auditRecorder.Close()
+	//导出缓冲中的span
+	if tracerProvider != nil {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		tracerProvider.Shutdown(shutdownCtx)
+		cancel()
+	}
 	config.Logger.Println(error)
 }
+
+func signingKeysFromConfig(serverConfig *config.ServerConfig) []service.SigningKey {
+	keys := make([]service.SigningKey, 0, len(serverConfig.Token.SigningKeys))
+	for _, key := range serverConfig.Token.SigningKeys {
+		keys = append(keys, service.SigningKey{Id: key.Id, Secret: []byte(key.Secret)})
+	}
+	return keys
+}
+
+//根据配置构建签名秘钥、客户端和用户的快照
+func credentialsFromConfig(serverConfig *config.ServerConfig) (*service.Credentials, error) {
+	return service.NewCredentials(signingKeysFromConfig(serverConfig), serverConfig.ClientDetails(), serverConfig.UserDetails())
+}
+
+//根据配置创建审计日志的Sink
+func newAuditRecorder(auditConfig config.AuditConfig, tag string) (*audit.SinkRecorder, error) {
+	var sinks []audit.Sink
+	if auditConfig.File != "" {
+		sink, err := audit.NewFileSink(auditConfig.File, []byte(auditConfig.FileKey))
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if auditConfig.Syslog != "" {
+		network, raddr := "", ""
+		if auditConfig.Syslog != "local" {
+			parts := strings.SplitN(auditConfig.Syslog, "://", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid syslog address %q", auditConfig.Syslog)
+			}
+			network, raddr = parts[0], parts[1]
+		}
+		sink, err := audit.NewSyslogSink(network, raddr, tag)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if auditConfig.Webhook != "" {
+		sinks = append(sinks, audit.NewWebhookSink(auditConfig.Webhook, nil, 0, config.Logger))
+	}
+	return audit.NewRecorder(config.Logger, sinks...), nil
+}
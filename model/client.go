@@ -1,5 +1,13 @@
 package model
 
+//RFC 8705 客户端证书认证方式
+const (
+	//证书由客户端CA签发，并且主题与客户端配置的主题一致
+	TLSClientAuth = "tls_client_auth"
+	//自签名证书，指纹与客户端配置的指纹之一一致
+	SelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
 type ClientDetails struct {
 	//客户端ID
 	ClientId string
@@ -13,4 +21,14 @@ type ClientDetails struct {
 	RegisteredRedirectUri string
 	//可以使用的授权类型
 	AuthorizedGrantTypes []string
+	//授权范围
+	Scope []string
+	//令牌端点的认证方式，为空时使用客户端秘钥（Basic认证或表单）
+	TokenEndpointAuthMethod string
+	//tls_client_auth时证书的主题，如 CN=orders,O=example
+	TLSClientAuthSubjectDN string
+	//self_signed_tls_client_auth时证书的SHA-256指纹（x5t#S256）
+	TLSClientCertificateThumbprints []string
+	//访问令牌是否绑定客户端证书
+	CertificateBoundAccessTokens bool
 }
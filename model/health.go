@@ -0,0 +1,25 @@
+package model
+
+const (
+	HealthUp   = "UP"
+	HealthDown = "DOWN"
+)
+
+//单个组件的检查结果
+type ComponentHealth struct {
+	Status string `json:"status"`
+	//检查失败的原因
+	Error string `json:"error,omitempty"`
+	//检查耗时，毫秒
+	Duration int64 `json:"duration_ms"`
+}
+
+//健康检查报告，任一组件失败时整体为DOWN
+type HealthReport struct {
+	Status     string                      `json:"status"`
+	Components map[string]*ComponentHealth `json:"components,omitempty"`
+}
+
+func (report *HealthReport) IsUp() bool {
+	return report.Status == HealthUp
+}
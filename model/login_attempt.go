@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+/**
+登录失败尝试的记录，用于暴力破解防护
+*/
+type LoginAttempt struct {
+	//连续失败次数
+	FailedAttempts int
+	//最后一次失败时间
+	LastFailedTime time.Time
+	//锁定截止时间，零值表示未锁定
+	LockedUntil time.Time
+}
+
+//账户锁定状态
+type LockState struct {
+	//是否处于锁定中
+	Locked bool
+	//连续失败次数
+	FailedAttempts int
+	//锁定截止时间，未锁定时为nil
+	LockedUntil *time.Time
+}
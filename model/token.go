@@ -1,6 +1,11 @@
 package model
 
-import "time"
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"time"
+)
 
 /**
 一般来讲，OAth2Token会和OAuth2Details一一绑定，代表当前操作的用户和客户端
@@ -26,4 +31,21 @@ func (oa *OAuth2Token) IsExpired() bool {
 type OAuth2Details struct {
 	Client *ClientDetails
 	User   *UserDetails
+	//用户认证使用的方式，对应JWT的amr声明，如pwd、otp
+	AuthenticationMethods []string
+	//令牌绑定的客户端证书指纹，对应JWT的cnf.x5t#S256声明，为空时令牌未绑定证书
+	CertificateThumbprint string
+	//令牌的过期时间，为零值时未知
+	ExpiresAt time.Time
+}
+
+//RFC 8705 令牌的确认声明（cnf）
+type Confirmation struct {
+	X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+//证书的SHA-256指纹，DER编码的摘要再进行base64url编码（无填充）
+func CertificateThumbprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
@@ -10,3 +10,22 @@ type UserDetails struct {
 	//拥有的权限
 	Authorities []string
 }
+
+//TOTP绑定的状态
+const (
+	//新秘钥等待使用一次性密码确认
+	TOTPEnrollmentPending = "pending"
+	//绑定已生效
+	TOTPEnrollmentActive = "active"
+)
+
+//TOTP绑定结果，秘钥和恢复码只在生成时返回一次
+type TOTPEnrollment struct {
+	Status string `json:"status"`
+	//base32编码的秘钥
+	Secret string `json:"secret,omitempty"`
+	//供身份验证器App扫描的otpauth URI
+	KeyURI string `json:"key_uri,omitempty"`
+	//恢复码明文
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
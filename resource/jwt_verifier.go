@@ -0,0 +1,246 @@
+package resource
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/dgrijalva/jwt-go"
+	"math/big"
+	"net/http"
+	"security/model"
+	"sync"
+	"time"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+//授权服务器签发的JWT声明，与service.OAuth2TokenCustomClaims保持一致
+type tokenClaims struct {
+	UserDetails   model.UserDetails
+	ClientDetails model.ClientDetails
+	Amr           []string            `json:"amr,omitempty"`
+	Cnf           *model.Confirmation `json:"cnf,omitempty"`
+	jwt.StandardClaims
+}
+
+/**
+本地校验JWT访问令牌
+HMAC签名根据JWT头部的kid选择共享秘钥，RSA和ECDSA签名根据kid从JWKS中查找公钥
+*/
+type JWTVerifier struct {
+	mutex    sync.RWMutex
+	hmacKeys []HMACKey
+	jwks     *JWKS
+}
+
+//HS256共享秘钥，Id与授权服务器签名秘钥的id一致，即JWT头部的kid
+type HMACKey struct {
+	Id     string
+	Secret []byte
+}
+
+//hmacKeys和jwks至少提供一个，没有kid的令牌使用hmacKeys[0]校验，与授权服务器一致
+func NewJWTVerifier(hmacKeys []HMACKey, jwks *JWKS) *JWTVerifier {
+	return &JWTVerifier{
+		hmacKeys: hmacKeys,
+		jwks:     jwks,
+	}
+}
+
+//替换全部共享秘钥，授权服务器轮换秘钥时同时保留新旧秘钥，直到旧秘钥签发的令牌全部过期
+func (v *JWTVerifier) SetHMACKeys(keys []HMACKey) {
+	v.mutex.Lock()
+	v.hmacKeys = keys
+	v.mutex.Unlock()
+}
+
+//根据kid选择共享秘钥
+func (v *JWTVerifier) hmacKey(kid string) ([]byte, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	if len(v.hmacKeys) == 0 {
+		return nil, ErrUnknownKey
+	}
+	if kid == "" {
+		return v.hmacKeys[0].Secret, nil
+	}
+	for _, key := range v.hmacKeys {
+		if key.Id == kid {
+			return key.Secret, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (v *JWTVerifier) Verify(ctx context.Context, tokenValue string) (*model.OAuth2Details, error) {
+	claims := &tokenClaims{}
+	_, err := jwt.ParseWithClaims(tokenValue, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return v.hmacKey(kid)
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			if v.jwks == nil {
+				return nil, ErrUnknownKey
+			}
+			return v.jwks.Key(ctx, kid)
+		}
+		return nil, ErrUnknownKey
+	})
+	if err != nil {
+		if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpiredToken
+		}
+		return nil, ErrInvalidToken
+	}
+	details := &model.OAuth2Details{
+		User:                  &claims.UserDetails,
+		Client:                &claims.ClientDetails,
+		AuthenticationMethods: claims.Amr,
+	}
+	if claims.ExpiresAt != 0 {
+		details.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	if claims.Cnf != nil {
+		details.CertificateThumbprint = claims.Cnf.X5tS256
+	}
+	return details, nil
+}
+
+/**
+JSON Web Key Set（RFC 7517）
+从授权服务器拉取公钥并按kid缓存，遇到未知的kid时重新拉取，两次拉取之间至少间隔minRefreshInterval
+*/
+type JWKS struct {
+	url                string
+	client             *http.Client
+	minRefreshInterval time.Duration
+	mutex              sync.Mutex
+	keys               map[string]interface{}
+	lastRefresh        time.Time
+}
+
+func NewJWKS(url string, client *http.Client, minRefreshInterval time.Duration) *JWKS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &JWKS{
+		url:                url,
+		client:             client,
+		minRefreshInterval: minRefreshInterval,
+		keys:               make(map[string]interface{}),
+	}
+}
+
+//根据kid获取公钥
+func (j *JWKS) Key(ctx context.Context, kid string) (interface{}, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(j.lastRefresh) < j.minRefreshInterval {
+		return nil, ErrUnknownKey
+	}
+	if err := j.refresh(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//调用方需持有锁
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.lastRefresh = time.Now()
+	req, err := http.NewRequest(http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := j.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("fetch jwks failed: " + resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	j.keys = keys
+	return nil
+}
+
+func (jwk *jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnknownKey
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
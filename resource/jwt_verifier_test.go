@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"context"
+	"security/model"
+	"security/service"
+	"testing"
+	"time"
+)
+
+//使用授权服务器的秘钥签发访问令牌
+func signTestToken(t *testing.T, keys []service.SigningKey) string {
+	t.Helper()
+	enhancer, err := service.NewJWTTokenEnhancerWithKeys(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiresTime := time.Now().Add(time.Minute)
+	token, err := enhancer.Enhance(&model.OAuth2Token{ExpiresTime: &expiresTime}, &model.OAuth2Details{
+		Client: &model.ClientDetails{ClientId: "app"},
+		User:   &model.UserDetails{UserName: "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.TokenValue
+}
+
+func TestJWTVerifierKeyRotation(t *testing.T) {
+	oldKey := service.SigningKey{Id: "2026-01", Secret: []byte("old-signing-key-0123456789abcdefgh")}
+	newKey := service.SigningKey{Id: "2026-07", Secret: []byte("new-signing-key-0123456789abcdefgh")}
+	oldToken := signTestToken(t, []service.SigningKey{oldKey})
+	newToken := signTestToken(t, []service.SigningKey{newKey, oldKey})
+	noKidToken := signTestToken(t, []service.SigningKey{{Secret: oldKey.Secret}})
+	hmacKey := func(key service.SigningKey) HMACKey {
+		return HMACKey{Id: key.Id, Secret: key.Secret}
+	}
+
+	verifier := NewJWTVerifier([]HMACKey{hmacKey(oldKey)}, nil)
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"old key before rotation", oldToken, nil},
+		{"no kid uses first key", noKidToken, nil},
+		{"new key before rotation", newToken, ErrInvalidToken},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			details, err := verifier.Verify(context.Background(), test.token)
+			if err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if err == nil && details.User.UserName != "alice" {
+				t.Fatalf("user = %s, want alice", details.User.UserName)
+			}
+		})
+	}
+
+	//轮换期间新旧秘钥签发的令牌都有效
+	verifier.SetHMACKeys([]HMACKey{hmacKey(newKey), hmacKey(oldKey)})
+	for _, token := range []string{oldToken, newToken} {
+		if _, err := verifier.Verify(context.Background(), token); err != nil {
+			t.Fatalf("verify during rotation: %v", err)
+		}
+	}
+	//旧秘钥移除后旧令牌失效
+	verifier.SetHMACKeys([]HMACKey{hmacKey(newKey)})
+	if _, err := verifier.Verify(context.Background(), oldToken); err != ErrInvalidToken {
+		t.Fatalf("verify with removed key error = %v, want %v", err, ErrInvalidToken)
+	}
+	if _, err := verifier.Verify(context.Background(), newToken); err != nil {
+		t.Fatal(err)
+	}
+}
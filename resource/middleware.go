@@ -0,0 +1,103 @@
+package resource
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"net/http"
+	"security/model"
+)
+
+//校验请求中的访问令牌，结果写入context
+//令牌绑定了客户端证书时（RFC 8705），请求必须通过同一证书建立的TLS连接发送
+func authenticate(ctx context.Context, verifier TokenVerifier, r *http.Request) context.Context {
+	tokenValue, err := BearerToken(r)
+	if err != nil {
+		return NewErrorContext(ctx, err)
+	}
+	details, err := verifier.Verify(ctx, tokenValue)
+	if err != nil {
+		return NewErrorContext(ctx, err)
+	}
+	if details.CertificateThumbprint != "" && !certificateMatches(r, details.CertificateThumbprint) {
+		return NewErrorContext(ctx, ErrCertificateMismatch)
+	}
+	return NewContext(ctx, details)
+}
+
+func certificateMatches(r *http.Request, thumbprint string) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	return model.CertificateThumbprint(r.TLS.PeerCertificates[0]) == thumbprint
+}
+
+//net/http中间件，令牌校验失败时直接返回401
+func Middleware(verifier TokenVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := authenticate(r.Context(), verifier, r)
+			if err, ok := ErrorFromContext(ctx); ok {
+				WriteError(w, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+//go-kit http server的ServerBefore，在请求前校验令牌，配合EndpointMiddleware使用
+func RequestFunc(verifier TokenVerifier) kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return authenticate(ctx, verifier, r)
+	}
+}
+
+//go-kit endpoint中间件，context中没有通过校验的令牌信息时拒绝访问
+func EndpointMiddleware() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			if err, ok := ErrorFromContext(ctx); ok {
+				return nil, err
+			}
+			if _, ok := FromContext(ctx); !ok {
+				return nil, ErrMissingToken
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+//按RFC 6750返回认证失败，可用作go-kit的ServerErrorEncoder
+func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+	WriteError(w, err)
+}
+
+func WriteError(w http.ResponseWriter, err error) {
+	description := errorDescription(err)
+	challenge := `Bearer realm="oauth2"`
+	if err != ErrMissingToken {
+		challenge += `, error="invalid_token", error_description="` + description + `"`
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(http.StatusUnauthorized)
+	body := map[string]interface{}{}
+	if err != ErrMissingToken {
+		body["error"] = "invalid_token"
+		body["error_description"] = description
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+//只返回本包定义的错误描述，校验令牌时的其他错误（如请求授权服务器失败）不返回原文
+func errorDescription(err error) string {
+	for _, known := range []error{ErrExpiredToken, ErrInactiveToken, ErrCertificateMismatch} {
+		if errors.Is(err, known) {
+			return known.Error()
+		}
+	}
+	return ErrInvalidToken.Error()
+}
@@ -0,0 +1,26 @@
+package resource
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorHidesVerifierErrors(t *testing.T) {
+	tests := []struct {
+		err         error
+		description string
+	}{
+		{errors.New(`Post "http://10.0.0.5:10098/oauth/check_token": connection refused`), ErrInvalidToken.Error()},
+		{ErrExpiredToken, ErrExpiredToken.Error()},
+		{ErrCertificateMismatch, ErrCertificateMismatch.Error()},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		WriteError(w, test.err)
+		want := `Bearer realm="oauth2", error="invalid_token", error_description="` + test.description + `"`
+		if challenge := w.Header().Get("WWW-Authenticate"); challenge != want {
+			t.Fatalf("WWW-Authenticate = %q, want %q", challenge, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package resource
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"security/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+请求授权服务器校验访问令牌
+支持授权服务器的/oauth/check_token和/oauth/introspect（RFC 7662）端点，资源服务器使用自己的客户端凭证进行Basic认证
+*/
+type RemoteVerifier struct {
+	endpoint     string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+	decode       func(resp *http.Response) (*model.OAuth2Details, error)
+}
+
+//使用授权服务器的/oauth/check_token校验令牌
+func NewCheckTokenVerifier(endpoint, clientId, clientSecret string, client *http.Client) *RemoteVerifier {
+	return newRemoteVerifier(endpoint, clientId, clientSecret, client, decodeCheckTokenResponse)
+}
+
+//使用授权服务器的/oauth/introspect（RFC 7662）端点校验令牌
+func NewIntrospectionVerifier(endpoint, clientId, clientSecret string, client *http.Client) *RemoteVerifier {
+	return newRemoteVerifier(endpoint, clientId, clientSecret, client, decodeIntrospectionResponse)
+}
+
+func newRemoteVerifier(endpoint, clientId, clientSecret string, client *http.Client, decode func(resp *http.Response) (*model.OAuth2Details, error)) *RemoteVerifier {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &RemoteVerifier{
+		endpoint:     endpoint,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       client,
+		decode:       decode,
+	}
+}
+
+func (v *RemoteVerifier) Verify(ctx context.Context, tokenValue string) (*model.OAuth2Details, error) {
+	form := url.Values{}
+	form.Set("token", tokenValue)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest(http.MethodPost, v.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(v.clientId, v.clientSecret)
+	resp, err := v.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return v.decode(resp)
+}
+
+func decodeCheckTokenResponse(resp *http.Response) (*model.OAuth2Details, error) {
+	switch {
+	case resp.StatusCode == http.StatusBadRequest:
+		return nil, ErrInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.New("check token failed: " + resp.Status)
+	}
+	var body struct {
+		OAuthDetails *model.OAuth2Details `json:"o_auth_details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.OAuthDetails == nil {
+		return nil, ErrInvalidToken
+	}
+	return body.OAuthDetails, nil
+}
+
+func decodeIntrospectionResponse(resp *http.Response) (*model.OAuth2Details, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("introspect token failed: " + resp.Status)
+	}
+	var body struct {
+		Active      bool     `json:"active"`
+		Scope       string   `json:"scope"`
+		ClientId    string   `json:"client_id"`
+		Username    string   `json:"username"`
+		Exp         int64    `json:"exp"`
+		Authorities []string `json:"authorities"`
+		Amr         []string `json:"amr"`
+		//RFC 8705 3.2节，绑定证书的令牌
+		Cnf *model.Confirmation `json:"cnf"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if !body.Active {
+		return nil, ErrInactiveToken
+	}
+	if body.Exp != 0 && time.Unix(body.Exp, 0).Before(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+	details := &model.OAuth2Details{
+		Client: &model.ClientDetails{
+			ClientId: body.ClientId,
+			Scope:    strings.Fields(body.Scope),
+		},
+		User: &model.UserDetails{
+			UserName:    body.Username,
+			Authorities: body.Authorities,
+		},
+		AuthenticationMethods: body.Amr,
+	}
+	if body.Exp != 0 {
+		details.ExpiresAt = time.Unix(body.Exp, 0)
+	}
+	if body.Cnf != nil {
+		details.CertificateThumbprint = body.Cnf.X5tS256
+	}
+	return details, nil
+}
+
+/**
+缓存校验结果，避免每个请求都访问授权服务器
+只缓存校验成功的结果，缓存时间越长，令牌被撤销后仍可使用的时间越长；
+缓存时间不超过令牌的过期时间，令牌过期后重新校验
+*/
+type CachingVerifier struct {
+	verifier   TokenVerifier
+	ttl        time.Duration
+	maxEntries int
+	mutex      sync.Mutex
+	entries    map[[sha256.Size]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	details   *model.OAuth2Details
+	expiresAt time.Time
+}
+
+func NewCachingVerifier(verifier TokenVerifier, ttl time.Duration, maxEntries int) *CachingVerifier {
+	return &CachingVerifier{
+		verifier:   verifier,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+func (c *CachingVerifier) Verify(ctx context.Context, tokenValue string) (*model.OAuth2Details, error) {
+	//缓存中不保存令牌明文
+	key := sha256.Sum256([]byte(tokenValue))
+	now := time.Now()
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.details, nil
+	}
+
+	details, err := c.verifier.Verify(ctx, tokenValue)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		//清除过期的缓存，仍然已满时全部清空
+		for k, v := range c.entries {
+			if !now.Before(v.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			c.entries = make(map[[sha256.Size]byte]cacheEntry)
+		}
+	}
+	expiresAt := now.Add(c.ttl)
+	if !details.ExpiresAt.IsZero() && details.ExpiresAt.Before(expiresAt) {
+		expiresAt = details.ExpiresAt
+	}
+	c.entries[key] = cacheEntry{
+		details:   details,
+		expiresAt: expiresAt,
+	}
+	return details, nil
+}
@@ -0,0 +1,82 @@
+package resource
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"net/http/httptest"
+	endpoint2 "security/endpoint"
+	"security/model"
+	"security/service"
+	"security/transport"
+	"testing"
+	"time"
+)
+
+type countingVerifier struct {
+	calls     int
+	expiresAt time.Time
+}
+
+func (v *countingVerifier) Verify(ctx context.Context, tokenValue string) (*model.OAuth2Details, error) {
+	v.calls++
+	return &model.OAuth2Details{ExpiresAt: v.expiresAt}, nil
+}
+
+func TestCachingVerifierExpiry(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn time.Duration
+		calls     int
+	}{
+		//令牌先于缓存过期，过期后重新校验
+		{"token expires before ttl", 50 * time.Millisecond, 2},
+		{"ttl expires before token", time.Hour, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := &countingVerifier{expiresAt: time.Now().Add(test.expiresIn)}
+			caching := NewCachingVerifier(verifier, time.Minute, 10)
+			for i := 0; i < 2; i++ {
+				if _, err := caching.Verify(context.Background(), "token"); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			if verifier.calls != test.calls {
+				t.Fatalf("underlying verifier called %d times, want %d", verifier.calls, test.calls)
+			}
+		})
+	}
+}
+
+func TestIntrospectionVerifier(t *testing.T) {
+	key := service.SigningKey{Secret: []byte("introspection-signing-key-0123456789")}
+	enhancer, err := service.NewJWTTokenEnhancerWithKeys([]service.SigningKey{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenService := service.NewTokenService(service.NewJwtTokenStore(enhancer), enhancer)
+	clientDetailsService := service.NewInMemoryClientDetailService([]*model.ClientDetails{{ClientId: "orders", ClientSecret: "secret"}}, nil)
+	handler := transport.MakeHttpHandler(context.Background(), endpoint2.OAuth2Endpoints{
+		IntrospectEndpoint: endpoint2.MakeClientAuthorizationMiddleware(log.NewNopLogger())(endpoint2.MakeIntrospectEndpoint(tokenService)),
+	}, tokenService, clientDetailsService, nil, &endpoint2.EndpointMetrics{
+		RequestCount:   generic.NewCounter("request_count"),
+		RequestLatency: generic.NewHistogram("request_latency_seconds", 10),
+	}, transport.BearerTokenHeader, log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	token := signTestToken(t, []service.SigningKey{key})
+	verifier := NewIntrospectionVerifier(server.URL+"/oauth/introspect", "orders", "secret", server.Client())
+	details, err := verifier.Verify(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.User.UserName != "alice" || details.Client.ClientId != "app" || details.ExpiresAt.IsZero() {
+		t.Fatalf("details = %+v", details)
+	}
+	if _, err := verifier.Verify(context.Background(), "not-a-jwt"); err != ErrInactiveToken {
+		t.Fatalf("verify invalid token error = %v, want %v", err, ErrInactiveToken)
+	}
+}
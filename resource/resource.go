@@ -0,0 +1,110 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"security/model"
+	"strings"
+)
+
+/**
+资源服务器SDK
+资源服务器使用TokenVerifier校验请求携带的访问令牌，校验通过后将令牌绑定的用户信息和客户端信息放入context，
+业务代码通过FromContext获取。令牌可以在本地校验（JWT签名，HMAC秘钥或JWKS公钥），也可以请求授权服务器校验（check_token或RFC 7662 introspection）
+*/
+
+var (
+	ErrMissingToken  = errors.New("missing bearer token")
+	ErrInvalidToken  = errors.New("invalid access token")
+	ErrExpiredToken  = errors.New("access token is expired")
+	ErrInactiveToken = errors.New("access token is not active")
+	//绑定证书的令牌没有通过同一证书建立的TLS连接使用
+	ErrCertificateMismatch = errors.New("access token is bound to a different client certificate")
+)
+
+//访问令牌校验器
+type TokenVerifier interface {
+	//校验访问令牌，返回令牌绑定的用户信息和客户端信息
+	Verify(ctx context.Context, tokenValue string) (*model.OAuth2Details, error)
+}
+
+type detailsKey struct{}
+type errorKey struct{}
+
+//将令牌绑定的信息放入context
+func NewContext(ctx context.Context, details *model.OAuth2Details) context.Context {
+	return context.WithValue(ctx, detailsKey{}, details)
+}
+
+//从context中获取令牌绑定的信息
+func FromContext(ctx context.Context) (*model.OAuth2Details, bool) {
+	details, ok := ctx.Value(detailsKey{}).(*model.OAuth2Details)
+	return details, ok && details != nil
+}
+
+//将令牌校验失败的错误放入context
+func NewErrorContext(ctx context.Context, err error) context.Context {
+	return context.WithValue(ctx, errorKey{}, err)
+}
+
+//从context中获取令牌校验失败的错误
+func ErrorFromContext(ctx context.Context) (error, bool) {
+	err, ok := ctx.Value(errorKey{}).(error)
+	return err, ok
+}
+
+//获取令牌对应的用户
+func UserFromContext(ctx context.Context) (*model.UserDetails, bool) {
+	details, ok := FromContext(ctx)
+	if !ok || details.User == nil {
+		return nil, false
+	}
+	return details.User, true
+}
+
+//获取令牌对应的客户端
+func ClientFromContext(ctx context.Context) (*model.ClientDetails, bool) {
+	details, ok := FromContext(ctx)
+	if !ok || details.Client == nil {
+		return nil, false
+	}
+	return details.Client, true
+}
+
+//令牌对应的用户是否直接拥有指定权限
+func HasAuthority(ctx context.Context, authority string) bool {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, value := range user.Authorities {
+		if value == authority {
+			return true
+		}
+	}
+	return false
+}
+
+//令牌对应的客户端是否拥有指定授权范围
+func HasScope(ctx context.Context, scope string) bool {
+	client, ok := ClientFromContext(ctx)
+	if !ok {
+		return false
+	}
+	for _, value := range client.Scope {
+		if value == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//从Authorization请求头中解析Bearer令牌
+func BearerToken(r *http.Request) (string, error) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+		return "", ErrMissingToken
+	}
+	return strings.TrimSpace(parts[1]), nil
+}
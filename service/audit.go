@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"security/common/audit"
+	"security/model"
+)
+
+/**
+产生审计事件的装饰器
+请求的来源IP和User-Agent由transport层写入context，Recorder写入事件时补全
+*/
+
+//记录登录成功或失败，以及令牌的签发和刷新
+type AuditingTokenGrant struct {
+	next         TokenGrant
+	tokenService TokenService
+	recorder     audit.Recorder
+}
+
+//tokenService用于根据签发的令牌获取用户信息
+func NewAuditingTokenGrant(next TokenGrant, tokenService TokenService, recorder audit.Recorder) TokenGrant {
+	return &AuditingTokenGrant{
+		next:         next,
+		tokenService: tokenService,
+		recorder:     recorder,
+	}
+}
+
+func (g *AuditingTokenGrant) Grant(ctx context.Context, grantType string, client *model.ClientDetails, r *http.Request) (*model.OAuth2Token, error) {
+	token, err := g.next.Grant(ctx, grantType, client, r)
+	if err != nil {
+		//只有用户凭证的验证失败视为登录失败，缺少二次验证码是登录的第一步，不是失败
+		if grantType == "password" && !errors.Is(err, ErrMFACodeRequired) {
+			g.recorder.Record(ctx, &audit.Event{
+				Type:      audit.EventLoginFailure,
+				ClientId:  client.ClientId,
+				Username:  r.PostFormValue("username"),
+				GrantType: grantType,
+				Reason:    err.Error(),
+			})
+		}
+		return nil, err
+	}
+
+	event := &audit.Event{
+		Type:      audit.EventTokenIssued,
+		ClientId:  client.ClientId,
+		GrantType: grantType,
+		TokenId:   audit.TokenFingerprint(token.TokenValue),
+		TokenType: "access_token",
+	}
+	if details, err := g.tokenService.GetOAuth2DetailsByAccessToken(ctx, token.TokenValue); err == nil {
+		setEventDetails(event, details)
+	}
+	switch grantType {
+	case "password":
+		login := *event
+		login.Type = audit.EventLoginSuccess
+		login.TokenId, login.TokenType = "", ""
+		g.recorder.Record(ctx, &login)
+	case "refresh_token":
+		event.Type = audit.EventTokenRefreshed
+	}
+	g.recorder.Record(ctx, event)
+	return token, nil
+}
+
+//记录令牌的移除，移除前读取令牌绑定的用户和客户端
+//存储支持撤销时记录token_revoked，否则令牌仍然有效，只记录token_rotated或refresh_used
+type AuditingTokenStore struct {
+	TokenStore
+	recorder audit.Recorder
+}
+
+func NewAuditingTokenStore(store TokenStore, recorder audit.Recorder) TokenStore {
+	return &AuditingTokenStore{
+		TokenStore: store,
+		recorder:   recorder,
+	}
+}
+
+func (s *AuditingTokenStore) RemoveAccessToken(ctx context.Context, tokenValue string) {
+	details, _ := s.TokenStore.ReadOAuth2Details(ctx, tokenValue)
+	s.TokenStore.RemoveAccessToken(ctx, tokenValue)
+	s.recorder.Record(ctx, s.removedEvent(audit.EventTokenRotated, tokenValue, "access_token", details))
+}
+
+func (s *AuditingTokenStore) RemoveRefreshToken(ctx context.Context, oauth2Token string) {
+	details, _ := s.TokenStore.ReadOAuth2DetailsForRefreshToken(ctx, oauth2Token)
+	s.TokenStore.RemoveRefreshToken(ctx, oauth2Token)
+	s.recorder.Record(ctx, s.removedEvent(audit.EventRefreshTokenUsed, oauth2Token, "refresh_token", details))
+}
+
+//notRevoked为存储不支持撤销时的事件类型
+func (s *AuditingTokenStore) removedEvent(notRevoked audit.EventType, tokenValue, tokenType string, details *model.OAuth2Details) *audit.Event {
+	eventType := notRevoked
+	if s.TokenStore.SupportsRevocation() {
+		eventType = audit.EventTokenRevoked
+	}
+	event := &audit.Event{
+		Type:      eventType,
+		TokenId:   audit.TokenFingerprint(tokenValue),
+		TokenType: tokenType,
+	}
+	setEventDetails(event, details)
+	return event
+}
+
+//记录客户端认证的结果
+type AuditingClientDetailsService struct {
+	next     ClientDetailsService
+	recorder audit.Recorder
+}
+
+func NewAuditingClientDetailsService(next ClientDetailsService, recorder audit.Recorder) ClientDetailsService {
+	return &AuditingClientDetailsService{
+		next:     next,
+		recorder: recorder,
+	}
+}
+
+func (s *AuditingClientDetailsService) GetClientDetailsByClientId(ctx context.Context, clientId string, clientSecret string) (*model.ClientDetails, error) {
+	clientDetails, err := s.next.GetClientDetailsByClientId(ctx, clientId, clientSecret)
+	s.record(ctx, clientId, err)
+	return clientDetails, err
+}
+
+func (s *AuditingClientDetailsService) GetClientDetailsByCertificate(ctx context.Context, clientId string, certificates []*x509.Certificate) (*model.ClientDetails, error) {
+	clientDetails, err := s.next.GetClientDetailsByCertificate(ctx, clientId, certificates)
+	s.record(ctx, clientId, err)
+	return clientDetails, err
+}
+
+func (s *AuditingClientDetailsService) record(ctx context.Context, clientId string, err error) {
+	event := &audit.Event{
+		Type:     audit.EventClientAuthenticated,
+		ClientId: clientId,
+	}
+	if err != nil {
+		event.Type = audit.EventClientAuthenticationFailure
+		event.Reason = err.Error()
+	}
+	s.recorder.Record(ctx, event)
+}
+
+//记录路由策略拒绝的访问
+type AuditingRoutePolicyService struct {
+	next     RoutePolicyService
+	recorder audit.Recorder
+}
+
+func NewAuditingRoutePolicyService(next RoutePolicyService, recorder audit.Recorder) RoutePolicyService {
+	return &AuditingRoutePolicyService{
+		next:     next,
+		recorder: recorder,
+	}
+}
+
+func (s *AuditingRoutePolicyService) IsPermitted(ctx context.Context, path, method string, details *model.OAuth2Details) bool {
+	if s.next.IsPermitted(ctx, path, method, details) {
+		return true
+	}
+	event := &audit.Event{
+		Type:   audit.EventPermissionDenied,
+		Path:   path,
+		Method: method,
+	}
+	setEventDetails(event, details)
+	s.recorder.Record(ctx, event)
+	return false
+}
+
+//写入令牌绑定的客户端和用户
+func setEventDetails(event *audit.Event, details *model.OAuth2Details) {
+	if details == nil {
+		return
+	}
+	if details.Client != nil {
+		event.ClientId = details.Client.ClientId
+	}
+	if details.User != nil {
+		event.UserId = details.User.UserId
+		event.Username = details.User.UserName
+	}
+}
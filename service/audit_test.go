@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"security/common/audit"
+	"security/model"
+	"strings"
+	"testing"
+)
+
+type eventRecorder struct {
+	events []*audit.Event
+}
+
+func (r *eventRecorder) Record(ctx context.Context, event *audit.Event) {
+	r.events = append(r.events, event)
+}
+
+func (r *eventRecorder) types() []audit.EventType {
+	types := make([]audit.EventType, 0, len(r.events))
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+type failingTokenGrant struct {
+	err error
+}
+
+func (g failingTokenGrant) Grant(ctx context.Context, grantType string, client *model.ClientDetails, r *http.Request) (*model.OAuth2Token, error) {
+	return nil, g.err
+}
+
+//支持撤销的令牌存储
+type revocableTokenStore struct {
+	TokenStore
+}
+
+func (s revocableTokenStore) SupportsRevocation() bool {
+	return true
+}
+
+func TestAuditingTokenGrantLoginFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want []audit.EventType
+	}{
+		{"wrong password", ErrPassword, []audit.EventType{audit.EventLoginFailure}},
+		{"mfa code required", ErrMFACodeRequired, []audit.EventType{}},
+		{"invalid mfa code", ErrInvalidMFACode, []audit.EventType{audit.EventLoginFailure}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &eventRecorder{}
+			grant := NewAuditingTokenGrant(failingTokenGrant{tt.err}, nil, recorder)
+			form := url.Values{"username": {"alice"}}
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			if _, err := grant.Grant(context.Background(), "password", &model.ClientDetails{ClientId: "app"}, r); err != tt.err {
+				t.Fatalf("Grant error = %v, want %v", err, tt.err)
+			}
+			if got := recorder.types(); !equalEventTypes(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditingTokenStoreRemoval(t *testing.T) {
+	tokenService, enhancer := newTestTokenService()
+	token, err := tokenService.CreateAccessToken(context.Background(), newTestOAuth2Details(&model.ClientDetails{
+		ClientId:                    "app",
+		AccessTokenValiditySeconds:  60,
+		RefreshTokenValiditySeconds: 600,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtTokenStore := NewJwtTokenStore(enhancer)
+
+	tests := []struct {
+		name  string
+		store TokenStore
+		want  []audit.EventType
+	}{
+		//JWT无法撤销，移除后令牌仍然有效
+		{"jwt", jwtTokenStore, []audit.EventType{audit.EventTokenRotated, audit.EventRefreshTokenUsed}},
+		{"revocable", revocableTokenStore{jwtTokenStore}, []audit.EventType{audit.EventTokenRevoked, audit.EventTokenRevoked}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &eventRecorder{}
+			store := NewAuditingTokenStore(tt.store, recorder)
+			store.RemoveAccessToken(context.Background(), token.TokenValue)
+			store.RemoveRefreshToken(context.Background(), token.RefreshToken.TokenValue)
+
+			if got := recorder.types(); !equalEventTypes(got, tt.want) {
+				t.Fatalf("events = %v, want %v", got, tt.want)
+			}
+			for _, event := range recorder.events {
+				if event.Username != "alice" || event.ClientId != "app" {
+					t.Fatalf("event details = %q/%q, want alice/app", event.Username, event.ClientId)
+				}
+			}
+		})
+	}
+}
+
+func equalEventTypes(got, want []audit.EventType) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"security/model"
+	"strings"
+)
+
+var ErrRoleHierarchyCycle = errors.New("role hierarchy contains a cycle")
+
+const (
+	//权限字符串的分隔符，如orders:*:read
+	permissionSeparator = ":"
+	//通配符，匹配任意一段
+	permissionWildcard = "*"
+)
+
+/**
+权限策略
+判断用户是否具备访问所需的权限，所需权限可以是角色（如Admin），也可以是权限字符串（如orders:123:read）
+*/
+type AuthorityPolicy interface {
+	IsGranted(ctx context.Context, user *model.UserDetails, required string) bool
+}
+
+/**
+基于角色继承的权限策略
+角色继承使用"Admin > Simple"的形式定义，每行一条，表示Admin拥有Simple的全部权限；
+角色可以绑定权限字符串，用户的Authorities中也可以直接包含权限字符串，权限字符串的每一段都可以使用*通配
+*/
+type RoleHierarchyPolicy struct {
+	//角色 -> 可达的全部角色（包含自身）
+	reachableRoles map[string][]string
+	//角色 -> 绑定的权限字符串
+	rolePermissions map[string][]string
+}
+
+func NewRoleHierarchyPolicy(hierarchy string, rolePermissions map[string][]string) (*RoleHierarchyPolicy, error) {
+	//角色 -> 直接继承的角色
+	children := make(map[string][]string)
+	for _, line := range strings.Split(hierarchy, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		roles := strings.Split(line, ">")
+		for i := 0; i+1 < len(roles); i++ {
+			higher, lower := strings.TrimSpace(roles[i]), strings.TrimSpace(roles[i+1])
+			if higher == "" || lower == "" {
+				return nil, errors.New("invalid role hierarchy: " + line)
+			}
+			children[higher] = append(children[higher], lower)
+		}
+	}
+
+	reachableRoles := make(map[string][]string)
+	for role := range children {
+		reachable, err := reachable(children, role, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		reachableRoles[role] = reachable
+	}
+	if rolePermissions == nil {
+		rolePermissions = make(map[string][]string)
+	}
+	return &RoleHierarchyPolicy{
+		reachableRoles:  reachableRoles,
+		rolePermissions: rolePermissions,
+	}, nil
+}
+
+//深度优先查找角色可达的全部角色，visiting用于检测环
+func reachable(children map[string][]string, role string, visiting map[string]bool) ([]string, error) {
+	if visiting[role] {
+		return nil, ErrRoleHierarchyCycle
+	}
+	visiting[role] = true
+	defer delete(visiting, role)
+
+	result := []string{role}
+	for _, child := range children[role] {
+		roles, err := reachable(children, child, visiting)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, roles...)
+	}
+	return result, nil
+}
+
+func (p *RoleHierarchyPolicy) IsGranted(ctx context.Context, user *model.UserDetails, required string) bool {
+	if user == nil || required == "" {
+		return false
+	}
+	roles, permissions := p.Expand(user.Authorities)
+	if !strings.Contains(required, permissionSeparator) {
+		return roles[required]
+	}
+	for _, permission := range permissions {
+		if ImpliesPermission(permission, required) {
+			return true
+		}
+	}
+	return false
+}
+
+//展开用户拥有的全部角色和权限字符串
+func (p *RoleHierarchyPolicy) Expand(authorities []string) (map[string]bool, []string) {
+	roles := make(map[string]bool)
+	var permissions []string
+	for _, authority := range authorities {
+		if strings.Contains(authority, permissionSeparator) {
+			permissions = append(permissions, authority)
+			continue
+		}
+		reachable, ok := p.reachableRoles[authority]
+		if !ok {
+			reachable = []string{authority}
+		}
+		for _, role := range reachable {
+			if !roles[role] {
+				roles[role] = true
+				permissions = append(permissions, p.rolePermissions[role]...)
+			}
+		}
+	}
+	return roles, permissions
+}
+
+//判断拥有的权限granted是否蕴含所需的权限required
+//每一段相等或granted该段为*即匹配；granted段数较少时，视为后续段全部通配，如orders:*蕴含orders:1:read
+func ImpliesPermission(granted, required string) bool {
+	grantedParts := strings.Split(granted, permissionSeparator)
+	requiredParts := strings.Split(required, permissionSeparator)
+	for i, part := range grantedParts {
+		if i >= len(requiredParts) {
+			//granted比required更具体，多出的段必须全部为通配
+			if part != permissionWildcard {
+				return false
+			}
+			continue
+		}
+		if part != permissionWildcard && part != requiredParts[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"security/model"
+	"testing"
+)
+
+func TestImpliesPermission(t *testing.T) {
+	tests := []struct {
+		granted  string
+		required string
+		want     bool
+	}{
+		{"orders:1:read", "orders:1:read", true},
+		{"orders:1:read", "orders:2:read", false},
+		{"orders:*:read", "orders:2:read", true},
+		{"orders:*:read", "orders:2:write", false},
+		{"*:*:read", "users:7:read", true},
+		//段数较少的权限蕴含更具体的权限
+		{"orders", "orders:1:read", true},
+		{"orders:*", "orders:1:read", true},
+		{"orders:1", "orders:2:read", false},
+		//更具体的权限不蕴含较少段数的权限，除非多出的段全部为通配
+		{"orders:1:read", "orders:1", false},
+		{"orders:1:*", "orders:1", true},
+		{"orders:*:*", "orders", true},
+		{"customers:*", "orders:1:read", false},
+	}
+	for _, tt := range tests {
+		if got := ImpliesPermission(tt.granted, tt.required); got != tt.want {
+			t.Errorf("ImpliesPermission(%q, %q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+		}
+	}
+}
+
+func TestRoleHierarchyPolicyIsGranted(t *testing.T) {
+	policy, err := NewRoleHierarchyPolicy(`
+		Admin > Operator
+		Operator > Simple
+		Auditor > Simple
+	`, map[string][]string{
+		"Simple":   {"orders:*:read"},
+		"Operator": {"orders:*:write"},
+		"Auditor":  {"audit:*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		authorities []string
+		required    string
+		want        bool
+	}{
+		{"own role", []string{"Simple"}, "Simple", true},
+		{"transitive role", []string{"Admin"}, "Simple", true},
+		{"lower role does not imply higher", []string{"Simple"}, "Operator", false},
+		{"sibling role", []string{"Auditor"}, "Operator", false},
+		{"permission of own role", []string{"Simple"}, "orders:1:read", true},
+		{"permission of transitive role", []string{"Admin"}, "orders:1:read", true},
+		{"permission of higher role", []string{"Simple"}, "orders:1:write", false},
+		{"direct permission", []string{"customers:7:read"}, "customers:7:read", true},
+		{"direct wildcard permission", []string{"customers:*"}, "customers:7:read", true},
+		{"role outside the hierarchy", []string{"Guest"}, "Guest", true},
+		{"role outside the hierarchy has no permissions", []string{"Guest"}, "orders:1:read", false},
+		//默认拒绝
+		{"no authorities", nil, "Simple", false},
+		{"unknown permission", []string{"Admin"}, "billing:1:read", false},
+		{"empty requirement", []string{"Admin"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.UserDetails{UserName: "alice", Authorities: tt.authorities}
+			if got := policy.IsGranted(context.Background(), user, tt.required); got != tt.want {
+				t.Fatalf("IsGranted(%v, %q) = %v, want %v", tt.authorities, tt.required, got, tt.want)
+			}
+		})
+	}
+	if policy.IsGranted(context.Background(), nil, "Simple") {
+		t.Fatal("nil user is granted")
+	}
+}
+
+func TestRoleHierarchyPolicyRejectsInvalidHierarchy(t *testing.T) {
+	tests := []struct {
+		name      string
+		hierarchy string
+	}{
+		{"self cycle", "Admin > Admin"},
+		{"two role cycle", "Admin > Simple\nSimple > Admin"},
+		{"chained cycle", "Admin > Operator > Simple > Admin"},
+		{"missing role", "Admin >"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRoleHierarchyPolicy(tt.hierarchy, nil); err == nil {
+				t.Fatalf("NewRoleHierarchyPolicy(%q) accepted an invalid hierarchy", tt.hierarchy)
+			}
+		})
+	}
+	if _, err := NewRoleHierarchyPolicy("Admin > Simple\nSimple > Admin", nil); err != ErrRoleHierarchyCycle {
+		t.Fatalf("cycle error = %v, want %v", err, ErrRoleHierarchyCycle)
+	}
+	//菱形继承不是环
+	if _, err := NewRoleHierarchyPolicy("Admin > Operator\nAdmin > Auditor\nOperator > Simple\nAuditor > Simple", nil); err != nil {
+		t.Fatalf("diamond hierarchy: %v", err)
+	}
+}
@@ -2,28 +2,45 @@ package service
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"security/model"
 )
 
 var (
-	ErrClientExits  = errors.New("client id is not exits")
-	ErrClientSecret = errors.New("invalid client secret")
+	ErrClientExits               = errors.New("client id is not exits")
+	ErrClientSecret              = errors.New("invalid client secret")
+	ErrNoClients                 = errors.New("no client is configured")
+	ErrClientAuthMethod          = errors.New("client authentication method is not allowed for this client")
+	ErrClientCertificate         = errors.New("invalid client certificate")
+	ErrClientCertificateRequired = errors.New("client certificate is required for certificate-bound access tokens")
 )
 
 type ClientDetailsService interface {
 	//根据客户端id加载并验证客户端信息
 	GetClientDetailsByClientId(ctx context.Context, clientId string, clientSecret string) (*model.ClientDetails, error)
+	//根据客户端id加载客户端信息，并使用TLS握手中客户端提供的证书认证，certificates[0]为客户端证书
+	GetClientDetailsByCertificate(ctx context.Context, clientId string, certificates []*x509.Certificate) (*model.ClientDetails, error)
 }
 
+//验证客户端证书由受信任的CA签发，certificates[0]为客户端证书，其余为中间证书
+type ClientCertificateVerifier func(certificates []*x509.Certificate) error
+
+//客户端信息保存在CredentialsHolder中，可以整体替换，正在处理的请求继续使用替换前的客户端信息
 type InMemoryClientDetailsService struct {
-	clientDetailsDict map[string]*model.ClientDetails
+	credentials *CredentialsHolder
+	//tls_client_auth时验证证书链，为nil时不支持tls_client_auth
+	certificateVerifier ClientCertificateVerifier
 }
 
 func (service *InMemoryClientDetailsService) GetClientDetailsByClientId(ctx context.Context, clientId string, clientSecret string) (*model.ClientDetails, error) {
 	//根据clientId 获取clientDetails
-	clientDetails, ok := service.clientDetailsDict[clientId]
+	clientDetails, ok := service.clients()[clientId]
 	if ok {
+		//使用证书认证的客户端不能使用秘钥
+		if clientDetails.TokenEndpointAuthMethod != "" {
+			return nil, ErrClientAuthMethod
+		}
 		//比较clientSecret是否正确
 		if clientDetails.ClientSecret == clientSecret {
 			return clientDetails, nil
@@ -35,17 +52,91 @@ func (service *InMemoryClientDetailsService) GetClientDetailsByClientId(ctx cont
 	}
 }
 
-func NewInMemoryClientDetailService(clientDetailsList []*model.ClientDetails) *InMemoryClientDetailsService {
+//RFC 8705 2.1节和2.2节，TLS握手已经证明客户端持有证书的私钥
+func (service *InMemoryClientDetailsService) GetClientDetailsByCertificate(ctx context.Context, clientId string, certificates []*x509.Certificate) (*model.ClientDetails, error) {
+	clientDetails, ok := service.clients()[clientId]
+	if !ok {
+		return nil, ErrClientExits
+	}
+	if len(certificates) == 0 {
+		return nil, ErrClientCertificate
+	}
+	certificate := certificates[0]
+	switch clientDetails.TokenEndpointAuthMethod {
+	case model.TLSClientAuth:
+		if service.certificateVerifier == nil || service.certificateVerifier(certificates) != nil {
+			return nil, ErrClientCertificate
+		}
+		if certificate.Subject.String() != clientDetails.TLSClientAuthSubjectDN {
+			return nil, ErrClientCertificate
+		}
+		return clientDetails, nil
+	case model.SelfSignedTLSClientAuth:
+		thumbprint := model.CertificateThumbprint(certificate)
+		for _, value := range clientDetails.TLSClientCertificateThumbprints {
+			if value == thumbprint {
+				return clientDetails, nil
+			}
+		}
+		return nil, ErrClientCertificate
+	}
+	return nil, ErrClientAuthMethod
+}
 
-	clientDetailsDict := make(map[string]*model.ClientDetails)
+//没有配置任何客户端时无法签发令牌
+func (service *InMemoryClientDetailsService) HealthCheck(ctx context.Context) error {
+	if len(service.clients()) == 0 {
+		return ErrNoClients
+	}
+	return nil
+}
 
-	if clientDetailsDict != nil {
-		for _, value := range clientDetailsList {
-			clientDetailsDict[value.ClientId] = value
-		}
+//certificateVerifier为nil时使用tls_client_auth的客户端无法认证
+func NewInMemoryClientDetailService(clientDetailsList []*model.ClientDetails, certificateVerifier ClientCertificateVerifier) *InMemoryClientDetailsService {
+	return &InMemoryClientDetailsService{
+		credentials:         NewCredentialsHolder(&Credentials{clients: newClientDetailsDict(clientDetailsList)}),
+		certificateVerifier: certificateVerifier,
 	}
+}
+
+//使用共享的快照，客户端随签名秘钥和用户一起替换
+func NewInMemoryClientDetailServiceWithCredentials(credentials *CredentialsHolder, certificateVerifier ClientCertificateVerifier) *InMemoryClientDetailsService {
 	return &InMemoryClientDetailsService{
-		clientDetailsDict: clientDetailsDict,
+		credentials:         credentials,
+		certificateVerifier: certificateVerifier,
 	}
+}
+
+//只使用新的客户端列表替换全部客户端，如轮换秘钥、增加或删除客户端
+func (service *InMemoryClientDetailsService) Reload(clientDetailsList []*model.ClientDetails) {
+	clientDetailsDict := newClientDetailsDict(clientDetailsList)
+	service.credentials.update(func(credentials *Credentials) {
+		credentials.clients = clientDetailsDict
+	})
+}
+
+//当前的客户端快照
+func (service *InMemoryClientDetailsService) clients() map[string]*model.ClientDetails {
+	return service.credentials.Load().clients
+}
+
+func newClientDetailsDict(clientDetailsList []*model.ClientDetails) map[string]*model.ClientDetails {
+	clientDetailsDict := make(map[string]*model.ClientDetails, len(clientDetailsList))
+	for _, value := range clientDetailsList {
+		clientDetailsDict[value.ClientId] = value
+	}
+	return clientDetailsDict
+}
+
+type clientCertificateKey struct{}
+
+//保存TLS握手中客户端提供的证书，用于签发绑定证书的访问令牌
+func NewClientCertificateContext(ctx context.Context, certificate *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertificateKey{}, certificate)
+}
 
+//获取客户端提供的证书
+func ClientCertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	certificate, ok := ctx.Value(clientCertificateKey{}).(*x509.Certificate)
+	return certificate, ok && certificate != nil
 }
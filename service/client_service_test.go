@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"security/model"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+//签发测试证书，issuer为nil时生成自签名证书
+func newTestCertificate(t *testing.T, subject pkix.Name, isCA bool, issuer *testCertificate) *testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parent, parentKey := template, key
+	if issuer != nil {
+		parent, parentKey = issuer.certificate, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+//只信任ca签发的客户端证书
+func testCertificateVerifier(ca *testCertificate) ClientCertificateVerifier {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	return func(certificates []*x509.Certificate) error {
+		_, err := certificates[0].Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		return err
+	}
+}
+
+func TestGetClientDetailsByCertificate(t *testing.T) {
+	ca := newTestCertificate(t, pkix.Name{CommonName: "Test CA"}, true, nil)
+	subject := pkix.Name{CommonName: "client", Organization: []string{"Example"}}
+	issued := newTestCertificate(t, subject, false, ca)
+	otherSubject := newTestCertificate(t, pkix.Name{CommonName: "other", Organization: []string{"Example"}}, false, ca)
+	untrusted := newTestCertificate(t, subject, false, nil)
+	selfSigned := newTestCertificate(t, pkix.Name{CommonName: "self"}, false, nil)
+	otherSelfSigned := newTestCertificate(t, pkix.Name{CommonName: "self"}, false, nil)
+
+	s := NewInMemoryClientDetailService([]*model.ClientDetails{
+		{ClientId: "mtls", TokenEndpointAuthMethod: model.TLSClientAuth, TLSClientAuthSubjectDN: "CN=client,O=Example"},
+		{ClientId: "self", TokenEndpointAuthMethod: model.SelfSignedTLSClientAuth, TLSClientCertificateThumbprints: []string{"unused", model.CertificateThumbprint(selfSigned.certificate)}},
+		{ClientId: "secret", ClientSecret: "secret"},
+	}, testCertificateVerifier(ca))
+
+	tests := []struct {
+		name        string
+		clientId    string
+		certificate *testCertificate
+		err         error
+	}{
+		{"subject dn matches", "mtls", issued, nil},
+		{"subject dn differs", "mtls", otherSubject, ErrClientCertificate},
+		//主题相同但不是客户端CA签发的证书
+		{"untrusted issuer", "mtls", untrusted, ErrClientCertificate},
+		{"thumbprint matches", "self", selfSigned, nil},
+		{"thumbprint differs", "self", otherSelfSigned, ErrClientCertificate},
+		{"ca signed certificate for self signed client", "self", issued, ErrClientCertificate},
+		{"no certificate", "mtls", nil, ErrClientCertificate},
+		{"secret client", "secret", issued, ErrClientAuthMethod},
+		{"unknown client", "unknown", issued, ErrClientExits},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var certificates []*x509.Certificate
+			if test.certificate != nil {
+				certificates = []*x509.Certificate{test.certificate.certificate}
+			}
+			client, err := s.GetClientDetailsByCertificate(context.Background(), test.clientId, certificates)
+			if err != test.err {
+				t.Fatalf("error = %v, want %v", err, test.err)
+			}
+			if err == nil && client.ClientId != test.clientId {
+				t.Fatalf("client = %s, want %s", client.ClientId, test.clientId)
+			}
+		})
+	}
+
+	//使用证书认证的客户端不能使用秘钥
+	if _, err := s.GetClientDetailsByClientId(context.Background(), "self", ""); err != ErrClientAuthMethod {
+		t.Fatalf("secret authentication for certificate client error = %v, want %v", err, ErrClientAuthMethod)
+	}
+}
+
+func TestGetClientDetailsByCertificateWithoutVerifier(t *testing.T) {
+	ca := newTestCertificate(t, pkix.Name{CommonName: "Test CA"}, true, nil)
+	issued := newTestCertificate(t, pkix.Name{CommonName: "client"}, false, ca)
+	s := NewInMemoryClientDetailService([]*model.ClientDetails{
+		{ClientId: "mtls", TokenEndpointAuthMethod: model.TLSClientAuth, TLSClientAuthSubjectDN: "CN=client"},
+	}, nil)
+	if _, err := s.GetClientDetailsByCertificate(context.Background(), "mtls", []*x509.Certificate{issued.certificate}); err != ErrClientCertificate {
+		t.Fatalf("error = %v, want %v", err, ErrClientCertificate)
+	}
+}
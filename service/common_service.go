@@ -3,7 +3,6 @@ package service
 type Service interface {
 	SimpleData(username string) string
 	AdminData(username string) string
-	HealthCheck() bool
 }
 
 //实现service接口
@@ -21,8 +20,3 @@ func (cs *CommonServices) SimpleData(username string) string {
 func (cs *CommonServices) AdminData(username string) string {
 	return "hello" + username + " ,admin data,with admin authority"
 }
-
-//这里仅仅返回true
-func (cs *CommonServices) HealthCheck() bool {
-	return true
-}
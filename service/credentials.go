@@ -0,0 +1,66 @@
+package service
+
+import (
+	"security/model"
+	"sync"
+)
+
+/**
+签名秘钥、客户端和用户的快照
+三者来自同一份配置，热加载时先构建并校验完整的新快照，再通过CredentialsHolder一次性替换，
+请求不会看到新秘钥配合旧客户端之类新旧混合的配置；新快照构建失败时继续使用原有快照
+*/
+type Credentials struct {
+	//第一个秘钥用于签发
+	signingKeys []SigningKey
+	clients     map[string]*model.ClientDetails
+	users       map[string]*model.UserDetails
+}
+
+//签名秘钥不能为空，客户端和用户为空时由健康检查报告
+func NewCredentials(signingKeys []SigningKey, clientDetailsList []*model.ClientDetails, userDetailsList []*model.UserDetails) (*Credentials, error) {
+	if len(signingKeys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return &Credentials{
+		signingKeys: signingKeys,
+		clients:     newClientDetailsDict(clientDetailsList),
+		users:       newUserDetailsDict(userDetailsList),
+	}, nil
+}
+
+//JWTTokenEnhancer、InMemoryClientDetailsService和InMemoryUserDetailsService共享同一个CredentialsHolder时，
+//Store同时替换三者
+type CredentialsHolder struct {
+	mutex   sync.RWMutex
+	current *Credentials
+}
+
+func NewCredentialsHolder(credentials *Credentials) *CredentialsHolder {
+	return &CredentialsHolder{
+		current: credentials,
+	}
+}
+
+//当前快照，快照创建后不再修改
+func (holder *CredentialsHolder) Load() *Credentials {
+	holder.mutex.RLock()
+	defer holder.mutex.RUnlock()
+	return holder.current
+}
+
+//整体替换快照
+func (holder *CredentialsHolder) Store(credentials *Credentials) {
+	holder.mutex.Lock()
+	holder.current = credentials
+	holder.mutex.Unlock()
+}
+
+//在当前快照的副本上修改其中一部分后替换，供只替换秘钥、客户端或用户的调用使用
+func (holder *CredentialsHolder) update(modify func(credentials *Credentials)) {
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	credentials := *holder.current
+	modify(&credentials)
+	holder.current = &credentials
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"security/model"
+	"testing"
+)
+
+func testCredentials(t *testing.T, keyId, clientSecret, password string) *Credentials {
+	t.Helper()
+	credentials, err := NewCredentials(
+		[]SigningKey{{Id: keyId, Secret: []byte("credentials-test-" + keyId)}},
+		[]*model.ClientDetails{{ClientId: "app", ClientSecret: clientSecret, AccessTokenValiditySeconds: 60}},
+		[]*model.UserDetails{{UserName: "alice", Password: password}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return credentials
+}
+
+//共享同一个CredentialsHolder的秘钥、客户端和用户一起替换
+func TestCredentialsHolderSwapsAllTogether(t *testing.T) {
+	ctx := context.Background()
+	holder := NewCredentialsHolder(testCredentials(t, "v1", "secret1", "password1"))
+	enhancer := NewJWTTokenEnhancerWithCredentials(holder)
+	clients := NewInMemoryClientDetailServiceWithCredentials(holder, nil)
+	users := NewInMemoryUserDetailsServiceWithCredentials(holder, nil)
+
+	holder.Store(testCredentials(t, "v2", "secret2", "password2"))
+
+	if _, err := clients.GetClientDetailsByClientId(ctx, "app", "secret2"); err != nil {
+		t.Fatalf("new client secret: %v", err)
+	}
+	if _, err := clients.GetClientDetailsByClientId(ctx, "app", "secret1"); err != ErrClientSecret {
+		t.Fatalf("old client secret: got %v", err)
+	}
+	if _, err := users.GetUserDetailByUserName(ctx, "alice", "password2"); err != nil {
+		t.Fatalf("new password: %v", err)
+	}
+	if keys := enhancer.signingKeys(); keys[0].Id != "v2" {
+		t.Fatalf("signing key: got %q", keys[0].Id)
+	}
+}
+
+//没有签名秘钥的配置无法构建快照，原有快照保持不变
+func TestNewCredentialsRejectsMissingSigningKey(t *testing.T) {
+	holder := NewCredentialsHolder(testCredentials(t, "v1", "secret1", "password1"))
+	clients := NewInMemoryClientDetailServiceWithCredentials(holder, nil)
+
+	credentials, err := NewCredentials(nil, []*model.ClientDetails{{ClientId: "app", ClientSecret: "secret2"}}, nil)
+	if err != ErrNoSigningKey || credentials != nil {
+		t.Fatalf("got %v, %v", credentials, err)
+	}
+	if _, err := clients.GetClientDetailsByClientId(context.Background(), "app", "secret1"); err != nil {
+		t.Fatalf("previous snapshot: %v", err)
+	}
+}
+
+//只替换一部分时保留快照中的其余部分
+func TestReloadReplacesOnlyItsPart(t *testing.T) {
+	ctx := context.Background()
+	holder := NewCredentialsHolder(testCredentials(t, "v1", "secret1", "password1"))
+	clients := NewInMemoryClientDetailServiceWithCredentials(holder, nil)
+	users := NewInMemoryUserDetailsServiceWithCredentials(holder, nil)
+
+	clients.Reload([]*model.ClientDetails{{ClientId: "app", ClientSecret: "secret2"}})
+
+	if _, err := clients.GetClientDetailsByClientId(ctx, "app", "secret2"); err != nil {
+		t.Fatalf("reloaded client: %v", err)
+	}
+	if _, err := users.GetUserDetailByUserName(ctx, "alice", "password1"); err != nil {
+		t.Fatalf("users: %v", err)
+	}
+	if keys := holder.Load().signingKeys; len(keys) != 1 || keys[0].Id != "v1" {
+		t.Fatalf("signing keys: %v", keys)
+	}
+}
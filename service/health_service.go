@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"security/model"
+	"sync"
+	"time"
+)
+
+var ErrHealthCheckTimeout = errors.New("health check timed out")
+
+//组件的健康检查，返回nil表示健康
+type HealthCheck func(ctx context.Context) error
+
+//可以自行检查健康状态的组件，如用户和客户端信息的存储后端
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+/**
+健康检查
+存活检查失败表示进程需要重启；就绪检查失败表示暂时不能处理请求，注册中心应将实例摘除。
+就绪检查同时包含全部存活检查
+*/
+type HealthService interface {
+	//注册存活检查
+	AddLivenessCheck(name string, check HealthCheck)
+	//注册就绪检查
+	AddReadinessCheck(name string, check HealthCheck)
+	//执行存活检查
+	Live(ctx context.Context) *model.HealthReport
+	//执行就绪检查
+	Ready(ctx context.Context) *model.HealthReport
+}
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+//并发执行已注册的检查，每个检查有独立的超时时间
+type DefaultHealthService struct {
+	timeout         time.Duration
+	mutex           sync.RWMutex
+	livenessChecks  []namedHealthCheck
+	readinessChecks []namedHealthCheck
+}
+
+func NewHealthService(timeout time.Duration) *DefaultHealthService {
+	return &DefaultHealthService{
+		timeout: timeout,
+	}
+}
+
+func (hs *DefaultHealthService) AddLivenessCheck(name string, check HealthCheck) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.livenessChecks = append(hs.livenessChecks, namedHealthCheck{name, check})
+}
+
+func (hs *DefaultHealthService) AddReadinessCheck(name string, check HealthCheck) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+	hs.readinessChecks = append(hs.readinessChecks, namedHealthCheck{name, check})
+}
+
+func (hs *DefaultHealthService) Live(ctx context.Context) *model.HealthReport {
+	hs.mutex.RLock()
+	checks := append([]namedHealthCheck(nil), hs.livenessChecks...)
+	hs.mutex.RUnlock()
+	return hs.run(ctx, checks)
+}
+
+func (hs *DefaultHealthService) Ready(ctx context.Context) *model.HealthReport {
+	hs.mutex.RLock()
+	checks := append(append([]namedHealthCheck(nil), hs.livenessChecks...), hs.readinessChecks...)
+	hs.mutex.RUnlock()
+	return hs.run(ctx, checks)
+}
+
+func (hs *DefaultHealthService) run(ctx context.Context, checks []namedHealthCheck) *model.HealthReport {
+	report := &model.HealthReport{
+		Status:     model.HealthUp,
+		Components: make(map[string]*model.ComponentHealth, len(checks)),
+	}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check namedHealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := hs.runCheck(ctx, check.check)
+			component := &model.ComponentHealth{
+				Status:   model.HealthUp,
+				Duration: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				component.Status = model.HealthDown
+				component.Error = err.Error()
+			}
+			mutex.Lock()
+			report.Components[check.name] = component
+			if err != nil {
+				report.Status = model.HealthDown
+			}
+			mutex.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+//执行单个检查，超时后不再等待检查返回
+func (hs *DefaultHealthService) runCheck(ctx context.Context, check HealthCheck) (err error) {
+	if hs.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hs.timeout)
+		defer cancel()
+	}
+	result := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				result <- fmt.Errorf("health check panic: %v", r)
+			}
+		}()
+		result <- check(ctx)
+	}()
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+		return ErrHealthCheckTimeout
+	}
+}
+
+//签名秘钥检查：使用秘钥签发一个探测令牌并重新解析
+func NewSigningKeyHealthCheck(enhancer TokenEnhancer) HealthCheck {
+	return func(ctx context.Context) error {
+		token, err := enhancer.Enhance(newProbeToken(), newProbeDetails())
+		if err != nil {
+			return err
+		}
+		_, _, err = enhancer.Extract(token.TokenValue)
+		return err
+	}
+}
+
+//令牌存储检查：写入、读取并删除一个探测令牌
+func NewTokenStoreHealthCheck(store TokenStore, enhancer TokenEnhancer) HealthCheck {
+	return func(ctx context.Context) error {
+		details := newProbeDetails()
+		token, err := enhancer.Enhance(newProbeToken(), details)
+		if err != nil {
+			return err
+		}
+		store.StoreAccessToken(ctx, token, details)
+		defer store.RemoveAccessToken(ctx, token.TokenValue)
+		_, err = store.ReadOAuth2Details(ctx, token.TokenValue)
+		return err
+	}
+}
+
+func newProbeToken() *model.OAuth2Token {
+	expiresTime := time.Now().Add(time.Minute)
+	return &model.OAuth2Token{
+		ExpiresTime: &expiresTime,
+	}
+}
+
+func newProbeDetails() *model.OAuth2Details {
+	return &model.OAuth2Details{
+		Client: &model.ClientDetails{ClientId: "health-probe"},
+		User:   &model.UserDetails{UserName: "health-probe"},
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"security/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+func healthCheckOf(err error) HealthCheck {
+	return func(ctx context.Context) error {
+		return err
+	}
+}
+
+func TestHealthServiceLiveAndReady(t *testing.T) {
+	hs := NewHealthService(time.Second)
+	ctx := context.Background()
+	if report := hs.Ready(ctx); !report.IsUp() || len(report.Components) != 0 {
+		t.Fatalf("report without checks = %+v", report)
+	}
+
+	failure := errors.New("user store unavailable")
+	hs.AddLivenessCheck("process", healthCheckOf(nil))
+	hs.AddReadinessCheck("signing_key", healthCheckOf(nil))
+	hs.AddReadinessCheck("user_details", healthCheckOf(failure))
+
+	//就绪检查失败不影响存活检查
+	live := hs.Live(ctx)
+	if !live.IsUp() || len(live.Components) != 1 || live.Components["process"].Status != model.HealthUp {
+		t.Fatalf("live report = %+v", live)
+	}
+
+	//就绪检查包含存活检查，任一组件失败时整体为DOWN
+	ready := hs.Ready(ctx)
+	if ready.IsUp() || len(ready.Components) != 3 {
+		t.Fatalf("ready report = %+v", ready)
+	}
+	for name, want := range map[string]string{"process": model.HealthUp, "signing_key": model.HealthUp, "user_details": model.HealthDown} {
+		if status := ready.Components[name].Status; status != want {
+			t.Errorf("%s status = %s, want %s", name, status, want)
+		}
+	}
+	if got := ready.Components["user_details"].Error; got != failure.Error() {
+		t.Fatalf("user_details error = %q, want %q", got, failure.Error())
+	}
+}
+
+func TestHealthServiceTimeout(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	hs := NewHealthService(timeout)
+	//忽略ctx、一直不返回的检查
+	blocked := make(chan struct{})
+	defer close(blocked)
+	hs.AddReadinessCheck("blocked", func(ctx context.Context) error {
+		<-blocked
+		return nil
+	})
+	slowErr := make(chan error, 1)
+	hs.AddReadinessCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		slowErr <- ctx.Err()
+		return ctx.Err()
+	})
+	hs.AddReadinessCheck("fast", healthCheckOf(nil))
+
+	start := time.Now()
+	report := hs.Ready(context.Background())
+	if elapsed := time.Since(start); elapsed > 10*timeout {
+		t.Fatalf("Ready returned after %v with a %v timeout", elapsed, timeout)
+	}
+	if report.IsUp() {
+		t.Fatal("report is up with timed out checks")
+	}
+	for _, name := range []string{"blocked", "slow"} {
+		component := report.Components[name]
+		if component.Status != model.HealthDown || component.Error != ErrHealthCheckTimeout.Error() {
+			t.Errorf("%s = %+v, want timed out", name, component)
+		}
+	}
+	//超时的检查不影响其他检查
+	if status := report.Components["fast"].Status; status != model.HealthUp {
+		t.Fatalf("fast status = %s", status)
+	}
+	//检查收到的ctx带有超时时间
+	if err := <-slowErr; err != context.DeadlineExceeded {
+		t.Fatalf("check context error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	//调用方的ctx结束时同样不再等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewHealthService(0).runCheck(ctx, func(ctx context.Context) error {
+		<-blocked
+		return nil
+	}); err != ErrHealthCheckTimeout {
+		t.Fatalf("runCheck with a cancelled context error = %v, want %v", err, ErrHealthCheckTimeout)
+	}
+}
+
+func TestHealthServicePanic(t *testing.T) {
+	hs := NewHealthService(time.Second)
+	hs.AddLivenessCheck("panic", func(ctx context.Context) error {
+		panic("nil store")
+	})
+	hs.AddLivenessCheck("healthy", healthCheckOf(nil))
+
+	report := hs.Live(context.Background())
+	component := report.Components["panic"]
+	if report.IsUp() || component.Status != model.HealthDown {
+		t.Fatalf("report with a panicking check = %+v", report)
+	}
+	if !strings.Contains(component.Error, "health check panic: nil store") {
+		t.Fatalf("panic error = %q", component.Error)
+	}
+	if status := report.Components["healthy"].Status; status != model.HealthUp {
+		t.Fatalf("healthy status = %s", status)
+	}
+}
+
+func TestTokenHealthChecks(t *testing.T) {
+	enhancer := NewJWTTokenEnhancer(testSigningKey).(*JWTTokenEnhancer)
+	store := NewJwtTokenStore(enhancer)
+	ctx := context.Background()
+	if err := NewSigningKeyHealthCheck(enhancer)(ctx); err != nil {
+		t.Fatalf("signing key check: %v", err)
+	}
+	if err := NewTokenStoreHealthCheck(store, enhancer)(ctx); err != nil {
+		t.Fatalf("token store check: %v", err)
+	}
+}
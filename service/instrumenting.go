@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"github.com/go-kit/kit/metrics"
+	"security/model"
+	"sync"
+	"time"
+)
+
+/**
+统计未过期访问令牌数量的令牌存储
+令牌存储时记录过期时间，移除或过期后不再计数，数量通过activeTokens上报
+*/
+type InstrumentingTokenStore struct {
+	TokenStore
+	activeTokens metrics.Gauge
+	mutex        sync.Mutex
+	//令牌值 -> 过期时间
+	expires map[string]time.Time
+}
+
+func NewInstrumentingTokenStore(store TokenStore, activeTokens metrics.Gauge) *InstrumentingTokenStore {
+	return &InstrumentingTokenStore{
+		TokenStore:   store,
+		activeTokens: activeTokens,
+		expires:      make(map[string]time.Time),
+	}
+}
+
+func (s *InstrumentingTokenStore) StoreAccessToken(ctx context.Context, token *model.OAuth2Token, details *model.OAuth2Details) {
+	s.TokenStore.StoreAccessToken(ctx, token, details)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if token.ExpiresTime != nil {
+		s.expires[token.TokenValue] = *token.ExpiresTime
+	}
+	s.update()
+}
+
+func (s *InstrumentingTokenStore) RemoveAccessToken(ctx context.Context, tokenValue string) {
+	s.TokenStore.RemoveAccessToken(ctx, tokenValue)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.expires, tokenValue)
+	s.update()
+}
+
+//未过期的访问令牌数量
+func (s *InstrumentingTokenStore) ActiveAccessTokens() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.update()
+	return len(s.expires)
+}
+
+//定期清除过期令牌并更新指标，直到ctx结束
+func (s *InstrumentingTokenStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ActiveAccessTokens()
+		}
+	}
+}
+
+//清除过期令牌并更新指标，调用方需持有锁
+func (s *InstrumentingTokenStore) update() {
+	now := time.Now()
+	for tokenValue, expiresTime := range s.expires {
+		if expiresTime.Before(now) {
+			delete(s.expires, tokenValue)
+		}
+	}
+	s.activeTokens.Set(float64(len(s.expires)))
+}
+
+//统计登录失败次数，标签locked表示失败后账号是否被锁定
+type InstrumentingLoginAttemptService struct {
+	LoginAttemptService
+	failedLogins metrics.Counter
+}
+
+func NewInstrumentingLoginAttemptService(loginAttemptService LoginAttemptService, failedLogins metrics.Counter) *InstrumentingLoginAttemptService {
+	return &InstrumentingLoginAttemptService{
+		LoginAttemptService: loginAttemptService,
+		failedLogins:        failedLogins,
+	}
+}
+
+func (s *InstrumentingLoginAttemptService) LoginFailed(ctx context.Context, username, ip string) {
+	s.LoginAttemptService.LoginFailed(ctx, username, ip)
+	locked := "false"
+	if s.LoginAttemptService.GetLockState(ctx, username).Locked {
+		locked = "true"
+	}
+	s.failedLogins.With("locked", locked).Add(1)
+}
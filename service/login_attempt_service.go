@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"security/model"
+	"sync"
+	"time"
+)
+
+var (
+	//用户名不存在和密码错误统一返回该错误，避免用户枚举
+	ErrBadCredentials = errors.New("bad credentials")
+	ErrAccountLocked  = errors.New("account is temporarily locked")
+)
+
+/**
+登录尝试策略
+前FreeAttempts次失败不做限制，之后每次失败按BaseBackoff指数退避，
+达到MaxAttempts次后锁定LockDuration，超过ResetAfter没有失败则清空记录
+*/
+type LoginAttemptPolicy struct {
+	FreeAttempts int
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	LockDuration time.Duration
+	ResetAfter   time.Duration
+}
+
+//按用户名的默认策略
+var DefaultUserAttemptPolicy = LoginAttemptPolicy{
+	FreeAttempts: 3,
+	MaxAttempts:  10,
+	BaseBackoff:  time.Second,
+	LockDuration: 15 * time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+//按IP的默认策略，同一IP可能对应多个用户，阈值放宽
+var DefaultIPAttemptPolicy = LoginAttemptPolicy{
+	FreeAttempts: 10,
+	MaxAttempts:  50,
+	BaseBackoff:  time.Second,
+	LockDuration: 15 * time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+//用户名和IP各自最多保留的记录数，防止大量不同的用户名或IP耗尽内存
+const DefaultMaxLoginAttemptEntries = 100000
+
+//计算第failures次失败后的等待时长
+func (p LoginAttemptPolicy) backoff(failures int) time.Duration {
+	if failures >= p.MaxAttempts {
+		return p.LockDuration
+	}
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	d := p.BaseBackoff
+	for i := p.FreeAttempts + 1; i < failures; i++ {
+		d *= 2
+		if d >= p.LockDuration {
+			return p.LockDuration
+		}
+	}
+	return d
+}
+
+/**
+登录尝试服务
+按用户名和来源IP分别记录失败次数，对超过阈值的请求进行退避和临时锁定
+*/
+type LoginAttemptService interface {
+	//检查用户名和IP是否允许尝试登录，允许时预先按失败记录本次尝试
+	Check(ctx context.Context, username, ip string) error
+	//本次尝试登录失败
+	LoginFailed(ctx context.Context, username, ip string)
+	//本次尝试登录成功，撤销预先记录的失败，并清空该用户的失败记录
+	LoginSucceeded(ctx context.Context, username, ip string)
+	//密码正确但还需要二次验证，只撤销本次预先记录的失败，之前的失败记录保留到二次验证通过
+	LoginIncomplete(ctx context.Context, username, ip string)
+	//获取用户的锁定状态
+	GetLockState(ctx context.Context, username string) *model.LockState
+}
+
+/**
+实现LoginAttemptService接口
+检查和失败计数在同一个锁内完成，并发的请求在验证密码之前就已计入失败次数，不能同时绕过退避和锁定；
+用户名和IP的记录各自最多保留maxEntries条，已满时先清除过期的记录，仍然已满时淘汰最早失败的记录
+*/
+type InMemoryLoginAttemptService struct {
+	userPolicy LoginAttemptPolicy
+	ipPolicy   LoginAttemptPolicy
+	maxEntries int
+	mutex      sync.Mutex
+	users      map[string]*model.LoginAttempt
+	ips        map[string]*model.LoginAttempt
+	now        func() time.Time
+}
+
+func NewInMemoryLoginAttemptService(userPolicy, ipPolicy LoginAttemptPolicy, maxEntries int) *InMemoryLoginAttemptService {
+	return &InMemoryLoginAttemptService{
+		userPolicy: userPolicy,
+		ipPolicy:   ipPolicy,
+		maxEntries: maxEntries,
+		users:      make(map[string]*model.LoginAttempt),
+		ips:        make(map[string]*model.LoginAttempt),
+		now:        time.Now,
+	}
+}
+
+func (s *InMemoryLoginAttemptService) Check(ctx context.Context, username, ip string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	if attempt := s.load(s.users, s.userPolicy, username, now); attempt != nil && now.Before(attempt.LockedUntil) {
+		return ErrAccountLocked
+	}
+	if attempt := s.load(s.ips, s.ipPolicy, ip, now); attempt != nil && now.Before(attempt.LockedUntil) {
+		return ErrAccountLocked
+	}
+	//认证结果出来之前按失败计数，成功时再撤销
+	s.fail(s.users, s.userPolicy, username, now)
+	s.fail(s.ips, s.ipPolicy, ip, now)
+	return nil
+}
+
+//失败已在Check中记录
+func (s *InMemoryLoginAttemptService) LoginFailed(ctx context.Context, username, ip string) {
+}
+
+func (s *InMemoryLoginAttemptService) LoginSucceeded(ctx context.Context, username, ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.users, username)
+	//IP只撤销本次尝试，其余记录保留，避免攻击者用一个自己的账号反复清空IP计数
+	s.undo(s.ips, s.ipPolicy, ip)
+}
+
+//不清空用户的失败记录，否则知道密码的攻击者可以交替提交空的和猜测的一次性密码绕过锁定
+func (s *InMemoryLoginAttemptService) LoginIncomplete(ctx context.Context, username, ip string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.undo(s.users, s.userPolicy, username)
+	s.undo(s.ips, s.ipPolicy, ip)
+}
+
+func (s *InMemoryLoginAttemptService) GetLockState(ctx context.Context, username string) *model.LockState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	state := &model.LockState{}
+	if attempt := s.load(s.users, s.userPolicy, username, now); attempt != nil {
+		state.FailedAttempts = attempt.FailedAttempts
+		if now.Before(attempt.LockedUntil) {
+			lockedUntil := attempt.LockedUntil
+			state.Locked = true
+			state.LockedUntil = &lockedUntil
+		}
+	}
+	return state
+}
+
+//读取记录，过期的记录直接清除
+func (s *InMemoryLoginAttemptService) load(attempts map[string]*model.LoginAttempt, policy LoginAttemptPolicy, key string, now time.Time) *model.LoginAttempt {
+	if key == "" {
+		return nil
+	}
+	attempt, ok := attempts[key]
+	if !ok {
+		return nil
+	}
+	if now.Sub(attempt.LastFailedTime) > policy.ResetAfter && !now.Before(attempt.LockedUntil) {
+		delete(attempts, key)
+		return nil
+	}
+	return attempt
+}
+
+func (s *InMemoryLoginAttemptService) fail(attempts map[string]*model.LoginAttempt, policy LoginAttemptPolicy, key string, now time.Time) {
+	if key == "" {
+		return
+	}
+	attempt := s.load(attempts, policy, key, now)
+	if attempt == nil {
+		if len(attempts) >= s.maxEntries {
+			s.prune(attempts, policy, now)
+		}
+		attempt = &model.LoginAttempt{}
+		attempts[key] = attempt
+	}
+	attempt.FailedAttempts++
+	attempt.LastFailedTime = now
+	if wait := policy.backoff(attempt.FailedAttempts); wait > 0 {
+		attempt.LockedUntil = now.Add(wait)
+	}
+}
+
+//撤销Check中预先记录的一次失败，并按剩余的失败次数重新计算退避时间
+func (s *InMemoryLoginAttemptService) undo(attempts map[string]*model.LoginAttempt, policy LoginAttemptPolicy, key string) {
+	attempt, ok := attempts[key]
+	if !ok {
+		return
+	}
+	attempt.FailedAttempts--
+	if attempt.FailedAttempts <= 0 {
+		delete(attempts, key)
+		return
+	}
+	attempt.LockedUntil = time.Time{}
+	if wait := policy.backoff(attempt.FailedAttempts); wait > 0 {
+		attempt.LockedUntil = attempt.LastFailedTime.Add(wait)
+	}
+}
+
+//清除过期的记录，仍然已满时淘汰最早失败的一条
+func (s *InMemoryLoginAttemptService) prune(attempts map[string]*model.LoginAttempt, policy LoginAttemptPolicy, now time.Time) {
+	var (
+		oldestKey  string
+		oldestTime time.Time
+	)
+	for key, attempt := range attempts {
+		if now.Sub(attempt.LastFailedTime) > policy.ResetAfter && !now.Before(attempt.LockedUntil) {
+			delete(attempts, key)
+			continue
+		}
+		if oldestKey == "" || attempt.LastFailedTime.Before(oldestTime) {
+			oldestKey, oldestTime = key, attempt.LastFailedTime
+		}
+	}
+	if len(attempts) >= s.maxEntries {
+		delete(attempts, oldestKey)
+	}
+}
+
+//获取请求来源IP
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
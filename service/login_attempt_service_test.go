@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testAttemptPolicy = LoginAttemptPolicy{
+	FreeAttempts: 3,
+	MaxAttempts:  5,
+	BaseBackoff:  time.Second,
+	LockDuration: time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+func TestLoginAttemptConcurrentChecks(t *testing.T) {
+	s := NewInMemoryLoginAttemptService(testAttemptPolicy, testAttemptPolicy, 10)
+	ctx := context.Background()
+
+	//并发的请求在验证密码之前检查，只有免费次数内的请求能通过
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.Check(ctx, "alice", "10.0.0.1") == nil {
+				mutex.Lock()
+				allowed++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != testAttemptPolicy.FreeAttempts+1 {
+		t.Fatalf("allowed %d concurrent attempts, want %d", allowed, testAttemptPolicy.FreeAttempts+1)
+	}
+	if state := s.GetLockState(ctx, "alice"); !state.Locked {
+		t.Fatalf("user is not locked after %d attempts", allowed)
+	}
+}
+
+func TestLoginAttemptSucceededUndoesCheck(t *testing.T) {
+	s := NewInMemoryLoginAttemptService(testAttemptPolicy, testAttemptPolicy, 10)
+	ctx := context.Background()
+
+	//同一IP上的成功登录不计入IP的失败次数
+	for i := 0; i < testAttemptPolicy.MaxAttempts*2; i++ {
+		username := fmt.Sprintf("user%d", i)
+		if err := s.Check(ctx, username, "10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		s.LoginSucceeded(ctx, username, "10.0.0.1")
+	}
+	if len(s.ips) != 0 || len(s.users) != 0 {
+		t.Fatalf("records left after successful logins: users %d, ips %d", len(s.users), len(s.ips))
+	}
+
+	//失败之后的成功登录只撤销本次尝试
+	if err := s.Check(ctx, "bob", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	s.LoginFailed(ctx, "bob", "10.0.0.2")
+	if err := s.Check(ctx, "carol", "10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	s.LoginSucceeded(ctx, "carol", "10.0.0.2")
+	if attempt := s.ips["10.0.0.2"]; attempt == nil || attempt.FailedAttempts != 1 {
+		t.Fatalf("ip record = %+v, want 1 failed attempt", attempt)
+	}
+}
+
+func TestLoginAttemptMaxEntries(t *testing.T) {
+	s := NewInMemoryLoginAttemptService(testAttemptPolicy, testAttemptPolicy, 3)
+	ctx := context.Background()
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		s.Check(ctx, fmt.Sprintf("user%d", i), "")
+		now = now.Add(time.Minute)
+	}
+	//已满时淘汰最早失败的记录
+	s.Check(ctx, "user3", "")
+	if _, ok := s.users["user0"]; ok || len(s.users) != 3 {
+		t.Fatalf("users = %v, want user0 evicted", s.users)
+	}
+
+	//过期的记录优先清除
+	now = now.Add(testAttemptPolicy.ResetAfter - time.Minute + time.Second)
+	s.Check(ctx, "user4", "")
+	s.Check(ctx, "user5", "")
+	if len(s.users) != 3 {
+		t.Fatalf("users = %v, want 3 records", s.users)
+	}
+	for _, username := range []string{"user3", "user4", "user5"} {
+		if _, ok := s.users[username]; !ok {
+			t.Fatalf("users = %v, want %s kept", s.users, username)
+		}
+	}
+}
+
+func TestLoginAttemptIncompleteKeepsFailures(t *testing.T) {
+	s := NewInMemoryLoginAttemptService(testAttemptPolicy, testAttemptPolicy, 10)
+	ctx := context.Background()
+
+	if err := s.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	s.LoginFailed(ctx, "alice", "10.0.0.1")
+	//等待二次验证的尝试不计入失败，也不清空之前的失败
+	if err := s.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	s.LoginIncomplete(ctx, "alice", "10.0.0.1")
+	if state := s.GetLockState(ctx, "alice"); state.FailedAttempts != 1 {
+		t.Fatalf("user failed attempts = %d, want 1", state.FailedAttempts)
+	}
+	if attempt := s.ips["10.0.0.1"]; attempt == nil || attempt.FailedAttempts != 1 {
+		t.Fatalf("ip record = %+v, want 1 failed attempt", attempt)
+	}
+}
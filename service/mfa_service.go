@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"security/common/totp"
+	"security/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+//认证方式，对应RFC 8176中amr声明的取值
+const (
+	AmrPassword     = "pwd"
+	AmrOTP          = "otp"
+	AmrMultiFactor  = "mfa"
+	AmrRecoveryCode = "recovery"
+)
+
+var (
+	ErrMFACodeRequired       = errors.New("mfa code is required")
+	ErrInvalidMFACode        = errors.New("invalid mfa code")
+	ErrMFAEnrollmentRequired = errors.New("mfa enrollment is required")
+)
+
+const (
+	//生成的恢复码数量
+	recoveryCodeCount = 10
+	//允许前后一个步长的时钟偏差
+	totpSkew = 1
+	//新秘钥等待确认的时间
+	pendingEnrollmentTTL = 10 * time.Minute
+)
+
+/**
+二次验证服务
+负责TOTP的绑定和一次性密码、恢复码的校验
+*/
+type MFAService interface {
+	//用户是否需要二次验证：已绑定TOTP，或拥有要求二次验证的权限
+	IsRequired(ctx context.Context, user *model.UserDetails) bool
+	//校验一次性密码或恢复码，成功时返回使用的认证方式
+	Verify(ctx context.Context, user *model.UserDetails, code string) (string, error)
+	//验证用户凭证后生成等待确认的TOTP秘钥和恢复码，已绑定的用户需要提供当前的一次性密码或恢复码；
+	//再次调用并提供新秘钥生成的一次性密码后绑定生效
+	Enroll(ctx context.Context, username, password, code, ip string) (*model.TOTPEnrollment, error)
+}
+
+//实现MFAService接口，TOTP秘钥和恢复码按用户名保存在MFAEnrollmentStore中，不修改用户信息，
+//因此用户信息被重新加载或服务重启时二次验证的绑定保持不变
+type TOTPMFAService struct {
+	issuer              string
+	requiredAuthorities []string
+	userDetailsService  UserDetailsService
+	loginAttemptService LoginAttemptService
+	store               MFAEnrollmentStore
+	//串行化绑定的读取和修改，保证一次性密码和恢复码只能使用一次
+	mutex sync.Mutex
+	now   func() time.Time
+}
+
+//拥有requiredAuthorities中任一权限的用户必须绑定TOTP才能登录
+func NewTOTPMFAService(issuer string, requiredAuthorities []string, userDetailsService UserDetailsService, loginAttemptService LoginAttemptService, store MFAEnrollmentStore) *TOTPMFAService {
+	return &TOTPMFAService{
+		issuer:              issuer,
+		requiredAuthorities: requiredAuthorities,
+		userDetailsService:  userDetailsService,
+		loginAttemptService: loginAttemptService,
+		store:               store,
+		now:                 time.Now,
+	}
+}
+
+func (s *TOTPMFAService) IsRequired(ctx context.Context, user *model.UserDetails) bool {
+	enrollment, err := s.store.Get(ctx, user.UserName)
+	//无法读取绑定时要求二次验证，由Verify返回错误
+	if err != nil || enrollment.Enrolled() {
+		return true
+	}
+	for _, authority := range user.Authorities {
+		for _, required := range s.requiredAuthorities {
+			if authority == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *TOTPMFAService) Verify(ctx context.Context, user *model.UserDetails, code string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	enrollment, err := s.store.Get(ctx, user.UserName)
+	if err != nil {
+		return "", err
+	}
+	if !enrollment.Enrolled() {
+		return "", ErrMFAEnrollmentRequired
+	}
+	method, err := s.verify(enrollment, code)
+	if err != nil {
+		return "", err
+	}
+	//保存使用过的步数和恢复码
+	if err := s.store.Put(ctx, user.UserName, enrollment); err != nil {
+		return "", err
+	}
+	return method, nil
+}
+
+func (s *TOTPMFAService) Enroll(ctx context.Context, username, password, code, ip string) (*model.TOTPEnrollment, error) {
+	user, err := authenticateUser(ctx, s.userDetailsService, s.loginAttemptService, username, password, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	enrollment, err := s.store.Get(ctx, user.UserName)
+	if err != nil {
+		return nil, err
+	}
+	if enrollment == nil {
+		enrollment = &MFAEnrollment{}
+	}
+	code = strings.TrimSpace(code)
+	now := s.now()
+
+	//使用新秘钥生成的一次性密码确认等待中的绑定
+	if enrollment.PendingSecret != "" && now.Before(enrollment.PendingExpiresAt) && code != "" {
+		if step, ok := totp.Validate(enrollment.PendingSecret, code, now, totpSkew); ok {
+			enrollment.Secret = enrollment.PendingSecret
+			enrollment.RecoveryCodes = enrollment.PendingRecoveryCodes
+			enrollment.LastStep = step
+			enrollment.PendingSecret, enrollment.PendingRecoveryCodes, enrollment.PendingExpiresAt = "", nil, time.Time{}
+			if err := s.store.Put(ctx, user.UserName, enrollment); err != nil {
+				return nil, err
+			}
+			s.enrollSucceeded(ctx, username, ip)
+			return &model.TOTPEnrollment{Status: model.TOTPEnrollmentActive}, nil
+		}
+	}
+
+	//已绑定的用户重新绑定需要当前的一次性密码或恢复码，未绑定的用户只在确认时提供一次性密码
+	if enrollment.Enrolled() {
+		if _, err := s.verify(enrollment, code); err != nil {
+			s.enrollFailed(ctx, username, ip, err)
+			return nil, err
+		}
+	} else if code != "" {
+		s.enrollFailed(ctx, username, ip, ErrInvalidMFACode)
+		return nil, ErrInvalidMFACode
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	recoveryCodes := make([]string, recoveryCodeCount)
+	hashedCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		if recoveryCodes[i], err = generateRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashedCodes[i] = hashRecoveryCode(recoveryCodes[i])
+	}
+	//新秘钥确认之前，原有的绑定继续有效
+	enrollment.PendingSecret = secret
+	enrollment.PendingRecoveryCodes = hashedCodes
+	enrollment.PendingExpiresAt = now.Add(pendingEnrollmentTTL)
+	if err := s.store.Put(ctx, user.UserName, enrollment); err != nil {
+		return nil, err
+	}
+	s.enrollSucceeded(ctx, username, ip)
+
+	return &model.TOTPEnrollment{
+		Status:        model.TOTPEnrollmentPending,
+		Secret:        secret,
+		KeyURI:        totp.KeyURI(s.issuer, user.UserName, secret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (s *TOTPMFAService) enrollSucceeded(ctx context.Context, username, ip string) {
+	if s.loginAttemptService != nil {
+		s.loginAttemptService.LoginSucceeded(ctx, username, ip)
+	}
+}
+
+func (s *TOTPMFAService) enrollFailed(ctx context.Context, username, ip string, err error) {
+	if s.loginAttemptService == nil {
+		return
+	}
+	switch err {
+	case ErrInvalidMFACode:
+		s.loginAttemptService.LoginFailed(ctx, username, ip)
+	case ErrMFACodeRequired:
+		s.loginAttemptService.LoginIncomplete(ctx, username, ip)
+	}
+}
+
+//校验成功时修改enrollment中使用过的步数或恢复码，调用方需持有锁并保存enrollment
+func (s *TOTPMFAService) verify(enrollment *MFAEnrollment, code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", ErrMFACodeRequired
+	}
+	if step, ok := totp.Validate(enrollment.Secret, code, s.now(), totpSkew); ok {
+		//同一步长内的一次性密码只能使用一次
+		if step <= enrollment.LastStep {
+			return "", ErrInvalidMFACode
+		}
+		enrollment.LastStep = step
+		return AmrOTP, nil
+	}
+	//尝试作为恢复码使用
+	hashed := hashRecoveryCode(code)
+	for i, value := range enrollment.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(hashed)) == 1 {
+			enrollment.RecoveryCodes = append(enrollment.RecoveryCodes[:i:i], enrollment.RecoveryCodes[i+1:]...)
+			return AmrRecoveryCode, nil
+		}
+	}
+	return "", ErrInvalidMFACode
+}
+
+//生成形如xxxxx-xxxxx的恢复码
+func generateRecoveryCode() (string, error) {
+	buf := make([]byte, 7)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
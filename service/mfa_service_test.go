@@ -0,0 +1,255 @@
+package service
+
+import (
+	"context"
+	"path/filepath"
+	"security/common/totp"
+	"security/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMFAService(t *testing.T) (*TOTPMFAService, *model.UserDetails) {
+	t.Helper()
+	return newTestMFAServiceWithStore(t, NewMemoryMFAEnrollmentStore())
+}
+
+func newTestMFAServiceWithStore(t *testing.T, store MFAEnrollmentStore) (*TOTPMFAService, *model.UserDetails) {
+	t.Helper()
+	user := &model.UserDetails{UserName: "alice", Password: "password"}
+	s := NewTOTPMFAService("security", nil, NewInMemoryUserDetailsService([]*model.UserDetails{user}, nil), nil, store)
+	now := time.Unix(1234567890, 0)
+	s.now = func() time.Time { return now }
+	return s, user
+}
+
+//生成新秘钥并使用上一步长的一次性密码确认，code为重新绑定时需要的当前一次性密码或恢复码
+func enroll(t *testing.T, s *TOTPMFAService, code string) *model.TOTPEnrollment {
+	t.Helper()
+	ctx := context.Background()
+	pending, err := s.Enroll(ctx, "alice", "password", code, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pending.Status != model.TOTPEnrollmentPending {
+		t.Fatalf("status = %s, want %s", pending.Status, model.TOTPEnrollmentPending)
+	}
+	confirmed, err := s.Enroll(ctx, "alice", "password", codeAt(t, pending.Secret, s.now().Add(-totp.Period*time.Second)), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.Status != model.TOTPEnrollmentActive || confirmed.Secret != "" {
+		t.Fatalf("confirmation = %+v, want active without secret", confirmed)
+	}
+	return pending
+}
+
+func codeAt(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCode(secret, totp.Step(at))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestMFAVerifyTOTPReplay(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	enrollment := enroll(t, s, "")
+	now := s.now()
+
+	tests := []struct {
+		name   string
+		code   string
+		method string
+		err    error
+	}{
+		{"empty code", " ", "", ErrMFACodeRequired},
+		{"current code", codeAt(t, enrollment.Secret, now), AmrOTP, nil},
+		//同一步长内的一次性密码不能重放
+		{"replayed code", codeAt(t, enrollment.Secret, now), "", ErrInvalidMFACode},
+		//已使用过的步长之前的一次性密码也不能使用
+		{"earlier code within skew", codeAt(t, enrollment.Secret, now.Add(-totp.Period*time.Second)), "", ErrInvalidMFACode},
+		{"next code within skew", codeAt(t, enrollment.Secret, now.Add(totp.Period*time.Second)), AmrOTP, nil},
+		{"wrong code", "000000", "", ErrInvalidMFACode},
+	}
+	for _, test := range tests {
+		method, err := s.Verify(ctx, user, test.code)
+		if method != test.method || err != test.err {
+			t.Fatalf("%s: Verify = %q, %v; want %q, %v", test.name, method, err, test.method, test.err)
+		}
+	}
+}
+
+func TestMFARecoveryCodeConsumption(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	enrollment := enroll(t, s, "")
+	if len(enrollment.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(enrollment.RecoveryCodes), recoveryCodeCount)
+	}
+
+	tests := []struct {
+		name   string
+		code   string
+		method string
+		err    error
+	}{
+		//恢复码不区分大小写，忽略首尾空格
+		{"first code", " " + strings.ToUpper(enrollment.RecoveryCodes[0]) + " ", AmrRecoveryCode, nil},
+		{"first code again", enrollment.RecoveryCodes[0], "", ErrInvalidMFACode},
+		{"last code", enrollment.RecoveryCodes[recoveryCodeCount-1], AmrRecoveryCode, nil},
+		{"last code again", enrollment.RecoveryCodes[recoveryCodeCount-1], "", ErrInvalidMFACode},
+		{"unknown code", "aaaaa-aaaaa", "", ErrInvalidMFACode},
+		{"second code", enrollment.RecoveryCodes[1], AmrRecoveryCode, nil},
+	}
+	for _, test := range tests {
+		method, err := s.Verify(ctx, user, test.code)
+		if method != test.method || err != test.err {
+			t.Fatalf("%s: Verify = %q, %v; want %q, %v", test.name, method, err, test.method, test.err)
+		}
+	}
+}
+
+func TestMFAReEnroll(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	if _, err := s.Verify(ctx, user, "123456"); err != ErrMFAEnrollmentRequired {
+		t.Fatalf("Verify before enrollment error = %v, want %v", err, ErrMFAEnrollmentRequired)
+	}
+	if s.IsRequired(ctx, user) {
+		t.Fatal("user without enrollment or required authority requires mfa")
+	}
+	first := enroll(t, s, "")
+	if !s.IsRequired(ctx, user) {
+		t.Fatal("enrolled user does not require mfa")
+	}
+
+	//重新绑定需要当前的二次验证
+	if _, err := s.Enroll(ctx, "alice", "password", "", ""); err != ErrMFACodeRequired {
+		t.Fatalf("Enroll without code error = %v, want %v", err, ErrMFACodeRequired)
+	}
+	if _, err := s.Enroll(ctx, "alice", "wrong", first.RecoveryCodes[0], ""); err != ErrBadCredentials {
+		t.Fatalf("Enroll with wrong password error = %v, want %v", err, ErrBadCredentials)
+	}
+	second := enroll(t, s, first.RecoveryCodes[0])
+
+	//旧的恢复码失效
+	if _, err := s.Verify(ctx, user, first.RecoveryCodes[1]); err != ErrInvalidMFACode {
+		t.Fatalf("Verify with old recovery code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if method, err := s.Verify(ctx, user, codeAt(t, second.Secret, s.now())); err != nil || method != AmrOTP {
+		t.Fatalf("Verify with new secret = %q, %v", method, err)
+	}
+}
+
+func TestMFAConcurrentRecoveryCode(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	enrollment := enroll(t, s, "")
+
+	//同一个恢复码被并发使用时只有一次成功
+	var (
+		wg        sync.WaitGroup
+		mutex     sync.Mutex
+		succeeded int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.Verify(ctx, user, enrollment.RecoveryCodes[0]); err == nil {
+				mutex.Lock()
+				succeeded++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Fatalf("recovery code used %d times, want 1", succeeded)
+	}
+}
+
+func TestMFAEnrollmentConfirmation(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	pending, err := s.Enroll(ctx, "alice", "password", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//确认之前新秘钥不生效
+	if s.IsRequired(ctx, user) {
+		t.Fatal("pending enrollment requires mfa")
+	}
+	if _, err := s.Verify(ctx, user, codeAt(t, pending.Secret, s.now())); err != ErrMFAEnrollmentRequired {
+		t.Fatalf("Verify with pending secret error = %v, want %v", err, ErrMFAEnrollmentRequired)
+	}
+	if _, err := s.Enroll(ctx, "alice", "password", "000000", ""); err != ErrInvalidMFACode {
+		t.Fatalf("confirm with wrong code error = %v, want %v", err, ErrInvalidMFACode)
+	}
+
+	//超过确认时间后新秘钥作废
+	now := s.now()
+	s.now = func() time.Time { return now.Add(pendingEnrollmentTTL + time.Second) }
+	if _, err := s.Enroll(ctx, "alice", "password", codeAt(t, pending.Secret, s.now()), ""); err != ErrInvalidMFACode {
+		t.Fatalf("confirm after expiry error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if s.IsRequired(ctx, user) {
+		t.Fatal("expired pending enrollment requires mfa")
+	}
+}
+
+func TestMFAReEnrollKeepsSecretUntilConfirmed(t *testing.T) {
+	s, user := newTestMFAService(t)
+	ctx := context.Background()
+	first := enroll(t, s, "")
+	second, err := s.Enroll(ctx, "alice", "password", first.RecoveryCodes[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//新秘钥确认之前仍然使用原有的秘钥
+	if _, err := s.Verify(ctx, user, codeAt(t, second.Secret, s.now())); err != ErrInvalidMFACode {
+		t.Fatalf("Verify with unconfirmed secret error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if method, err := s.Verify(ctx, user, codeAt(t, first.Secret, s.now())); err != nil || method != AmrOTP {
+		t.Fatalf("Verify with current secret = %q, %v", method, err)
+	}
+}
+
+func TestMFAEnrollmentPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mfa.json")
+	store, err := NewFileMFAEnrollmentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, user := newTestMFAServiceWithStore(t, store)
+	ctx := context.Background()
+	enrollment := enroll(t, s, "")
+	code := codeAt(t, enrollment.Secret, s.now())
+	if _, err := s.Verify(ctx, user, code); err != nil {
+		t.Fatal(err)
+	}
+
+	//重启后绑定和已使用的一次性密码仍然有效
+	store, err = NewFileMFAEnrollmentStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, user = newTestMFAServiceWithStore(t, store)
+	if !s.IsRequired(ctx, user) {
+		t.Fatal("enrollment is lost after restart")
+	}
+	if _, err := s.Enroll(ctx, "alice", "password", "", ""); err != ErrMFACodeRequired {
+		t.Fatalf("re-enroll without code after restart error = %v, want %v", err, ErrMFACodeRequired)
+	}
+	if _, err := s.Verify(ctx, user, code); err != ErrInvalidMFACode {
+		t.Fatalf("replayed code after restart error = %v, want %v", err, ErrInvalidMFACode)
+	}
+	if method, err := s.Verify(ctx, user, enrollment.RecoveryCodes[0]); err != nil || method != AmrRecoveryCode {
+		t.Fatalf("Verify with recovery code after restart = %q, %v", method, err)
+	}
+}
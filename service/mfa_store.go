@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/**
+二次验证绑定的存储
+绑定必须在重启后保留，否则已绑定的用户会退回到未绑定状态，只凭密码就能重新绑定
+*/
+type MFAEnrollmentStore interface {
+	//获取用户的绑定，不存在时返回nil
+	Get(ctx context.Context, username string) (*MFAEnrollment, error)
+	//保存用户的绑定
+	Put(ctx context.Context, username string, enrollment *MFAEnrollment) error
+}
+
+//用户的TOTP绑定
+type MFAEnrollment struct {
+	//已确认的TOTP秘钥，base32编码，为空时未绑定
+	Secret string `json:"secret,omitempty"`
+	//已确认的恢复码的SHA-256摘要，每个恢复码只能使用一次
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	//最后一次使用的步数，防止一次性密码被重放
+	LastStep int64 `json:"last_step,omitempty"`
+	//等待确认的新秘钥和恢复码，使用新秘钥生成的一次性密码确认后生效
+	PendingSecret        string    `json:"pending_secret,omitempty"`
+	PendingRecoveryCodes []string  `json:"pending_recovery_codes,omitempty"`
+	PendingExpiresAt     time.Time `json:"pending_expires_at,omitempty"`
+}
+
+//是否已确认绑定
+func (e *MFAEnrollment) Enrolled() bool {
+	return e != nil && e.Secret != ""
+}
+
+//只保存在内存中的绑定，重启后丢失，只用于测试和开发环境
+type MemoryMFAEnrollmentStore struct {
+	mutex       sync.RWMutex
+	enrollments map[string]MFAEnrollment
+}
+
+func NewMemoryMFAEnrollmentStore() *MemoryMFAEnrollmentStore {
+	return &MemoryMFAEnrollmentStore{
+		enrollments: make(map[string]MFAEnrollment),
+	}
+}
+
+func (s *MemoryMFAEnrollmentStore) Get(ctx context.Context, username string) (*MFAEnrollment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	enrollment, ok := s.enrollments[username]
+	if !ok {
+		return nil, nil
+	}
+	return enrollment.clone(), nil
+}
+
+func (s *MemoryMFAEnrollmentStore) Put(ctx context.Context, username string, enrollment *MFAEnrollment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.enrollments[username] = *enrollment.clone()
+	return nil
+}
+
+/**
+保存在JSON文件中的绑定
+每次修改都写入临时文件后重命名，写入失败时文件保持原样；文件包含TOTP秘钥，权限为0600
+*/
+type FileMFAEnrollmentStore struct {
+	path        string
+	mutex       sync.RWMutex
+	enrollments map[string]MFAEnrollment
+}
+
+//文件不存在时从空的绑定开始
+func NewFileMFAEnrollmentStore(path string) (*FileMFAEnrollmentStore, error) {
+	enrollments := make(map[string]MFAEnrollment)
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &enrollments); err != nil {
+			return nil, err
+		}
+	}
+	return &FileMFAEnrollmentStore{
+		path:        path,
+		enrollments: enrollments,
+	}, nil
+}
+
+func (s *FileMFAEnrollmentStore) Get(ctx context.Context, username string) (*MFAEnrollment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	enrollment, ok := s.enrollments[username]
+	if !ok {
+		return nil, nil
+	}
+	return enrollment.clone(), nil
+}
+
+func (s *FileMFAEnrollmentStore) Put(ctx context.Context, username string, enrollment *MFAEnrollment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	enrollments := make(map[string]MFAEnrollment, len(s.enrollments)+1)
+	for key, value := range s.enrollments {
+		enrollments[key] = value
+	}
+	enrollments[username] = *enrollment.clone()
+	if err := writeFileAtomic(s.path, enrollments); err != nil {
+		return err
+	}
+	s.enrollments = enrollments
+	return nil
+}
+
+func writeFileAtomic(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (e *MFAEnrollment) clone() *MFAEnrollment {
+	clone := *e
+	clone.RecoveryCodes = append([]string(nil), e.RecoveryCodes...)
+	clone.PendingRecoveryCodes = append([]string(nil), e.PendingRecoveryCodes...)
+	return &clone
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"security/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RoutePolicyAllow = "allow"
+	RoutePolicyDeny  = "deny"
+)
+
+var ErrInvalidRoutePolicy = errors.New("invalid route policy")
+
+/**
+路由访问策略，对应策略文件中的一条规则
+同一字段内满足任一即可（Scopes要求全部满足），不同字段之间需要同时满足，空字段表示不做限制
+*/
+type RoutePolicy struct {
+	//路由模板，与mux注册的路径一致，如/admin
+	Path string `yaml:"path"`
+	//HTTP方法，为空表示全部方法
+	Methods []string `yaml:"methods"`
+	//所需的角色或权限字符串，经AuthorityPolicy判断，满足其一即可
+	Authorities []string `yaml:"authorities"`
+	//客户端必须具备的全部授权范围
+	Scopes []string `yaml:"scopes"`
+	//允许访问的客户端ID
+	Clients []string `yaml:"clients"`
+}
+
+//策略文件
+type RoutePolicyConfig struct {
+	//没有规则匹配时的处理方式：allow或deny，默认deny
+	Default string        `yaml:"default"`
+	Rules   []RoutePolicy `yaml:"rules"`
+}
+
+/**
+路由策略服务
+根据请求的路由和方法，判断令牌对应的用户和客户端是否允许访问
+*/
+type RoutePolicyService interface {
+	IsPermitted(ctx context.Context, path, method string, details *model.OAuth2Details) bool
+}
+
+//基于策略文件的路由策略服务，文件修改后自动重新加载
+type FileRoutePolicyService struct {
+	path            string
+	authorityPolicy AuthorityPolicy
+	logger          *log.Logger
+	mutex           sync.RWMutex
+	config          *RoutePolicyConfig
+	modTime         time.Time
+}
+
+func NewFileRoutePolicyService(path string, authorityPolicy AuthorityPolicy, logger *log.Logger) (*FileRoutePolicyService, error) {
+	s := &FileRoutePolicyService{
+		path:            path,
+		authorityPolicy: authorityPolicy,
+		logger:          logger,
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//重新加载策略文件，文件有误时保留原有策略
+func (s *FileRoutePolicyService) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	config, err := ParseRoutePolicyConfig(data)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.config = config
+	s.modTime = info.ModTime()
+	s.mutex.Unlock()
+	return nil
+}
+
+//定期检查策略文件的修改时间，发生变化时重新加载，直到ctx结束
+func (s *FileRoutePolicyService) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				s.logger.Println("stat route policy file error:", err)
+				continue
+			}
+			s.mutex.RLock()
+			modTime := s.modTime
+			s.mutex.RUnlock()
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				s.logger.Println("reload route policy file error:", err)
+				continue
+			}
+			s.logger.Println("route policy reloaded")
+		}
+	}
+}
+
+func (s *FileRoutePolicyService) IsPermitted(ctx context.Context, path, method string, details *model.OAuth2Details) bool {
+	s.mutex.RLock()
+	config := s.config
+	s.mutex.RUnlock()
+
+	for _, rule := range config.Rules {
+		if rule.matches(path, method) {
+			return rule.permits(ctx, s.authorityPolicy, details)
+		}
+	}
+	return config.Default == RoutePolicyAllow
+}
+
+//解析并校验策略文件
+func ParseRoutePolicyConfig(data []byte) (*RoutePolicyConfig, error) {
+	config := &RoutePolicyConfig{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	if config.Default == "" {
+		config.Default = RoutePolicyDeny
+	}
+	if config.Default != RoutePolicyAllow && config.Default != RoutePolicyDeny {
+		return nil, ErrInvalidRoutePolicy
+	}
+	for _, rule := range config.Rules {
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, ErrInvalidRoutePolicy
+		}
+	}
+	return config, nil
+}
+
+func (rule *RoutePolicy) matches(path, method string) bool {
+	if rule.Path != path {
+		return false
+	}
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, value := range rule.Methods {
+		if strings.EqualFold(value, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *RoutePolicy) permits(ctx context.Context, authorityPolicy AuthorityPolicy, details *model.OAuth2Details) bool {
+	if details == nil || details.Client == nil || details.User == nil {
+		return false
+	}
+	if len(rule.Clients) > 0 && !contains(rule.Clients, details.Client.ClientId) {
+		return false
+	}
+	for _, scope := range rule.Scopes {
+		if !contains(details.Client.Scope, scope) {
+			return false
+		}
+	}
+	if len(rule.Authorities) == 0 {
+		return true
+	}
+	for _, authority := range rule.Authorities {
+		if authorityPolicy.IsGranted(ctx, details.User, authority) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"security/model"
+	"testing"
+)
+
+const testRoutePolicy = `
+default: deny
+rules:
+  - path: /orders
+    methods: [GET]
+    authorities: [Simple]
+  - path: /orders
+    methods: [POST, delete]
+    authorities: [Admin]
+    scopes: [write]
+  - path: /orders
+    authorities: [Operator]
+  - path: /reports
+    clients: [reporting]
+`
+
+func writeRoutePolicy(t *testing.T, path, policy string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestRoutePolicyService(t *testing.T, policy string) (*FileRoutePolicyService, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "route_policy.yaml")
+	writeRoutePolicy(t, path, policy)
+	authorityPolicy, err := NewRoleHierarchyPolicy("Admin > Operator\nOperator > Simple", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewFileRoutePolicyService(path, authorityPolicy, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func routeDetails(clientId string, scopes []string, authorities ...string) *model.OAuth2Details {
+	return &model.OAuth2Details{
+		Client: &model.ClientDetails{ClientId: clientId, Scope: scopes},
+		User:   &model.UserDetails{UserName: "alice", Authorities: authorities},
+	}
+}
+
+func TestRoutePolicyPrecedence(t *testing.T) {
+	s, _ := newTestRoutePolicyService(t, testRoutePolicy)
+
+	tests := []struct {
+		name    string
+		path    string
+		method  string
+		details *model.OAuth2Details
+		want    bool
+	}{
+		{"method rule", "/orders", http.MethodGet, routeDetails("app", nil, "Simple"), true},
+		//按顺序使用第一条匹配的规则，后面不限方法的规则不再生效
+		{"first matching rule wins", "/orders", http.MethodPost, routeDetails("app", []string{"write"}, "Operator"), false},
+		{"method rule with scope", "/orders", http.MethodPost, routeDetails("app", []string{"write"}, "Admin"), true},
+		{"method rule missing scope", "/orders", http.MethodPost, routeDetails("app", nil, "Admin"), false},
+		{"method is case insensitive", "/orders", "DELETE", routeDetails("app", []string{"write"}, "Admin"), true},
+		{"rule without methods", "/orders", http.MethodPut, routeDetails("app", nil, "Operator"), true},
+		{"rule without methods denies", "/orders", http.MethodPut, routeDetails("app", nil, "Simple"), false},
+		{"client rule", "/reports", http.MethodGet, routeDetails("reporting", nil), true},
+		{"client rule denies other client", "/reports", http.MethodGet, routeDetails("app", nil, "Admin"), false},
+		{"path must match exactly", "/orders/1", http.MethodGet, routeDetails("app", nil, "Admin"), false},
+		{"unmatched route uses default", "/unknown", http.MethodGet, routeDetails("app", nil, "Admin"), false},
+		{"missing token details", "/orders", http.MethodGet, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.IsPermitted(context.Background(), tt.path, tt.method, tt.details); got != tt.want {
+				t.Fatalf("IsPermitted(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoutePolicyDefaultAllow(t *testing.T) {
+	s, _ := newTestRoutePolicyService(t, "default: allow\nrules:\n  - path: /admin\n    authorities: [Admin]\n")
+	if !s.IsPermitted(context.Background(), "/orders", http.MethodGet, routeDetails("app", nil)) {
+		t.Fatal("unmatched route is denied with default allow")
+	}
+	if s.IsPermitted(context.Background(), "/admin", http.MethodGet, routeDetails("app", nil, "Simple")) {
+		t.Fatal("matched rule is ignored with default allow")
+	}
+}
+
+func TestRoutePolicyReload(t *testing.T) {
+	s, path := newTestRoutePolicyService(t, testRoutePolicy)
+	ctx := context.Background()
+	details := routeDetails("app", nil, "Simple")
+
+	writeRoutePolicy(t, path, "default: allow\nrules: []\n")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if !s.IsPermitted(ctx, "/unknown", http.MethodGet, details) {
+		t.Fatal("reloaded default is not applied")
+	}
+
+	//无效的策略文件不替换原有策略
+	for _, policy := range []string{
+		"default: maybe\n",
+		"rules:\n  - path: orders\n",
+		"rules:\n  - path: /orders\n    unknown: true\n",
+	} {
+		writeRoutePolicy(t, path, policy)
+		if err := s.Reload(); err == nil {
+			t.Fatalf("Reload accepted %q", policy)
+		}
+		if !s.IsPermitted(ctx, "/unknown", http.MethodGet, details) {
+			t.Fatalf("previous policy is lost after reloading %q", policy)
+		}
+	}
+}
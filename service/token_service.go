@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	uuid "github.com/satori/go.uuid"
 	"net/http"
@@ -17,9 +18,15 @@ import (
 
 var (
 	ErrNotSupportGrantType               = errors.New("grant type is not supported")
+	ErrNoSigningKey                      = errors.New("no signing key is configured")
+	ErrUnknownSigningKey                 = errors.New("unknown signing key")
 	ErrInvalidUsernameAndPasswordRequest = errors.New("invalid username,password")
 	ErrInvalidTokenRequest               = errors.New("invalid token")
 	ErrExpiredToken                      = errors.New("token is expired")
+	ErrInvalidRefreshToken               = errors.New("invalid refresh token")
+	ErrNotSupportOperation               = errors.New("operation is not supported")
+	ErrRefreshTokenClient                = errors.New("refresh token was issued to another client")
+	ErrRefreshTokenCertificate           = errors.New("refresh token is bound to a different client certificate")
 )
 
 //令牌生成器
@@ -55,9 +62,11 @@ func NewComposeTokenGranter(tokenGrantDict map[string]TokenGrant) TokenGrant {
 
 /*使用户名和密码生成令牌授权*/
 type UsernamePasswordTokenGranter struct {
-	supportGrantType   string
-	userDetailsService UserDetailsService
-	tokenService       TokenService
+	supportGrantType    string
+	userDetailsService  UserDetailsService
+	tokenService        TokenService
+	loginAttemptService LoginAttemptService
+	mfaService          MFAService
 }
 
 func (upg UsernamePasswordTokenGranter) Grant(ctx context.Context, grantType string, client *ClientDetails, r *http.Request) (*OAuth2Token, error) {
@@ -65,22 +74,47 @@ func (upg UsernamePasswordTokenGranter) Grant(ctx context.Context, grantType str
 		return nil, ErrNotSupportGrantType
 	}
 	//从请求体中获取用户名和密码
-	username := r.FormValue("username")
-	passwrod := r.FormValue("password")
+	username := r.PostFormValue("username")
+	passwrod := r.PostFormValue("password")
 
 	if username == "" || passwrod == "" {
 		return nil, ErrInvalidUsernameAndPasswordRequest
 	}
 
 	//验证用户名密码是否正确
-	userDetails, err := upg.userDetailsService.GetUserDetailByUserName(ctx, username, passwrod)
+	ip := ClientIP(r)
+	userDetails, err := authenticateUser(ctx, upg.userDetailsService, upg.loginAttemptService, username, passwrod, ip)
 	if err != nil {
 		return nil, err
 	}
+
+	//已绑定或被要求二次验证的用户需要提供一次性密码
+	methods := []string{AmrPassword}
+	if upg.mfaService != nil && upg.mfaService.IsRequired(ctx, userDetails) {
+		method, err := upg.mfaService.Verify(ctx, userDetails, r.PostFormValue("mfa_code"))
+		if err != nil {
+			if upg.loginAttemptService != nil {
+				switch err {
+				case ErrInvalidMFACode:
+					upg.loginAttemptService.LoginFailed(ctx, username, ip)
+				case ErrMFACodeRequired:
+					//密码正确，等待客户端提交一次性密码，不计入失败
+					upg.loginAttemptService.LoginIncomplete(ctx, username, ip)
+				}
+			}
+			return nil, err
+		}
+		methods = append(methods, method, AmrMultiFactor)
+	}
+	if upg.loginAttemptService != nil {
+		upg.loginAttemptService.LoginSucceeded(ctx, username, ip)
+	}
+
 	//根据用户信息和客户端信息生成访问令牌
-	return upg.tokenService.CreateAccessToken(&OAuth2Details{
-		Client: client,
-		User:   userDetails,
+	return upg.tokenService.CreateAccessToken(ctx, &OAuth2Details{
+		Client:                client,
+		User:                  userDetails,
+		AuthenticationMethods: methods,
 	})
 }
 
@@ -91,23 +125,26 @@ func (upg UsernamePasswordTokenGranter) Grant(ctx context.Context, grantType str
 type TokenService interface {
 
 	//根据访问令牌获取对应的用户信息和客户端信息
-	GetOAuth2DetailsByAccessToken(tokenValue string) (*OAuth2Details, error)
+	GetOAuth2DetailsByAccessToken(ctx context.Context, tokenValue string) (*OAuth2Details, error)
 	//根据用户信息和客户端信息生成访问令牌
-	CreateAccessToken(oauth2Details *OAuth2Details) (*OAuth2Token, error)
-	//根据刷新令牌获取访问令牌
-	RefreshAccessToken(refreshTokenValue string) (*OAuth2Token, error)
+	CreateAccessToken(ctx context.Context, oauth2Details *OAuth2Details) (*OAuth2Token, error)
+	//根据刷新令牌获取访问令牌，刷新令牌必须是签发给client的
+	RefreshAccessToken(ctx context.Context, client *ClientDetails, refreshTokenValue string) (*OAuth2Token, error)
 	//根据用户信息和客户端信息获取访问令牌
-	GetAccessToken(details *OAuth2Details) (*OAuth2Token, error)
+	GetAccessToken(ctx context.Context, details *OAuth2Details) (*OAuth2Token, error)
 	//根据访问令牌获取访问令牌结构体
-	ReadAccessToken(tokenValue string) (*OAuth2Token, error)
+	ReadAccessToken(ctx context.Context, tokenValue string) (*OAuth2Token, error)
 }
 
 //用户密码令牌生成
-func NewUsernamePasswordTokenGrant(grantType string, userDetailService UserDetailsService, tokenService TokenService) TokenGrant {
+//loginAttemptService为nil时不做登录尝试限制，mfaService为nil时不做二次验证
+func NewUsernamePasswordTokenGrant(grantType string, userDetailService UserDetailsService, tokenService TokenService, loginAttemptService LoginAttemptService, mfaService MFAService) TokenGrant {
 	return &UsernamePasswordTokenGranter{
-		supportGrantType:   grantType,
-		userDetailsService: userDetailService,
-		tokenService:       tokenService,
+		supportGrantType:    grantType,
+		userDetailsService:  userDetailService,
+		tokenService:        tokenService,
+		loginAttemptService: loginAttemptService,
+		mfaService:          mfaService,
 	}
 }
 
@@ -121,12 +158,12 @@ func (rfg *RefreshTokenGranter) Grant(ctx context.Context, grantType string, cli
 	if grantType != rfg.supportGrantType {
 		return nil, ErrNotSupportGrantType
 	}
-	//从请求中获取刷新令牌
-	refreshTokenValue := r.URL.Query().Get("fresh_token")
+	//从请求体中获取刷新令牌
+	refreshTokenValue := r.PostFormValue("refresh_token")
 	if refreshTokenValue == "" {
 		return nil, ErrInvalidTokenRequest
 	}
-	return rfg.tokenService.RefreshAccessToken(refreshTokenValue)
+	return rfg.tokenService.RefreshAccessToken(ctx, client, refreshTokenValue)
 }
 
 func NewRefreshGranter(grantType string, userDetailsService UserDetailsService, tokenService TokenService) TokenGrant {
@@ -136,6 +173,28 @@ func NewRefreshGranter(grantType string, userDetailsService UserDetailsService,
 	}
 }
 
+/*客户端凭证授权，客户端以自己的名义访问资源，令牌不绑定用户，RFC 6749 4.4节*/
+type ClientCredentialsTokenGranter struct {
+	supportGrantType string
+	tokenService     TokenService
+}
+
+func (ccg *ClientCredentialsTokenGranter) Grant(ctx context.Context, grantType string, client *ClientDetails, r *http.Request) (*OAuth2Token, error) {
+	if grantType != ccg.supportGrantType {
+		return nil, ErrNotSupportGrantType
+	}
+	return ccg.tokenService.CreateAccessToken(ctx, &OAuth2Details{
+		Client: client,
+	})
+}
+
+func NewClientCredentialsGranter(grantType string, tokenService TokenService) TokenGrant {
+	return &ClientCredentialsTokenGranter{
+		supportGrantType: grantType,
+		tokenService:     tokenService,
+	}
+}
+
 /*默认令牌服务*/
 type DefaultTokenService struct {
 	tokenStore    TokenStore
@@ -152,23 +211,28 @@ func NewTokenService(store TokenStore, enhancer TokenEnhancer) TokenService {
 //生成访问令牌
 //尝试根据用户信息和客户端信息从TokenSotre中获取保存的访问令牌
 //如果访问令牌已经失效，那么尝试根据用户信息和客户端信息生成一个新的访问令牌并返回
-func (ds *DefaultTokenService) CreateAccessToken(oauth2details *OAuth2Details) (*OAuth2Token, error) {
-	existToken, err := ds.tokenStore.GetAccessToken(oauth2details)
+func (ds *DefaultTokenService) CreateAccessToken(ctx context.Context, oauth2details *OAuth2Details) (*OAuth2Token, error) {
+	oauth2details, err := bindCertificate(ctx, oauth2details)
+	if err != nil {
+		return nil, err
+	}
+	existToken, err := ds.tokenStore.GetAccessToken(ctx, oauth2details)
 	var refreshToken *OAuth2Token
 	if err == nil {
 		//存在未失效的访问令牌，直接返回
 		if !existToken.IsExpired() {
-			ds.tokenStore.StoreAccessToken(existToken, oauth2details)
+			ds.tokenStore.StoreAccessToken(ctx, existToken, oauth2details)
 			return existToken, nil
 		}
 		//访问令牌已经失效，移除
-		ds.tokenStore.RemoveAccessToken(existToken.TokenValue)
+		ds.tokenStore.RemoveAccessToken(ctx, existToken.TokenValue)
 		if existToken.RefreshToken != nil {
 			refreshToken = existToken.RefreshToken
-			ds.tokenStore.RemoveRefreshToken(refreshToken.TokenValue)
+			ds.tokenStore.RemoveRefreshToken(ctx, refreshToken.TokenValue)
 		}
 	}
-	if refreshToken == nil || refreshToken.IsExpired() {
+	//客户端凭证授权没有用户，不签发刷新令牌，RFC 6749 4.4.3节
+	if oauth2details.User != nil && (refreshToken == nil || refreshToken.IsExpired()) {
 		//重新生成refreshToken
 		refreshToken, err = ds.createRefreshToken(oauth2details)
 		if err != nil {
@@ -180,8 +244,10 @@ func (ds *DefaultTokenService) CreateAccessToken(oauth2details *OAuth2Details) (
 	accessToken, err := ds.createAccessToken(refreshToken, oauth2details)
 	if err == nil {
 		//保存新生成令牌
-		ds.tokenStore.StoreAccessToken(accessToken, oauth2details)
-		ds.tokenStore.StoreRefreshToken(refreshToken, oauth2details)
+		ds.tokenStore.StoreAccessToken(ctx, accessToken, oauth2details)
+		if refreshToken != nil {
+			ds.tokenStore.StoreRefreshToken(ctx, refreshToken, oauth2details)
+		}
 	}
 	return accessToken, err
 
@@ -210,7 +276,7 @@ func (ds *DefaultTokenService) createAccessToken(refreshToken *OAuth2Token, deta
 //根据客户端信息和用户信息创建刷新令牌
 func (ds *DefaultTokenService) createRefreshToken(details *OAuth2Details) (*OAuth2Token, error) {
 	//token的有效时间
-	validitySecond := details.Client.AccessTokenValiditySeconds
+	validitySecond := details.Client.RefreshTokenValiditySeconds
 	s, _ := time.ParseDuration(strconv.Itoa(validitySecond) + "s")
 	expiredTime := time.Now().Add(s)
 	refreshToken := &OAuth2Token{
@@ -225,13 +291,13 @@ func (ds *DefaultTokenService) createRefreshToken(details *OAuth2Details) (*OAut
 }
 
 //使用访问令牌获取客户端信息和用户信息
-func (ds *DefaultTokenService) GetOAuth2DetailsByAccessToken(tokenValue string) (*OAuth2Details, error) {
-	accessToken, err := ds.tokenStore.ReadAccessToken(tokenValue)
+func (ds *DefaultTokenService) GetOAuth2DetailsByAccessToken(ctx context.Context, tokenValue string) (*OAuth2Details, error) {
+	accessToken, err := ds.tokenStore.ReadAccessToken(ctx, tokenValue)
 	if err == nil {
 		if accessToken.IsExpired() {
 			return nil, ErrExpiredToken
 		}
-		return ds.tokenStore.ReadOAuth2Details(tokenValue)
+		return ds.tokenStore.ReadOAuth2Details(ctx, tokenValue)
 	}
 	return nil, err
 
@@ -239,48 +305,90 @@ func (ds *DefaultTokenService) GetOAuth2DetailsByAccessToken(tokenValue string)
 
 //根据刷新令牌生成新的访问令牌和刷新令牌
 //在客户端持有的访问令牌失效时，客户端可以使用刷新令牌重新生成新的有效的访问令牌
-func (ds *DefaultTokenService) RefreshAccessToken(refreshTokenValue string) (*OAuth2Token, error) {
+//刷新令牌只能由签发时的客户端使用，绑定了证书的刷新令牌还必须使用同一证书，RFC 8705 4节
+func (ds *DefaultTokenService) RefreshAccessToken(ctx context.Context, client *ClientDetails, refreshTokenValue string) (*OAuth2Token, error) {
 	//使用使用tokenSotore将刷新令牌值对应的刷新令牌结构体查询出来，用于判断刷新令牌是否过期
 	//再根据刷新令牌之获取绑定的用户信息和客户端信息
 	//最后移除原有的访问令牌和已使用的刷新令牌,并根据用户信息和客户端信息生成新的访问令牌和刷新令牌
-	refreshToken, err := ds.tokenStore.ReadRefreshToken(refreshTokenValue)
+	//格式错误、签名无效或已过期的刷新令牌都属于无效的授权，RFC 6749 5.2节
+	refreshToken, err := ds.tokenStore.ReadRefreshToken(ctx, refreshTokenValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefreshToken, err)
+	}
+	if refreshToken.IsExpired() {
+		return nil, ErrExpiredToken
+	}
+	//未过期
+	oauthDetails, err := ds.tokenStore.ReadOAuth2DetailsForRefreshToken(ctx, refreshTokenValue)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRefreshToken, err)
+	}
+	if err := checkRefreshTokenBinding(ctx, client, oauthDetails); err != nil {
+		return nil, err
+	}
+	//使用客户端当前的配置，如令牌有效期和是否绑定证书
+	refreshDetails := *oauthDetails
+	refreshDetails.Client = client
+	if oauthDetails, err = bindCertificate(ctx, &refreshDetails); err != nil {
+		return nil, err
+	}
+	oauth2Token, err := ds.tokenStore.GetAccessToken(ctx, oauthDetails)
+	//移除原有的访问令牌
 	if err == nil {
-		if refreshToken.IsExpired() {
-			return nil, err
-		}
-		//未过期
-		oauthDetails, err := ds.tokenStore.ReadOAuth2DetailsForRefreshToken(refreshTokenValue)
-		if err == nil {
-			oauth2Token, err := ds.tokenStore.GetAccessToken(oauthDetails)
-			//移除原有的访问令牌
-			if err == nil {
-				ds.tokenStore.RemoveAccessToken(oauth2Token.TokenValue)
-			}
-			//移除已使用的刷新令牌
-			ds.tokenStore.RemoveRefreshToken(refreshTokenValue)
-			newRefreshToken, err := ds.createRefreshToken(oauthDetails)
-			if err == nil {
-				newAccessToken, err := ds.createAccessToken(newRefreshToken, oauthDetails)
-				if err == nil {
-					ds.tokenStore.StoreAccessToken(newAccessToken, oauthDetails)
-					ds.tokenStore.StoreRefreshToken(newRefreshToken, oauthDetails)
-				}
-				return newAccessToken, err
-			}
-
-		}
+		ds.tokenStore.RemoveAccessToken(ctx, oauth2Token.TokenValue)
+	}
+	//移除已使用的刷新令牌
+	ds.tokenStore.RemoveRefreshToken(ctx, refreshTokenValue)
+	newRefreshToken, err := ds.createRefreshToken(oauthDetails)
+	if err != nil {
+		return nil, err
+	}
+	newAccessToken, err := ds.createAccessToken(newRefreshToken, oauthDetails)
+	if err == nil {
+		ds.tokenStore.StoreAccessToken(ctx, newAccessToken, oauthDetails)
+		ds.tokenStore.StoreRefreshToken(ctx, newRefreshToken, oauthDetails)
+	}
+	return newAccessToken, err
+}
 
+//刷新令牌的客户端必须是当前认证的客户端，绑定的证书指纹必须与本次请求的证书一致
+func checkRefreshTokenBinding(ctx context.Context, client *ClientDetails, details *OAuth2Details) error {
+	if client == nil || details.Client == nil || details.Client.ClientId != client.ClientId {
+		return ErrRefreshTokenClient
 	}
-	return nil, err
+	if details.CertificateThumbprint == "" {
+		return nil
+	}
+	certificate, ok := ClientCertificateFromContext(ctx)
+	if !ok || CertificateThumbprint(certificate) != details.CertificateThumbprint {
+		return ErrRefreshTokenCertificate
+	}
+	return nil
+}
 
+/**
+RFC 8705 3节，客户端要求绑定证书时，令牌绑定请求中客户端证书的指纹
+请求没有客户端证书时拒绝签发，避免签发出未绑定的令牌
+*/
+func bindCertificate(ctx context.Context, details *OAuth2Details) (*OAuth2Details, error) {
+	if details.Client == nil || !details.Client.CertificateBoundAccessTokens {
+		return details, nil
+	}
+	certificate, ok := ClientCertificateFromContext(ctx)
+	if !ok {
+		return nil, ErrClientCertificateRequired
+	}
+	bound := *details
+	bound.CertificateThumbprint = CertificateThumbprint(certificate)
+	return &bound, nil
 }
 
-func (ds *DefaultTokenService) GetAccessToken(details *OAuth2Details) (*OAuth2Token, error) {
-	return ds.tokenStore.GetAccessToken(details)
+func (ds *DefaultTokenService) GetAccessToken(ctx context.Context, details *OAuth2Details) (*OAuth2Token, error) {
+	return ds.tokenStore.GetAccessToken(ctx, details)
 }
 
-func (ds *DefaultTokenService) ReadAccessToken(tokenValue string) (*OAuth2Token, error) {
-	return ds.tokenStore.ReadAccessToken(tokenValue)
+func (ds *DefaultTokenService) ReadAccessToken(ctx context.Context, tokenValue string) (*OAuth2Token, error) {
+	return ds.tokenStore.ReadAccessToken(ctx, tokenValue)
 }
 
 /**
@@ -289,23 +397,25 @@ func (ds *DefaultTokenService) ReadAccessToken(tokenValue string) (*OAuth2Token,
 */
 type TokenStore interface {
 	//存储访问令牌
-	StoreAccessToken(token *OAuth2Token, details *OAuth2Details)
+	StoreAccessToken(ctx context.Context, token *OAuth2Token, details *OAuth2Details)
 	//根据令牌值获取访问令牌结构体
-	ReadAccessToken(tokenValue string) (*OAuth2Token, error)
+	ReadAccessToken(ctx context.Context, tokenValue string) (*OAuth2Token, error)
 	//根据令牌值获取令牌对应的客户端和用户信息
-	ReadOAuth2Details(tokenValue string) (*OAuth2Details, error)
+	ReadOAuth2Details(ctx context.Context, tokenValue string) (*OAuth2Details, error)
 	//根据客户端信息和用户信息获取访问令牌
-	GetAccessToken(details *OAuth2Details) (*OAuth2Token, error)
+	GetAccessToken(ctx context.Context, details *OAuth2Details) (*OAuth2Token, error)
 	//移除存储的访问令牌
-	RemoveAccessToken(tokenValue string)
+	RemoveAccessToken(ctx context.Context, tokenValue string)
 	//存储刷新令牌
-	StoreRefreshToken(token *OAuth2Token, details *OAuth2Details)
+	StoreRefreshToken(ctx context.Context, token *OAuth2Token, details *OAuth2Details)
 	//移除存储的刷新令牌
-	RemoveRefreshToken(oauth2Token string)
+	RemoveRefreshToken(ctx context.Context, oauth2Token string)
 	//根据令牌值获取刷新令牌
-	ReadRefreshToken(tokenValue string) (*OAuth2Token, error)
+	ReadRefreshToken(ctx context.Context, tokenValue string) (*OAuth2Token, error)
 	//根据令牌值获取刷新令牌对应的客户端信息和用户信息
-	ReadOAuth2DetailsForRefreshToken(tokenValue string) (*OAuth2Details, error)
+	ReadOAuth2DetailsForRefreshToken(ctx context.Context, tokenValue string) (*OAuth2Details, error)
+	//移除令牌后令牌是否立即失效，为false时移除只是不再使用，令牌仍然有效到过期
+	SupportsRevocation() bool
 }
 
 //token增强
@@ -321,40 +431,48 @@ type JwtTokenStore struct {
 	jwtTokenEnhancer *JWTTokenEnhancer
 }
 
-func (j *JwtTokenStore) StoreAccessToken(token *OAuth2Token, details *OAuth2Details) {
-	panic("implement me")
+//JWT令牌本身携带了用户信息和客户端信息，无需存储
+func (j *JwtTokenStore) StoreAccessToken(ctx context.Context, token *OAuth2Token, details *OAuth2Details) {
+}
+
+func (j *JwtTokenStore) ReadAccessToken(ctx context.Context, tokenValue string) (*OAuth2Token, error) {
+	oauth2Token, _, err := j.jwtTokenEnhancer.Extract(tokenValue)
+	return oauth2Token, err
 }
 
-func (j *JwtTokenStore) ReadAccessToken(tokenValue string) (*OAuth2Token, error) {
-	panic("implement me")
+func (j *JwtTokenStore) ReadOAuth2Details(ctx context.Context, tokenValue string) (*OAuth2Details, error) {
+	_, oauth2Details, err := j.jwtTokenEnhancer.Extract(tokenValue)
+	return oauth2Details, err
 }
 
-func (j *JwtTokenStore) ReadOAuth2Details(tokenValue string) (*OAuth2Details, error) {
-	panic("implement me")
+//没有存储已签发的令牌，总是返回错误，由TokenService重新生成
+func (j *JwtTokenStore) GetAccessToken(ctx context.Context, details *OAuth2Details) (*OAuth2Token, error) {
+	return nil, ErrNotSupportOperation
 }
 
-func (j *JwtTokenStore) GetAccessToken(details *OAuth2Details) (*OAuth2Token, error) {
-	panic("implement me")
+//JWT签发后无法撤销，只能等待过期
+func (j *JwtTokenStore) RemoveAccessToken(ctx context.Context, tokenValue string) {
 }
 
-func (j *JwtTokenStore) RemoveAccessToken(tokenValue string) {
-	panic("implement me")
+func (j *JwtTokenStore) StoreRefreshToken(ctx context.Context, token *OAuth2Token, details *OAuth2Details) {
 }
 
-func (j *JwtTokenStore) StoreRefreshToken(token *OAuth2Token, details *OAuth2Details) {
-	panic("implement me")
+func (j *JwtTokenStore) RemoveRefreshToken(ctx context.Context, oauth2Token string) {
 }
 
-func (j *JwtTokenStore) RemoveRefreshToken(oauth2Token string) {
-	panic("implement me")
+//JWT签发后无法撤销
+func (j *JwtTokenStore) SupportsRevocation() bool {
+	return false
 }
 
-func (j *JwtTokenStore) ReadRefreshToken(tokenValue string) (*OAuth2Token, error) {
-	panic("implement me")
+func (j *JwtTokenStore) ReadRefreshToken(ctx context.Context, tokenValue string) (*OAuth2Token, error) {
+	oauth2Token, _, err := j.jwtTokenEnhancer.Extract(tokenValue)
+	return oauth2Token, err
 }
 
-func (j *JwtTokenStore) ReadOAuth2DetailsForRefreshToken(tokenValue string) (*OAuth2Details, error) {
-	panic("implement me")
+func (j *JwtTokenStore) ReadOAuth2DetailsForRefreshToken(ctx context.Context, tokenValue string) (*OAuth2Details, error) {
+	_, oauth2Details, err := j.jwtTokenEnhancer.Extract(tokenValue)
+	return oauth2Details, err
 }
 
 func NewJwtTokenStore(enhancer *JWTTokenEnhancer) TokenStore {
@@ -372,8 +490,15 @@ JWTTokenEnhancer会把令牌对应的用户信息和客户端信息写入到JWT
 
 //实现TokenEnhancer接口
 
+//秘钥保存在CredentialsHolder中，可以和客户端、用户一起整体替换，每次签发或验证使用同一份秘钥快照
 type JWTTokenEnhancer struct {
-	secretKey []byte
+	credentials *CredentialsHolder
+}
+
+//HS256签名秘钥，Id写入JWT头部的kid，用于在轮换期间选择验证秘钥
+type SigningKey struct {
+	Id     string
+	Secret []byte
 }
 
 //声明信息
@@ -381,6 +506,10 @@ type OAuth2TokenCustomClaims struct {
 	UserDetails   UserDetails
 	ClientDetails ClientDetails
 	RefreshToken  OAuth2Token
+	//认证方式
+	Amr []string `json:"amr,omitempty"`
+	//绑定的客户端证书
+	Cnf *Confirmation `json:"cnf,omitempty"`
 	jwt.StandardClaims
 }
 
@@ -393,20 +522,31 @@ func (enhance *JWTTokenEnhancer) Enhance(token *OAuth2Token, details *OAuth2Deta
 //在资源服务器解析JWT成功后，既可以定位请求的来源
 func (enhance *JWTTokenEnhancer) Extract(tokenValue string) (*OAuth2Token, *OAuth2Details, error) {
 	token, err := jwt.ParseWithClaims(tokenValue, &OAuth2TokenCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return enhance.secretKey, nil
+		//只接受HMAC签名，防止算法替换
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrInvalidTokenRequest
+		}
+		return enhance.verificationKey(token)
 	})
 	if err == nil {
 		claims := token.Claims.(*OAuth2TokenCustomClaims)
 		expireTime := time.Unix(claims.ExpiresAt, 0)
+		thumbprint := ""
+		if claims.Cnf != nil {
+			thumbprint = claims.Cnf.X5tS256
+		}
 
 		return &OAuth2Token{
-				RefreshToken: &claims.RefreshToken,
-				TokenValue:   tokenValue,
-				ExpiresTime:  &expireTime,
-			}, &OAuth2Details{
-				User:   &claims.UserDetails,
-				Client: &claims.ClientDetails,
-			}, nil
+			RefreshToken: &claims.RefreshToken,
+			TokenValue:   tokenValue,
+			ExpiresTime:  &expireTime,
+		}, &OAuth2Details{
+			User:                  &claims.UserDetails,
+			Client:                &claims.ClientDetails,
+			AuthenticationMethods: claims.Amr,
+			CertificateThumbprint: thumbprint,
+			ExpiresAt:             expireTime,
+		}, nil
 	}
 	return nil, nil, err
 
@@ -416,13 +556,20 @@ func (enhance *JWTTokenEnhancer) Extract(tokenValue string) (*OAuth2Token, *OAut
 func (enhance *JWTTokenEnhancer) sign(token *OAuth2Token, details *OAuth2Details) (*OAuth2Token, error) {
 	expireTime := token.ExpiresTime
 	clientDetails := *details.Client
-	userDetails := *details.User
+	//客户端凭证授权的令牌没有用户
+	var userDetails UserDetails
+	if details.User != nil {
+		userDetails = *details.User
+	}
 	clientDetails.ClientSecret = ""
+	clientDetails.TLSClientAuthSubjectDN = ""
+	clientDetails.TLSClientCertificateThumbprints = nil
 	userDetails.Password = ""
 
 	claims := OAuth2TokenCustomClaims{
 		UserDetails:   userDetails,
 		ClientDetails: clientDetails,
+		Amr:           details.AuthenticationMethods,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expireTime.Unix(),
 			Issuer:    "System",
@@ -431,9 +578,16 @@ func (enhance *JWTTokenEnhancer) sign(token *OAuth2Token, details *OAuth2Details
 	if token.RefreshToken != nil {
 		claims.RefreshToken = *token.RefreshToken
 	}
+	if details.CertificateThumbprint != "" {
+		claims.Cnf = &Confirmation{X5tS256: details.CertificateThumbprint}
+	}
 
-	tokens := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
-	tokenValue, err := tokens.SignedString(enhance.secretKey)
+	tokens := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signingKey := enhance.signingKeys()[0]
+	if signingKey.Id != "" {
+		tokens.Header["kid"] = signingKey.Id
+	}
+	tokenValue, err := tokens.SignedString(signingKey.Secret)
 	if err == nil {
 		token.TokenValue = tokenValue
 		token.TokenType = "jwt"
@@ -441,8 +595,57 @@ func (enhance *JWTTokenEnhancer) sign(token *OAuth2Token, details *OAuth2Details
 	}
 	return nil, err
 }
+
+//根据JWT头部的kid选择验证秘钥，没有kid时使用签发秘钥
+func (enhance *JWTTokenEnhancer) verificationKey(token *jwt.Token) (interface{}, error) {
+	keys := enhance.signingKeys()
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return keys[0].Secret, nil
+	}
+	for _, key := range keys {
+		if key.Id == kid {
+			return key.Secret, nil
+		}
+	}
+	return nil, ErrUnknownSigningKey
+}
+
 func NewJWTTokenEnhancer(secretKey string) TokenEnhancer {
 	return &JWTTokenEnhancer{
-		secretKey: []byte(secretKey),
+		credentials: NewCredentialsHolder(&Credentials{signingKeys: []SigningKey{{Secret: []byte(secretKey)}}}),
+	}
+}
+
+//使用多个秘钥，keys[0]用于签发，其余秘钥只用于验证轮换前签发的令牌
+func NewJWTTokenEnhancerWithKeys(keys []SigningKey) (*JWTTokenEnhancer, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return &JWTTokenEnhancer{
+		credentials: NewCredentialsHolder(&Credentials{signingKeys: keys}),
+	}, nil
+}
+
+//使用共享的快照，秘钥随客户端和用户一起替换
+func NewJWTTokenEnhancerWithCredentials(credentials *CredentialsHolder) *JWTTokenEnhancer {
+	return &JWTTokenEnhancer{
+		credentials: credentials,
 	}
 }
+
+//只替换全部秘钥，轮换时把新秘钥放在最前，旧秘钥保留到其签发的令牌全部过期
+func (enhance *JWTTokenEnhancer) SetKeys(keys []SigningKey) error {
+	if len(keys) == 0 {
+		return ErrNoSigningKey
+	}
+	enhance.credentials.update(func(credentials *Credentials) {
+		credentials.signingKeys = keys
+	})
+	return nil
+}
+
+//当前的秘钥快照
+func (enhance *JWTTokenEnhancer) signingKeys() []SigningKey {
+	return enhance.credentials.Load().signingKeys
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"security/model"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningKey = "token-service-test-signing-key-0123456789"
+
+func newTestTokenService() (TokenService, *JWTTokenEnhancer) {
+	enhancer := NewJWTTokenEnhancer(testSigningKey).(*JWTTokenEnhancer)
+	return NewTokenService(NewJwtTokenStore(enhancer), enhancer), enhancer
+}
+
+func newTestOAuth2Details(client *model.ClientDetails) *model.OAuth2Details {
+	return &model.OAuth2Details{
+		Client: client,
+		User:   &model.UserDetails{UserName: "alice", Password: "password"},
+	}
+}
+
+func boundClient(clientId string) *model.ClientDetails {
+	return &model.ClientDetails{
+		ClientId:                        clientId,
+		AccessTokenValiditySeconds:      60,
+		RefreshTokenValiditySeconds:     600,
+		CertificateBoundAccessTokens:    true,
+		TLSClientCertificateThumbprints: []string{"configured"},
+	}
+}
+
+//JWT载荷中的cnf声明
+func jwtConfirmation(t *testing.T, tokenValue string) map[string]string {
+	t.Helper()
+	parts := strings.Split(tokenValue, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed jwt %q", tokenValue)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims struct {
+		Cnf           map[string]string
+		ClientDetails map[string]interface{}
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.ClientDetails["TLSClientCertificateThumbprints"] != nil {
+		t.Fatal("client certificate thumbprints are written into the jwt")
+	}
+	return claims.Cnf
+}
+
+func TestCertificateBoundAccessToken(t *testing.T) {
+	tokenService, enhancer := newTestTokenService()
+	certificate := newTestCertificate(t, pkix.Name{CommonName: "client"}, false, nil).certificate
+	thumbprint := model.CertificateThumbprint(certificate)
+	ctx := NewClientCertificateContext(context.Background(), certificate)
+
+	token, err := tokenService.CreateAccessToken(ctx, newTestOAuth2Details(boundClient("bound")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//签发的JWT包含cnf.x5t#S256声明，解析后还原为证书指纹
+	if cnf := jwtConfirmation(t, token.TokenValue); cnf["x5t#S256"] != thumbprint {
+		t.Fatalf("cnf = %v, want x5t#S256 %s", cnf, thumbprint)
+	}
+	_, details, err := enhancer.Extract(token.TokenValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details.CertificateThumbprint != thumbprint {
+		t.Fatalf("extracted thumbprint = %q, want %q", details.CertificateThumbprint, thumbprint)
+	}
+	if details, err = tokenService.GetOAuth2DetailsByAccessToken(ctx, token.TokenValue); err != nil || details.CertificateThumbprint != thumbprint {
+		t.Fatalf("GetOAuth2DetailsByAccessToken = %+v, %v", details, err)
+	}
+
+	//要求绑定证书但请求中没有证书时拒绝签发
+	if _, err := tokenService.CreateAccessToken(context.Background(), newTestOAuth2Details(boundClient("bound"))); err != ErrClientCertificateRequired {
+		t.Fatalf("CreateAccessToken without certificate error = %v, want %v", err, ErrClientCertificateRequired)
+	}
+
+	//未要求绑定的客户端签发普通令牌
+	unbound := boundClient("unbound")
+	unbound.CertificateBoundAccessTokens = false
+	token, err = tokenService.CreateAccessToken(ctx, newTestOAuth2Details(unbound))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cnf := jwtConfirmation(t, token.TokenValue); cnf != nil {
+		t.Fatalf("unbound token has cnf %v", cnf)
+	}
+}
+
+func TestRefreshTokenBinding(t *testing.T) {
+	tokenService, enhancer := newTestTokenService()
+	certificate := newTestCertificate(t, pkix.Name{CommonName: "client"}, false, nil).certificate
+	otherCertificate := newTestCertificate(t, pkix.Name{CommonName: "client"}, false, nil).certificate
+	client := boundClient("bound")
+	ctx := NewClientCertificateContext(context.Background(), certificate)
+
+	token, err := tokenService.CreateAccessToken(ctx, newTestOAuth2Details(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshTokenValue := token.RefreshToken.TokenValue
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		client *model.ClientDetails
+		err    error
+	}{
+		{"another client", ctx, boundClient("another"), ErrRefreshTokenClient},
+		{"another certificate", NewClientCertificateContext(context.Background(), otherCertificate), client, ErrRefreshTokenCertificate},
+		{"no certificate", context.Background(), client, ErrRefreshTokenCertificate},
+		{"same client and certificate", ctx, client, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refreshed, err := tokenService.RefreshAccessToken(test.ctx, test.client, refreshTokenValue)
+			if err != test.err {
+				t.Fatalf("RefreshAccessToken error = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			//新令牌仍然绑定同一证书
+			_, details, err := enhancer.Extract(refreshed.TokenValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if details.CertificateThumbprint != model.CertificateThumbprint(certificate) {
+				t.Fatalf("refreshed token thumbprint = %q", details.CertificateThumbprint)
+			}
+		})
+	}
+}
+
+func passwordRequest(mfaCode string) *http.Request {
+	form := url.Values{"username": {"alice"}, "password": {"password"}, "mfa_code": {mfaCode}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.0.0.1:40000"
+	return r
+}
+
+//缺少一次性密码不计入失败，二次验证通过后不留下失败记录，但提交空的一次性密码不能清空猜测失败的次数
+func TestPasswordGrantMFALoginAttempts(t *testing.T) {
+	ctx := context.Background()
+	attempts := NewInMemoryLoginAttemptService(testAttemptPolicy, testAttemptPolicy, 10)
+	user := &model.UserDetails{UserName: "alice", Password: "password"}
+	users := NewInMemoryUserDetailsService([]*model.UserDetails{user}, attempts)
+	mfaService := NewTOTPMFAService("security", nil, users, attempts, NewMemoryMFAEnrollmentStore())
+	now := time.Unix(1234567890, 0)
+	mfaService.now = func() time.Time { return now }
+	secret := enroll(t, mfaService, "").Secret
+	tokenService, _ := newTestTokenService()
+	grant := NewUsernamePasswordTokenGrant("password", users, tokenService, attempts, mfaService)
+	client := &model.ClientDetails{ClientId: "app", AccessTokenValiditySeconds: 60, RefreshTokenValiditySeconds: 600}
+
+	if _, err := grant.Grant(ctx, "password", client, passwordRequest("")); err != ErrMFACodeRequired {
+		t.Fatalf("Grant without mfa code error = %v, want %v", err, ErrMFACodeRequired)
+	}
+	if len(attempts.users) != 0 || len(attempts.ips) != 0 {
+		t.Fatalf("records after mfa prompt: users %v, ips %v", attempts.users, attempts.ips)
+	}
+	if _, err := grant.Grant(ctx, "password", client, passwordRequest(codeAt(t, secret, now))); err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts.users) != 0 || len(attempts.ips) != 0 {
+		t.Fatalf("records after mfa login: users %v, ips %v", attempts.users, attempts.ips)
+	}
+
+	wrongCode := codeAt(t, secret, now.Add(time.Hour))
+	for i := 0; i <= testAttemptPolicy.FreeAttempts; i++ {
+		if _, err := grant.Grant(ctx, "password", client, passwordRequest("")); err != ErrMFACodeRequired {
+			t.Fatalf("attempt %d without mfa code error = %v, want %v", i, err, ErrMFACodeRequired)
+		}
+		if _, err := grant.Grant(ctx, "password", client, passwordRequest(wrongCode)); err != ErrInvalidMFACode {
+			t.Fatalf("attempt %d with wrong mfa code error = %v, want %v", i, err, ErrInvalidMFACode)
+		}
+	}
+	if _, err := grant.Grant(ctx, "password", client, passwordRequest("")); err != ErrAccountLocked {
+		t.Fatalf("Grant after wrong mfa codes error = %v, want %v", err, ErrAccountLocked)
+	}
+}
+
+//JWT头部的kid
+func jwtKeyId(t *testing.T, tokenValue string) string {
+	t.Helper()
+	header, err := base64.RawURLEncoding.DecodeString(strings.Split(tokenValue, ".")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value struct {
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value.Kid
+}
+
+func TestJWTTokenEnhancerKeyRotation(t *testing.T) {
+	v1 := SigningKey{Id: "v1", Secret: []byte("rotation-test-signing-key-v1-0123456789")}
+	v2 := SigningKey{Id: "v2", Secret: []byte("rotation-test-signing-key-v2-0123456789")}
+	enhancer, err := NewJWTTokenEnhancerWithKeys([]SigningKey{v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenService := NewTokenService(NewJwtTokenStore(enhancer), enhancer)
+	client := &model.ClientDetails{ClientId: "app", AccessTokenValiditySeconds: 60, RefreshTokenValiditySeconds: 600}
+	issue := func() *model.OAuth2Token {
+		t.Helper()
+		token, err := tokenService.CreateAccessToken(context.Background(), newTestOAuth2Details(client))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	before := issue()
+	if kid := jwtKeyId(t, before.TokenValue); kid != "v1" {
+		t.Fatalf("kid = %q, want v1", kid)
+	}
+
+	//新秘钥放在最前用于签发，旧秘钥继续验证轮换前签发的令牌
+	if err := enhancer.SetKeys([]SigningKey{v2, v1}); err != nil {
+		t.Fatal(err)
+	}
+	after := issue()
+	if kid := jwtKeyId(t, after.TokenValue); kid != "v2" {
+		t.Fatalf("kid after rotation = %q, want v2", kid)
+	}
+	for _, token := range []*model.OAuth2Token{before, after} {
+		if _, _, err := enhancer.Extract(token.TokenValue); err != nil {
+			t.Fatalf("token signed with %s: %v", jwtKeyId(t, token.TokenValue), err)
+		}
+	}
+	if _, err := tokenService.RefreshAccessToken(context.Background(), client, before.RefreshToken.TokenValue); err != nil {
+		t.Fatalf("refresh token issued before rotation: %v", err)
+	}
+
+	//移除旧秘钥后，旧秘钥签发的令牌失效
+	if err := enhancer.SetKeys([]SigningKey{v2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := enhancer.Extract(before.TokenValue); err == nil {
+		t.Fatal("token signed with a removed key is accepted")
+	}
+	if _, _, err := enhancer.Extract(after.TokenValue); err != nil {
+		t.Fatal(err)
+	}
+	//kid相同但秘钥不同的令牌无法通过验证
+	forged, err := NewJWTTokenEnhancerWithKeys([]SigningKey{{Id: "v2", Secret: []byte("forged-signing-key-0123456789abcdef")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedToken, err := forged.Enhance(&model.OAuth2Token{ExpiresTime: after.ExpiresTime}, newTestOAuth2Details(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := enhancer.Extract(forgedToken.TokenValue); err == nil {
+		t.Fatal("token signed with a forged key is accepted")
+	}
+
+	if err := enhancer.SetKeys(nil); err != ErrNoSigningKey {
+		t.Fatalf("SetKeys(nil) error = %v, want %v", err, ErrNoSigningKey)
+	}
+}
+
+//没有kid的令牌使用签发秘钥验证
+func TestJWTTokenEnhancerWithoutKeyId(t *testing.T) {
+	tokenService, enhancer := newTestTokenService()
+	token, err := tokenService.CreateAccessToken(context.Background(), newTestOAuth2Details(&model.ClientDetails{ClientId: "app", AccessTokenValiditySeconds: 60}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := jwtKeyId(t, token.TokenValue); kid != "" {
+		t.Fatalf("kid = %q, want none", kid)
+	}
+	if _, _, err := enhancer.Extract(token.TokenValue); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"security/common/tracing"
+	"security/model"
+)
+
+/**
+为令牌生成、令牌服务和令牌存储创建span的装饰器
+span中只记录授权类型、客户端ID等标识，不记录令牌值
+*/
+
+type TracingTokenGrant struct {
+	next TokenGrant
+}
+
+func NewTracingTokenGrant(next TokenGrant) TokenGrant {
+	return &TracingTokenGrant{next: next}
+}
+
+func (g *TracingTokenGrant) Grant(ctx context.Context, grantType string, client *model.ClientDetails, r *http.Request) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenGrant.Grant",
+		attribute.String("oauth2.grant_type", grantType),
+		attribute.String("oauth2.client_id", client.ClientId),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return g.next.Grant(ctx, grantType, client, r)
+}
+
+type TracingTokenService struct {
+	next TokenService
+}
+
+func NewTracingTokenService(next TokenService) TokenService {
+	return &TracingTokenService{next: next}
+}
+
+func (s *TracingTokenService) GetOAuth2DetailsByAccessToken(ctx context.Context, tokenValue string) (details *model.OAuth2Details, err error) {
+	ctx, span := tracing.Start(ctx, "TokenService.GetOAuth2DetailsByAccessToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.GetOAuth2DetailsByAccessToken(ctx, tokenValue)
+}
+
+func (s *TracingTokenService) CreateAccessToken(ctx context.Context, oauth2Details *model.OAuth2Details) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenService.CreateAccessToken", clientAttribute(oauth2Details))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.CreateAccessToken(ctx, oauth2Details)
+}
+
+func (s *TracingTokenService) RefreshAccessToken(ctx context.Context, client *model.ClientDetails, refreshTokenValue string) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenService.RefreshAccessToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.RefreshAccessToken(ctx, client, refreshTokenValue)
+}
+
+func (s *TracingTokenService) GetAccessToken(ctx context.Context, details *model.OAuth2Details) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenService.GetAccessToken", clientAttribute(details))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.GetAccessToken(ctx, details)
+}
+
+func (s *TracingTokenService) ReadAccessToken(ctx context.Context, tokenValue string) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenService.ReadAccessToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.ReadAccessToken(ctx, tokenValue)
+}
+
+type TracingTokenStore struct {
+	next TokenStore
+}
+
+func NewTracingTokenStore(next TokenStore) TokenStore {
+	return &TracingTokenStore{next: next}
+}
+
+func (s *TracingTokenStore) StoreAccessToken(ctx context.Context, token *model.OAuth2Token, details *model.OAuth2Details) {
+	ctx, span := tracing.Start(ctx, "TokenStore.StoreAccessToken", clientAttribute(details))
+	defer span.End()
+	s.next.StoreAccessToken(ctx, token, details)
+}
+
+func (s *TracingTokenStore) ReadAccessToken(ctx context.Context, tokenValue string) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenStore.ReadAccessToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.ReadAccessToken(ctx, tokenValue)
+}
+
+func (s *TracingTokenStore) ReadOAuth2Details(ctx context.Context, tokenValue string) (details *model.OAuth2Details, err error) {
+	ctx, span := tracing.Start(ctx, "TokenStore.ReadOAuth2Details")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.ReadOAuth2Details(ctx, tokenValue)
+}
+
+func (s *TracingTokenStore) GetAccessToken(ctx context.Context, details *model.OAuth2Details) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenStore.GetAccessToken", clientAttribute(details))
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.GetAccessToken(ctx, details)
+}
+
+func (s *TracingTokenStore) RemoveAccessToken(ctx context.Context, tokenValue string) {
+	ctx, span := tracing.Start(ctx, "TokenStore.RemoveAccessToken")
+	defer span.End()
+	s.next.RemoveAccessToken(ctx, tokenValue)
+}
+
+func (s *TracingTokenStore) StoreRefreshToken(ctx context.Context, token *model.OAuth2Token, details *model.OAuth2Details) {
+	ctx, span := tracing.Start(ctx, "TokenStore.StoreRefreshToken", clientAttribute(details))
+	defer span.End()
+	s.next.StoreRefreshToken(ctx, token, details)
+}
+
+func (s *TracingTokenStore) RemoveRefreshToken(ctx context.Context, oauth2Token string) {
+	ctx, span := tracing.Start(ctx, "TokenStore.RemoveRefreshToken")
+	defer span.End()
+	s.next.RemoveRefreshToken(ctx, oauth2Token)
+}
+
+func (s *TracingTokenStore) SupportsRevocation() bool {
+	return s.next.SupportsRevocation()
+}
+
+func (s *TracingTokenStore) ReadRefreshToken(ctx context.Context, tokenValue string) (token *model.OAuth2Token, err error) {
+	ctx, span := tracing.Start(ctx, "TokenStore.ReadRefreshToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.ReadRefreshToken(ctx, tokenValue)
+}
+
+func (s *TracingTokenStore) ReadOAuth2DetailsForRefreshToken(ctx context.Context, tokenValue string) (details *model.OAuth2Details, err error) {
+	ctx, span := tracing.Start(ctx, "TokenStore.ReadOAuth2DetailsForRefreshToken")
+	defer func() {
+		tracing.End(span, err)
+	}()
+	return s.next.ReadOAuth2DetailsForRefreshToken(ctx, tokenValue)
+}
+
+func clientAttribute(details *model.OAuth2Details) attribute.KeyValue {
+	if details == nil || details.Client == nil {
+		return attribute.String("oauth2.client_id", "")
+	}
+	return attribute.String("oauth2.client_id", details.Client.ClientId)
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http/httptest"
+	"security/common/tracing"
+	"security/model"
+	"strings"
+	"testing"
+)
+
+func installInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	provider, exporter := tracing.NewInMemoryTracerProvider("security-test")
+	tracing.Install(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) (tracetest.SpanStub, bool) {
+	for _, span := range spans {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+func TestTracingTokenService(t *testing.T) {
+	exporter := installInMemoryTracing(t)
+	enhancer := NewJWTTokenEnhancer(testSigningKey).(*JWTTokenEnhancer)
+	tokenService := NewTracingTokenService(NewTokenService(NewTracingTokenStore(NewJwtTokenStore(enhancer)), enhancer))
+	client := &model.ClientDetails{ClientId: "app", AccessTokenValiditySeconds: 60, RefreshTokenValiditySeconds: 600}
+	token, err := tokenService.CreateAccessToken(context.Background(), newTestOAuth2Details(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenService.ReadAccessToken(context.Background(), "not-a-token"); err == nil {
+		t.Fatal("ReadAccessToken accepted an invalid token")
+	}
+
+	spans := exporter.GetSpans()
+	create, ok := findSpan(spans, "TokenService.CreateAccessToken")
+	if !ok {
+		t.Fatal("TokenService.CreateAccessToken span is not recorded")
+	}
+	if !hasAttribute(create, "oauth2.client_id", "app") {
+		t.Fatalf("CreateAccessToken attributes = %v", create.Attributes)
+	}
+	//存储操作的span是令牌服务span的子span
+	store, ok := findSpan(spans, "TokenStore.StoreAccessToken")
+	if !ok || store.Parent.SpanID() != create.SpanContext.SpanID() {
+		t.Fatal("TokenStore.StoreAccessToken span is not a child of TokenService.CreateAccessToken")
+	}
+	read, ok := findSpan(spans, "TokenService.ReadAccessToken")
+	if !ok || read.Status.Code != codes.Error {
+		t.Fatalf("failed ReadAccessToken span = %+v", read.Status)
+	}
+
+	//span中不记录令牌值
+	for _, span := range spans {
+		for _, kv := range span.Attributes {
+			if value := kv.Value.Emit(); strings.Contains(value, token.TokenValue) || strings.Contains(value, "not-a-token") {
+				t.Fatalf("span %s records a token value in %s", span.Name, kv.Key)
+			}
+		}
+	}
+}
+
+func TestTracingTokenGrant(t *testing.T) {
+	exporter := installInMemoryTracing(t)
+	failure := errors.New("grant failed")
+	grant := NewTracingTokenGrant(failingTokenGrant{failure})
+	r := httptest.NewRequest("POST", "/oauth/token", nil)
+	if _, err := grant.Grant(context.Background(), "password", &model.ClientDetails{ClientId: "app"}, r); err != failure {
+		t.Fatalf("Grant error = %v, want %v", err, failure)
+	}
+	span, ok := findSpan(exporter.GetSpans(), "TokenGrant.Grant")
+	if !ok {
+		t.Fatal("TokenGrant.Grant span is not recorded")
+	}
+	if !hasAttribute(span, "oauth2.grant_type", "password") || !hasAttribute(span, "oauth2.client_id", "app") {
+		t.Fatalf("Grant attributes = %v", span.Attributes)
+	}
+	if span.Status.Code != codes.Error || span.Status.Description != failure.Error() {
+		t.Fatalf("Grant status = %+v", span.Status)
+	}
+}
+
+func hasAttribute(span tracetest.SpanStub, key, value string) bool {
+	for _, kv := range span.Attributes {
+		if string(kv.Key) == key && kv.Value.AsString() == value {
+			return true
+		}
+	}
+	return false
+}
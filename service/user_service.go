@@ -9,34 +9,73 @@ import (
 var (
 	ErrUserNotExist = errors.New("username is not exist")
 	ErrPassword     = errors.New("invalid password")
+	ErrNoUsers      = errors.New("no user is configured")
 )
 
 type UserDetailsService interface {
 	//根据用户名加载并验证用户信息
 	GetUserDetailByUserName(ctx context.Context, username string, password string) (*model.UserDetails, error)
+	//获取用户的锁定状态
+	GetLockState(ctx context.Context, username string) (*model.LockState, error)
 }
 
 //实现UserDetailsService接口
+//用户信息保存在CredentialsHolder中，可以整体替换，正在处理的请求继续使用替换前的用户信息
 type InMemoryUserDetailsService struct {
-	userDetailsDict map[string]*model.UserDetails
+	credentials         *CredentialsHolder
+	loginAttemptService LoginAttemptService
 }
 
-func NewInMemoryUserDetailsService(userDetailsList []*model.UserDetails) *InMemoryUserDetailsService {
-	userDetailsDict := make(map[string]*model.UserDetails)
-	if userDetailsList != nil {
-		for _, value := range userDetailsList {
-			userDetailsDict[value.UserName] = value
-		}
+//loginAttemptService为nil时不记录登录尝试，用户始终处于未锁定状态
+func NewInMemoryUserDetailsService(userDetailsList []*model.UserDetails, loginAttemptService LoginAttemptService) *InMemoryUserDetailsService {
+	return &InMemoryUserDetailsService{
+		credentials:         NewCredentialsHolder(&Credentials{users: newUserDetailsDict(userDetailsList)}),
+		loginAttemptService: loginAttemptService,
 	}
+}
+
+//使用共享的快照，用户随签名秘钥和客户端一起替换
+func NewInMemoryUserDetailsServiceWithCredentials(credentials *CredentialsHolder, loginAttemptService LoginAttemptService) *InMemoryUserDetailsService {
 	return &InMemoryUserDetailsService{
-		userDetailsDict: userDetailsDict,
+		credentials:         credentials,
+		loginAttemptService: loginAttemptService,
+	}
+}
+
+//只使用新的用户列表替换全部用户
+//二次验证的绑定保存在MFAEnrollmentStore中，不受重新加载影响
+func (us *InMemoryUserDetailsService) Reload(userDetailsList []*model.UserDetails) {
+	userDetailsDict := newUserDetailsDict(userDetailsList)
+	us.credentials.update(func(credentials *Credentials) {
+		credentials.users = userDetailsDict
+	})
+}
+
+//当前的用户快照
+func (us *InMemoryUserDetailsService) users() map[string]*model.UserDetails {
+	return us.credentials.Load().users
+}
+
+func newUserDetailsDict(userDetailsList []*model.UserDetails) map[string]*model.UserDetails {
+	userDetailsDict := make(map[string]*model.UserDetails, len(userDetailsList))
+	for _, value := range userDetailsList {
+		userDetailsDict[value.UserName] = value
 	}
+	return userDetailsDict
+}
+
+//没有配置任何用户时无法签发令牌
+func (us *InMemoryUserDetailsService) HealthCheck(ctx context.Context) error {
+	if len(us.users()) == 0 {
+		return ErrNoUsers
+	}
+	return nil
 }
 
 //通过用户名获取用户信息
 func (us *InMemoryUserDetailsService) GetUserDetailByUserName(ctx context.Context, username, password string) (*model.UserDetails, error) {
 	//根据username获取用户信息
-	if userDetails, ok := us.userDetailsDict[username]; ok {
+	if userDetails, ok := us.users()[username]; ok {
 		//获取到用户信息
 		if userDetails.Password == password {
 			return userDetails, nil
@@ -48,3 +87,34 @@ func (us *InMemoryUserDetailsService) GetUserDetailByUserName(ctx context.Contex
 		return nil, ErrUserNotExist
 	}
 }
+
+//获取用户的锁定状态
+func (us *InMemoryUserDetailsService) GetLockState(ctx context.Context, username string) (*model.LockState, error) {
+	if us.loginAttemptService == nil {
+		return &model.LockState{}, nil
+	}
+	return us.loginAttemptService.GetLockState(ctx, username), nil
+}
+
+//验证用户凭证，用户不存在和密码错误统一返回ErrBadCredentials并记录一次失败
+//验证成功后不清空失败记录，由调用方在完成全部认证步骤后调用LoginSucceeded
+func authenticateUser(ctx context.Context, userDetailsService UserDetailsService, loginAttemptService LoginAttemptService, username, password, ip string) (*model.UserDetails, error) {
+	//检查用户名和来源IP是否处于锁定中
+	if loginAttemptService != nil {
+		if err := loginAttemptService.Check(ctx, username, ip); err != nil {
+			return nil, err
+		}
+	}
+	userDetails, err := userDetailsService.GetUserDetailByUserName(ctx, username, password)
+	if err != nil {
+		if err == ErrUserNotExist || err == ErrPassword {
+			if loginAttemptService != nil {
+				loginAttemptService.LoginFailed(ctx, username, ip)
+			}
+			//不区分用户不存在和密码错误
+			return nil, ErrBadCredentials
+		}
+		return nil, err
+	}
+	return userDetails, nil
+}
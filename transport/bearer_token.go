@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+/**
+RFC 6750 访问令牌的携带方式
+Authorization请求头始终支持，表单和查询参数需要显式开启
+*/
+type BearerTokenMode int
+
+const (
+	//Authorization: Bearer <token>
+	BearerTokenHeader BearerTokenMode = 1 << iota
+	//application/x-www-form-urlencoded 请求体中的access_token参数
+	BearerTokenForm
+	//URL查询参数access_token
+	BearerTokenQuery
+)
+
+const accessTokenParam = "access_token"
+
+var (
+	ErrMissingBearerToken   = errors.New("missing bearer token")
+	ErrMalformedBearerToken = errors.New("malformed bearer token")
+	ErrMultipleBearerTokens = errors.New("bearer token must be sent using exactly one method")
+)
+
+//b64token语法，RFC 6750 2.1节
+var b64token = regexp.MustCompile(`^[A-Za-z0-9\-._~+/]+=*$`)
+
+//从请求中解析访问令牌，同时使用多种方式携带令牌时返回ErrMultipleBearerTokens
+func extractBearerToken(r *http.Request, mode BearerTokenMode) (string, error) {
+	var tokens []string
+
+	//多个Authorization请求头无法确定使用哪个令牌
+	authorizations := r.Header.Values("Authorization")
+	if len(authorizations) > 1 {
+		return "", ErrMultipleBearerTokens
+	}
+	if len(authorizations) == 1 && authorizations[0] != "" {
+		token, err := parseBearerAuthorization(authorizations[0])
+		if err != nil {
+			return "", err
+		}
+		tokens = append(tokens, token)
+	}
+
+	if mode&BearerTokenForm != 0 && isFormBody(r) {
+		if err := r.ParseForm(); err != nil {
+			return "", ErrMalformedBearerToken
+		}
+		if values, ok := r.PostForm[accessTokenParam]; ok {
+			tokens = append(tokens, values...)
+		}
+	}
+
+	if mode&BearerTokenQuery != 0 {
+		if values, ok := r.URL.Query()[accessTokenParam]; ok {
+			tokens = append(tokens, values...)
+		}
+	}
+
+	switch len(tokens) {
+	case 0:
+		return "", ErrMissingBearerToken
+	case 1:
+		if !b64token.MatchString(tokens[0]) {
+			return "", ErrMalformedBearerToken
+		}
+		return tokens[0], nil
+	default:
+		return "", ErrMultipleBearerTokens
+	}
+}
+
+//解析Authorization请求头，认证方案不区分大小写
+func parseBearerAuthorization(authorization string) (string, error) {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", ErrMissingBearerToken
+	}
+	token := strings.TrimSpace(parts[1])
+	if !b64token.MatchString(token) {
+		return "", ErrMalformedBearerToken
+	}
+	return token, nil
+}
+
+//RFC 6750 2.2节：只有非GET请求且请求体为单段的表单编码时才允许在请求体中携带令牌
+func isFormBody(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Body == nil {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
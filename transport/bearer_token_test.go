@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExtractBearerToken(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		authorization []string
+		contentType   string
+		body          string
+		mode          BearerTokenMode
+		token         string
+		err           error
+	}{
+		{"header", http.MethodGet, "/", []string{"Bearer abc.def-123"}, "", "", BearerTokenHeader, "abc.def-123", nil},
+		{"scheme is case insensitive", http.MethodGet, "/", []string{"bEaReR abc"}, "", "", BearerTokenHeader, "abc", nil},
+		{"padding", http.MethodGet, "/", []string{"Bearer abc=="}, "", "", BearerTokenHeader, "abc==", nil},
+		{"other scheme", http.MethodGet, "/", []string{"Basic YXBwOnNlY3JldA=="}, "", "", BearerTokenHeader, "", ErrMissingBearerToken},
+		{"scheme without token", http.MethodGet, "/", []string{"Bearer"}, "", "", BearerTokenHeader, "", ErrMissingBearerToken},
+		{"empty token", http.MethodGet, "/", []string{"Bearer "}, "", "", BearerTokenHeader, "", ErrMalformedBearerToken},
+		{"invalid characters", http.MethodGet, "/", []string{"Bearer a,b"}, "", "", BearerTokenHeader, "", ErrMalformedBearerToken},
+		{"multiple headers", http.MethodGet, "/", []string{"Bearer abc", "Bearer def"}, "", "", BearerTokenHeader, "", ErrMultipleBearerTokens},
+		{"no token", http.MethodGet, "/", nil, "", "", BearerTokenHeader | BearerTokenForm | BearerTokenQuery, "", ErrMissingBearerToken},
+
+		{"form", http.MethodPost, "/", nil, form, "access_token=abc", BearerTokenHeader | BearerTokenForm, "abc", nil},
+		{"form disabled", http.MethodPost, "/", nil, form, "access_token=abc", BearerTokenHeader, "", ErrMissingBearerToken},
+		//RFC 6750 2节：同一请求只能使用一种方式携带令牌
+		{"form with header", http.MethodPost, "/", []string{"Bearer abc"}, form, "access_token=abc", BearerTokenHeader | BearerTokenForm, "", ErrMultipleBearerTokens},
+		{"form with charset", http.MethodPost, "/", nil, form + "; charset=utf-8", "access_token=abc", BearerTokenHeader | BearerTokenForm, "abc", nil},
+		{"form on GET", http.MethodGet, "/", nil, form, "access_token=abc", BearerTokenHeader | BearerTokenForm, "", ErrMissingBearerToken},
+		{"json body", http.MethodPost, "/", nil, "application/json", `{"access_token":"abc"}`, BearerTokenHeader | BearerTokenForm, "", ErrMissingBearerToken},
+		{"repeated form parameter", http.MethodPost, "/", nil, form, "access_token=abc&access_token=def", BearerTokenHeader | BearerTokenForm, "", ErrMultipleBearerTokens},
+		{"empty form parameter", http.MethodPost, "/", nil, form, "access_token=", BearerTokenHeader | BearerTokenForm, "", ErrMalformedBearerToken},
+
+		{"query", http.MethodGet, "/?access_token=abc", nil, "", "", BearerTokenHeader | BearerTokenQuery, "abc", nil},
+		{"query disabled", http.MethodGet, "/?access_token=abc", nil, "", "", BearerTokenHeader, "", ErrMissingBearerToken},
+		{"query with header", http.MethodGet, "/?access_token=abc", []string{"Bearer abc"}, "", "", BearerTokenHeader | BearerTokenQuery, "", ErrMultipleBearerTokens},
+		{"query with form", http.MethodPost, "/?access_token=abc", nil, form, "access_token=def", BearerTokenHeader | BearerTokenForm | BearerTokenQuery, "", ErrMultipleBearerTokens},
+		{"empty query parameter", http.MethodGet, "/?access_token=", nil, "", "", BearerTokenHeader | BearerTokenQuery, "", ErrMalformedBearerToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			for _, value := range tt.authorization {
+				r.Header.Add("Authorization", value)
+			}
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			token, err := extractBearerToken(r, tt.mode)
+			if token != tt.token || err != tt.err {
+				t.Fatalf("extractBearerToken = %q, %v, want %q, %v", token, err, tt.token, tt.err)
+			}
+		})
+	}
+}
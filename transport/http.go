@@ -2,28 +2,37 @@ package transport
 
 import (
 	"context"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
+	"security/common/audit"
+	"security/common/tracing"
 	endpoint2 "security/endpoint"
+	"security/model"
 	"security/service"
 )
 
 var (
-	ErrorGrantTypeRequest = errors.New("invalid gran type request")
-	ErrorTokenRequest     = errors.New("invalid request token")
+	ErrorGrantTypeRequest             = errors.New("invalid gran type request")
+	ErrorTokenRequest                 = errors.New("invalid request token")
+	ErrorContentTypeRequest           = errors.New("request body must be application/x-www-form-urlencoded")
+	ErrorMultipleClientAuthentication = errors.New("client must authenticate using exactly one method")
+	ErrorCertificateBinding           = errors.New("access token is bound to a different client certificate")
 )
 
 /**
 在transport层中，把MakeTokenEndpoint和MakeCheckTokenEndpoint暴露到/oauth/token 和/oauth/check_token端点中，
 客户端就可以通过http的方式请求/oauth/token 和/oauth/check_token，获取访问令牌和验证访问令牌的有效性
 */
-func MakeHttpHandler(ctx context.Context, endpoints endpoint2.OAuth2Endpoints, tokenService service.TokenService, detailsService service.ClientDetailsService, logger log.Logger) http.Handler {
+func MakeHttpHandler(ctx context.Context, endpoints endpoint2.OAuth2Endpoints, tokenService service.TokenService, detailsService service.ClientDetailsService, routePolicyService service.RoutePolicyService, endpointMetrics *endpoint2.EndpointMetrics, bearerTokenMode BearerTokenMode, logger log.Logger) http.Handler {
 	r := mux.NewRouter()
 	/*options := []kithttp.ServerOption{
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
@@ -32,42 +41,88 @@ func MakeHttpHandler(ctx context.Context, endpoints endpoint2.OAuth2Endpoints, t
 	//指标
 	r.Path("/metrics").Handler(promhttp.Handler())
 
-	clientAuthorizationOptions := []kithttp.ServerOption{
+	//链路追踪的选项放在最前，使后续的请求处理都记录在路由的span中
+	tracingOptions := tracing.ServerOptions()
+	//请求来源写入context，供审计事件使用
+	requestOptions := func() []kithttp.ServerOption {
+		return append(tracing.ServerOptions(), kithttp.ServerBefore(makeAuditSourceContext()))
+	}
+
+	//健康检查，/health与/health/ready相同，供注册中心的HTTP检查使用
+	r.Methods("GET").Path("/health/live").Handler(kithttp.NewServer(
+		endpoints.HealthCheckEndpoint,
+		decodeLivenessRequest,
+		encodeHealthCheckResponse,
+		tracingOptions...,
+	))
+	readinessHandler := kithttp.NewServer(
+		endpoints.HealthCheckEndpoint,
+		decodeReadinessRequest,
+		encodeHealthCheckResponse,
+		tracingOptions...,
+	)
+	r.Methods("GET").Path("/health/ready").Handler(readinessHandler)
+	r.Methods("GET").Path("/health").Handler(readinessHandler)
+
+	//统计各端点的请求次数和延迟，包含鉴权失败的请求
+	metricsMiddleware := func(name string) endpoint.Middleware {
+		return tracing.Middleware("metrics", endpoint2.MakeMetricsMiddleware(name, endpointMetrics))
+	}
+
+	clientAuthorizationOptions := append(requestOptions(),
 		//为了确保endpoint能投获取到已验证的客户端信息，在请求前执行
 		kithttp.ServerBefore(makeClientAuthorizationContext(detailsService, logger)),
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-		kithttp.ServerErrorEncoder(encodeError),
-	}
+		kithttp.ServerErrorEncoder(encodeTokenEndpointError),
+	)
 
 	r.Methods("POST").Path("/oauth/token").Handler(kithttp.NewServer(
-		endpoints.TokenEndpoint,
+		metricsMiddleware("token")(endpoints.TokenEndpoint),
 		decodeTokenRequest,
-		encodeJsonReponse,
+		encodeTokenResponse,
 		clientAuthorizationOptions...,
 	))
 
 	r.Methods("POST").Path("/oauth/check_token").Handler(kithttp.NewServer(
-		endpoints.CheckTokenEndpoint,
+		metricsMiddleware("check_token")(endpoints.CheckTokenEndpoint),
 		decodeCheckTokenRequest,
 		encodeJsonReponse,
 		clientAuthorizationOptions...,
 	))
 
-	oauth2AuthorizationOptions := []kithttp.ServerOption{
-		kithttp.ServerBefore(makeOAuth2AuthroizationContext(tokenService, logger)),
+	//RFC 7662 令牌内省
+	r.Methods("POST").Path("/oauth/introspect").Handler(kithttp.NewServer(
+		metricsMiddleware("introspect")(endpoints.IntrospectEndpoint),
+		decodeIntrospectRequest,
+		encodeTokenResponse,
+		clientAuthorizationOptions...,
+	))
+
+	r.Methods("POST").Path("/oauth/mfa/enroll").Handler(kithttp.NewServer(
+		metricsMiddleware("mfa_enroll")(endpoints.MFAEnrollEndpoint),
+		decodeMFAEnrollRequest,
+		encodeJsonReponse,
+		clientAuthorizationOptions...,
+	))
+
+	oauth2AuthorizationOptions := append(requestOptions(),
+		kithttp.ServerBefore(makeOAuth2AuthroizationContext(tokenService, bearerTokenMode, logger), makeRequestRouteContext()),
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
-		kithttp.ServerErrorEncoder(encodeError),
-	}
+		kithttp.ServerErrorEncoder(encodeResourceError),
+	)
+
+	//受保护资源统一使用路由策略鉴权
+	routePolicyMiddleware := tracing.Middleware("route_policy", endpoint2.MakeRoutePolicyMiddleware(routePolicyService, logger))
 
 	r.Methods("GET").Path("/simple").Handler(kithttp.NewServer(
-		endpoints.SimpleEndpoint,
+		metricsMiddleware("simple")(routePolicyMiddleware(endpoints.SimpleEndpoint)),
 		decodeSimpleRequest,
 		encodeJsonReponse,
 		oauth2AuthorizationOptions...,
 	))
 
 	r.Methods("GET").Path("/admin").Handler(kithttp.NewServer(
-		endpoints.AdminEndpoint,
+		metricsMiddleware("admin")(routePolicyMiddleware(endpoints.AdminEndpoint)),
 		decodeAdminRequest,
 		encodeJsonReponse,
 		oauth2AuthorizationOptions...,
@@ -81,33 +136,57 @@ func MakeHttpHandler(ctx context.Context, endpoints endpoint2.OAuth2Endpoints, t
 
 // /oauth/check_token 端点提供给客户端和资源服务器验证访问令牌的有效性；如果访问令牌有效，则返回访问令牌绑定的用户信息和客户端信息
 
-//在请求访问令牌之前，需要验证Authorization请求头中携带的客户端信息
-func makeClientAuthorizationContext(service service.ClientDetailsService, logger log.Logger) kithttp.RequestFunc {
+//在请求访问令牌之前，需要验证客户端信息
+//客户端可以使用Authorization请求头的Basic认证，也可以在表单请求体中携带client_id和client_secret，但不能同时使用；
+//RFC 8705的客户端在表单中只携带client_id，使用TLS握手中提供的证书认证（tls_client_auth、self_signed_tls_client_auth）
+func makeClientAuthorizationContext(clientDetailsService service.ClientDetailsService, logger log.Logger) kithttp.RequestFunc {
 	return func(ctx context.Context, request *http.Request) context.Context {
-		if clientId, clientSecret, ok := request.BasicAuth(); ok {
-			clientDetail, err := service.GetClientDetailsByClientId(ctx, clientId, clientSecret)
-			if err == nil {
-				return context.WithValue(ctx, endpoint2.OAuth2ClientDetailsKey, clientDetail)
-			}
+		clientId, clientSecret, basic := request.BasicAuth()
+		var body, secret bool
+		if isFormBody(request) && request.ParseForm() == nil {
+			_, body = request.PostForm["client_id"]
+			_, secret = request.PostForm["client_secret"]
 		}
-		return nil
-	}
-}
-
-func encodeError(_ context.Context, err error, w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json;charset=utf-8")
-	switch err {
-	default:
-		w.WriteHeader(http.StatusInternalServerError)
+		var certificates []*x509.Certificate
+		if request.TLS != nil {
+			certificates = request.TLS.PeerCertificates
+		}
+		var (
+			clientDetail *model.ClientDetails
+			err          error
+		)
+		switch {
+		case basic && body:
+			return endpoint2.NewErrorContext(ctx, ErrorMultipleClientAuthentication)
+		case body && !secret && len(certificates) > 0:
+			clientDetail, err = clientDetailsService.GetClientDetailsByCertificate(ctx, request.PostFormValue("client_id"), certificates)
+		case body:
+			clientDetail, err = clientDetailsService.GetClientDetailsByClientId(ctx, request.PostFormValue("client_id"), request.PostFormValue("client_secret"))
+		case basic:
+			clientDetail, err = clientDetailsService.GetClientDetailsByClientId(ctx, clientId, clientSecret)
+		default:
+			return ctx
+		}
+		if err != nil {
+			return endpoint2.NewErrorContext(ctx, err)
+		}
+		//签发的令牌可以绑定该证书
+		if len(certificates) > 0 {
+			ctx = service.NewClientCertificateContext(ctx, certificates[0])
+		}
+		return endpoint2.NewClientDetailsContext(ctx, clientDetail)
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"error": err.Error(),
-	})
-
 }
 
+//按RFC 6749 4.3.2节，令牌请求的参数以application/x-www-form-urlencoded格式放在请求体中
 func decodeTokenRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	grantType := r.URL.Query().Get("grant_type")
+	if !isFormBody(r) {
+		return nil, ErrorContentTypeRequest
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, ErrorContentTypeRequest
+	}
+	grantType := r.PostFormValue("grant_type")
 	if grantType == "" {
 		return nil, ErrorGrantTypeRequest
 	}
@@ -117,8 +196,39 @@ func decodeTokenRequest(ctx context.Context, r *http.Request) (interface{}, erro
 	}, nil
 }
 
+//RFC 7662 2.1节，令牌以表单参数提交，token_type_hint可选，目前只有JWT令牌，忽略该参数
+func decodeIntrospectRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if !isFormBody(r) {
+		return nil, ErrorContentTypeRequest
+	}
+	tokenValue := r.PostFormValue("token")
+	if tokenValue == "" {
+		return nil, ErrorTokenRequest
+	}
+	return &endpoint2.IntrospectRequest{
+		Token: tokenValue,
+	}, nil
+}
+
+func decodeMFAEnrollRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if !isFormBody(r) {
+		return nil, ErrorContentTypeRequest
+	}
+	username := r.PostFormValue("username")
+	password := r.PostFormValue("password")
+	if username == "" || password == "" {
+		return nil, service.ErrInvalidUsernameAndPasswordRequest
+	}
+	return &endpoint2.MFAEnrollRequest{
+		Username: username,
+		Password: password,
+		Code:     r.PostFormValue("mfa_code"),
+		IP:       service.ClientIP(r),
+	}, nil
+}
+
 func decodeCheckTokenRequest(ctx context.Context, r *http.Request) (interface{}, error) {
-	tokenValue := r.URL.Query().Get("token")
+	tokenValue := r.FormValue("token")
 	if tokenValue == "" {
 		return nil, ErrorTokenRequest
 	}
@@ -127,29 +237,91 @@ func decodeCheckTokenRequest(ctx context.Context, r *http.Request) (interface{},
 	}, nil
 }
 
+//令牌响应不允许被缓存，RFC 6749 5.1节
+func encodeTokenResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	return encodeJsonReponse(ctx, w, response)
+}
+
 func encodeJsonReponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	w.Header().Set("Content-Type", "application/json;charset=utf-8")
 	return json.NewEncoder(w).Encode(response)
 }
 
 //令牌认证
-//从Authorization请求解析出访问令牌，然后使用TokenService根据访问令牌获取到用户信息和客户端信息
-func makeOAuth2AuthroizationContext(tokenService service.TokenService, logger log.Logger) kithttp.RequestFunc {
+//按RFC 6750从请求中解析出访问令牌，然后使用TokenService根据访问令牌获取到用户信息和客户端信息
+func makeOAuth2AuthroizationContext(tokenService service.TokenService, bearerTokenMode BearerTokenMode, logger log.Logger) kithttp.RequestFunc {
 	return func(ctx context.Context, r *http.Request) context.Context {
 		//获取令牌
-		accessToken := r.Header.Get("Authrization")
-		var err error
-		if accessToken != "" {
-			//获取令牌对应的用户信息和客户端信息
-			details, err := tokenService.GetOAuth2DetailsByAccessToken(accessToken)
-			if err == nil {
-				return context.WithValue(ctx, endpoint2.OAuth2ClientDetailsKey, details)
+		accessToken, err := extractBearerToken(r, bearerTokenMode)
+		if err != nil {
+			return endpoint2.NewErrorContext(ctx, err)
+		}
+		//获取令牌对应的用户信息和客户端信息
+		details, err := tokenService.GetOAuth2DetailsByAccessToken(ctx, accessToken)
+		if err != nil {
+			return endpoint2.NewErrorContext(ctx, fmt.Errorf("%w: %v", endpoint2.ErrInvalidToken, err))
+		}
+		//绑定证书的令牌只能通过同一证书建立的连接使用，RFC 8705 3节
+		if details.CertificateThumbprint != "" && !matchCertificate(r, details.CertificateThumbprint) {
+			return endpoint2.NewErrorContext(ctx, fmt.Errorf("%w: %v", endpoint2.ErrInvalidToken, ErrorCertificateBinding))
+		}
+		return endpoint2.NewOAuth2DetailsContext(ctx, details)
+	}
+}
+
+//请求的客户端证书指纹是否与令牌绑定的指纹一致
+func matchCertificate(r *http.Request, thumbprint string) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return false
+	}
+	return model.CertificateThumbprint(r.TLS.PeerCertificates[0]) == thumbprint
+}
+
+//将请求的来源IP和User-Agent写入context
+func makeAuditSourceContext() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		return audit.NewSourceContext(ctx, &audit.Source{
+			IP:        service.ClientIP(r),
+			UserAgent: r.UserAgent(),
+		})
+	}
+}
+
+//将请求匹配到的路由模板和方法写入context，供路由策略鉴权使用
+func makeRequestRouteContext() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		route := &endpoint2.RequestRoute{
+			Path:   r.URL.Path,
+			Method: r.Method,
+		}
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route.Path = template
 			}
-		} else {
-			err = ErrorGrantTypeRequest
 		}
-		return context.WithValue(ctx, endpoint2.OAuth2ErrorKey, err)
+		return endpoint2.NewRequestRouteContext(ctx, route)
+	}
+}
+
+func decodeLivenessRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return &endpoint2.HealthRequest{}, nil
+}
+
+func decodeReadinessRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return &endpoint2.HealthRequest{Ready: true}, nil
+}
+
+//检查失败时返回503，使HTTP检查失败
+func encodeHealthCheckResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	w.Header().Set("Cache-Control", "no-store")
+	if report, ok := response.(*model.HealthReport); ok && !report.IsUp() {
+		w.Header().Set("Content-Type", "application/json;charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return json.NewEncoder(w).Encode(response)
 	}
+	return encodeJsonReponse(ctx, w, response)
 }
 
 func decodeSimpleRequest(ctx context.Context, r *http.Request) (interface{}, error) {
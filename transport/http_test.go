@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	endpoint2 "security/endpoint"
+	"security/model"
+	"security/service"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSigningKey = "transport-test-signing-key-0123456789"
+
+var testClient = &model.ClientDetails{
+	ClientId:                    "app",
+	ClientSecret:                "secret",
+	AccessTokenValiditySeconds:  60,
+	RefreshTokenValiditySeconds: 600,
+	AuthorizedGrantTypes:        []string{"password", "refresh_token"},
+	Scope:                       []string{"read", "write"},
+}
+
+var testUser = &model.UserDetails{UserName: "alice", Password: "password"}
+
+//只注册密码和刷新令牌授权的令牌端点
+func newTestTokenHandler(tokenService service.TokenService) http.Handler {
+	clientDetailsService := service.NewInMemoryClientDetailService([]*model.ClientDetails{testClient}, nil)
+	userDetailsService := service.NewInMemoryUserDetailsService([]*model.UserDetails{testUser}, nil)
+	granter := service.NewComposeTokenGranter(map[string]service.TokenGrant{
+		"password":      service.NewUsernamePasswordTokenGrant("password", userDetailsService, tokenService, nil, nil),
+		"refresh_token": service.NewRefreshGranter("refresh_token", nil, tokenService),
+	})
+	tokenEndpoint := endpoint2.MakeClientAuthorizationMiddleware(log.NewNopLogger())(endpoint2.MakeTokenEndpoint(granter, clientDetailsService))
+	endpointMetrics := &endpoint2.EndpointMetrics{
+		RequestCount:   generic.NewCounter("request_count"),
+		RequestLatency: generic.NewHistogram("request_latency_seconds", 10),
+	}
+	return MakeHttpHandler(context.Background(), endpoint2.OAuth2Endpoints{TokenEndpoint: tokenEndpoint}, tokenService, clientDetailsService, nil, endpointMetrics, BearerTokenHeader, log.NewNopLogger())
+}
+
+func newTestTokenService() service.TokenService {
+	enhancer := service.NewJWTTokenEnhancer(testSigningKey).(*service.JWTTokenEnhancer)
+	return service.NewTokenService(service.NewJwtTokenStore(enhancer), enhancer)
+}
+
+func postRefreshToken(handler http.Handler, refreshToken string) *httptest.ResponseRecorder {
+	form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}}
+	r := httptest.NewRequest("POST", "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(testClient.ClientId, testClient.ClientSecret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestRefreshTokenGrantErrors(t *testing.T) {
+	tokenService := newTestTokenService()
+	handler := newTestTokenHandler(tokenService)
+
+	//过期的刷新令牌
+	expiredClient := *testClient
+	expiredClient.RefreshTokenValiditySeconds = -60
+	expired, err := tokenService.CreateAccessToken(context.Background(), &model.OAuth2Details{
+		Client: &expiredClient,
+		User:   &model.UserDetails{UserName: "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//其他秘钥签名的刷新令牌
+	forged, err := service.NewJWTTokenEnhancer("another-signing-key-0123456789abcdef").Enhance(&model.OAuth2Token{ExpiresTime: expired.ExpiresTime}, &model.OAuth2Details{
+		Client: testClient,
+		User:   &model.UserDetails{UserName: "alice"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		refreshToken string
+	}{
+		{"garbage token", "not-a-jwt"},
+		{"expired token", expired.RefreshToken.TokenValue},
+		{"bad signature", forged.TokenValue},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := postRefreshToken(handler, test.refreshToken)
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			//RFC 6749 5.2节，无效的刷新令牌返回400 invalid_grant
+			if w.Code != http.StatusBadRequest || body["error"] != OAuth2InvalidGrant {
+				t.Fatalf("got %d %v, want %d %s", w.Code, body, http.StatusBadRequest, OAuth2InvalidGrant)
+			}
+		})
+	}
+}
+
+func TestTokenRequestParsing(t *testing.T) {
+	handler := newTestTokenHandler(newTestTokenService())
+	password := url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"password"}}
+	withClient := url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"password"}, "client_id": {"app"}, "client_secret": {"secret"}}
+	const form = "application/x-www-form-urlencoded"
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		basicAuth   bool
+		status      int
+		code        string
+	}{
+		{"form body with basic auth", "/oauth/token", form, password.Encode(), true, http.StatusOK, ""},
+		{"client credentials in form body", "/oauth/token", form, withClient.Encode(), false, http.StatusOK, ""},
+		{"basic auth and form body", "/oauth/token", form, withClient.Encode(), true, http.StatusBadRequest, OAuth2InvalidRequest},
+		{"no client authentication", "/oauth/token", form, password.Encode(), false, http.StatusUnauthorized, OAuth2InvalidClient},
+		//参数必须在请求体中
+		{"grant type in query", "/oauth/token?grant_type=password", form, "username=alice&password=password", true, http.StatusBadRequest, OAuth2InvalidRequest},
+		{"json body", "/oauth/token", "application/json", `{"grant_type":"password"}`, true, http.StatusBadRequest, OAuth2InvalidRequest},
+		{"unknown grant type", "/oauth/token", form, "grant_type=implicit", true, http.StatusBadRequest, OAuth2UnauthorizedClient},
+		{"wrong password", "/oauth/token", form, "grant_type=password&username=alice&password=wrong", true, http.StatusBadRequest, OAuth2InvalidGrant},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			if test.basicAuth {
+				r.SetBasicAuth(testClient.ClientId, testClient.ClientSecret)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			var body map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != test.status || test.code != "" && body["error"] != test.code {
+				t.Fatalf("got %d %v, want %d %s", w.Code, body, test.status, test.code)
+			}
+		})
+	}
+}
+
+//RFC 6749 5.1节的令牌响应
+func TestTokenResponse(t *testing.T) {
+	handler := newTestTokenHandler(newTestTokenService())
+	form := url.Values{"grant_type": {"password"}, "username": {"alice"}, "password": {"password"}}
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(testClient.ClientId, testClient.ClientSecret)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || w.Header().Get("Pragma") != "no-cache" {
+		t.Fatalf("token response is cacheable: %v", w.Header())
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.AccessToken == "" || body.RefreshToken == "" || body.TokenType != "bearer" || body.Scope != "read write" {
+		t.Fatalf("token response = %+v", body)
+	}
+	if body.ExpiresIn <= 0 || body.ExpiresIn > int64(testClient.AccessTokenValiditySeconds) {
+		t.Fatalf("expires_in = %d, want (0, %d]", body.ExpiresIn, testClient.AccessTokenValiditySeconds)
+	}
+
+	//响应中的刷新令牌可以换取新的访问令牌
+	if w := postRefreshToken(handler, body.RefreshToken); w.Code != http.StatusOK {
+		t.Fatalf("refresh status = %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	healthService := service.NewHealthService(time.Second)
+	healthService.AddLivenessCheck("process", func(ctx context.Context) error { return nil })
+	healthService.AddReadinessCheck("user_details", func(ctx context.Context) error { return errors.New("unavailable") })
+	endpointMetrics := &endpoint2.EndpointMetrics{
+		RequestCount:   generic.NewCounter("request_count"),
+		RequestLatency: generic.NewHistogram("request_latency_seconds", 10),
+	}
+	handler := MakeHttpHandler(context.Background(), endpoint2.OAuth2Endpoints{
+		HealthCheckEndpoint: endpoint2.MakeHealthCheckEndpoint(healthService),
+	}, newTestTokenService(), nil, nil, endpointMetrics, BearerTokenHeader, log.NewNopLogger())
+
+	tests := []struct {
+		path       string
+		status     int
+		report     string
+		components int
+	}{
+		{"/health/live", http.StatusOK, model.HealthUp, 1},
+		//就绪检查失败时返回503，使注册中心的HTTP检查失败
+		{"/health/ready", http.StatusServiceUnavailable, model.HealthDown, 2},
+		{"/health", http.StatusServiceUnavailable, model.HealthDown, 2},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Fatalf("GET %s status = %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("GET %s response is cacheable: %v", tt.path, w.Header())
+		}
+		report := &model.HealthReport{}
+		if err := json.NewDecoder(w.Body).Decode(report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Status != tt.report || len(report.Components) != tt.components {
+			t.Fatalf("GET %s report = %+v", tt.path, report)
+		}
+	}
+}
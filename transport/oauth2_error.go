@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	endpoint2 "security/endpoint"
+	"security/service"
+)
+
+//RFC 6749 和 RFC 6750 定义的错误码
+const (
+	OAuth2InvalidRequest       = "invalid_request"
+	OAuth2InvalidClient        = "invalid_client"
+	OAuth2InvalidGrant         = "invalid_grant"
+	OAuth2UnauthorizedClient   = "unauthorized_client"
+	OAuth2UnsupportedGrantType = "unsupported_grant_type"
+	OAuth2InvalidToken         = "invalid_token"
+	OAuth2InsufficientScope    = "insufficient_scope"
+	OAuth2ServerError          = "server_error"
+)
+
+const oauth2Realm = "oauth2"
+
+//错误对应的HTTP状态码、OAuth2错误码和固定的错误描述，不返回内部错误的原文
+type oauth2Error struct {
+	status      int
+	code        string
+	description string
+}
+
+/**
+令牌端点（/oauth/token、/oauth/check_token等）的错误映射，RFC 6749 5.2节
+*/
+var tokenEndpointErrors = []struct {
+	err error
+	oauth2Error
+}{
+	{endpoint2.ErrInvalidClientRequest, oauth2Error{http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"}},
+	{service.ErrClientExits, oauth2Error{http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"}},
+	{service.ErrClientSecret, oauth2Error{http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"}},
+	{service.ErrClientAuthMethod, oauth2Error{http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"}},
+	{service.ErrClientCertificate, oauth2Error{http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"}},
+	{service.ErrClientCertificateRequired, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "client certificate is required"}},
+	{endpoint2.ErrUnauthorizedClient, oauth2Error{http.StatusBadRequest, OAuth2UnauthorizedClient, "client is not authorized to use this grant type"}},
+	{service.ErrNotSupportGrantType, oauth2Error{http.StatusBadRequest, OAuth2UnsupportedGrantType, "grant type is not supported"}},
+	{ErrorGrantTypeRequest, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "grant_type is required"}},
+	{ErrorContentTypeRequest, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "request body must be application/x-www-form-urlencoded"}},
+	{ErrorMultipleClientAuthentication, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "client must authenticate using exactly one method"}},
+	{ErrorTokenRequest, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "token is required"}},
+	{service.ErrInvalidUsernameAndPasswordRequest, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "username and password are required"}},
+	{service.ErrInvalidTokenRequest, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "invalid token"}},
+	{service.ErrMFACodeRequired, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "mfa code is required"}},
+	{service.ErrInvalidMFACode, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "invalid mfa code"}},
+	{service.ErrMFAEnrollmentRequired, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "mfa enrollment is required"}},
+	{service.ErrBadCredentials, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "bad credentials"}},
+	{service.ErrUserNotExist, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "bad credentials"}},
+	{service.ErrPassword, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "bad credentials"}},
+	{service.ErrAccountLocked, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "account is temporarily locked"}},
+	{service.ErrExpiredToken, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "token is expired"}},
+	{service.ErrInvalidRefreshToken, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "invalid refresh token"}},
+	{service.ErrRefreshTokenClient, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "refresh token was issued to another client"}},
+	{service.ErrRefreshTokenCertificate, oauth2Error{http.StatusBadRequest, OAuth2InvalidGrant, "refresh token is bound to a different client certificate"}},
+	//check_token校验失败
+	{endpoint2.ErrInvalidToken, oauth2Error{http.StatusBadRequest, OAuth2InvalidToken, "invalid access token"}},
+}
+
+/**
+受保护资源的错误映射，RFC 6750 3.1节
+*/
+var resourceErrors = []struct {
+	err error
+	oauth2Error
+}{
+	//请求中没有携带令牌，只返回认证质询，不返回错误码
+	{ErrMissingBearerToken, oauth2Error{http.StatusUnauthorized, "", ""}},
+	{ErrMalformedBearerToken, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "malformed bearer token"}},
+	{ErrMultipleBearerTokens, oauth2Error{http.StatusBadRequest, OAuth2InvalidRequest, "bearer token must be sent using exactly one method"}},
+	{endpoint2.ErrInvalidToken, oauth2Error{http.StatusUnauthorized, OAuth2InvalidToken, "invalid access token"}},
+	{endpoint2.ErrInvalidUserRequest, oauth2Error{http.StatusUnauthorized, OAuth2InvalidToken, "invalid access token"}},
+	{endpoint2.ErrInvalidClientRequest, oauth2Error{http.StatusUnauthorized, OAuth2InvalidToken, "invalid access token"}},
+	{service.ErrExpiredToken, oauth2Error{http.StatusUnauthorized, OAuth2InvalidToken, "access token is expired"}},
+	{endpoint2.ErrNotPermit, oauth2Error{http.StatusForbidden, OAuth2InsufficientScope, "insufficient permission for this resource"}},
+}
+
+func lookupOAuth2Error(err error, table []struct {
+	err error
+	oauth2Error
+}) oauth2Error {
+	for _, value := range table {
+		if errors.Is(err, value.err) {
+			return value.oauth2Error
+		}
+	}
+	return oauth2Error{http.StatusInternalServerError, OAuth2ServerError, "internal server error"}
+}
+
+//令牌端点的错误编码，客户端认证失败时返回Basic认证质询
+func encodeTokenEndpointError(ctx context.Context, err error, w http.ResponseWriter) {
+	oauth2Err := lookupOAuth2Error(err, tokenEndpointErrors)
+	if oauth2Err.status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+oauth2Realm+`"`)
+	}
+	writeOAuth2Error(w, oauth2Err)
+}
+
+//受保护资源的错误编码，返回Bearer认证质询
+func encodeResourceError(ctx context.Context, err error, w http.ResponseWriter) {
+	oauth2Err := lookupOAuth2Error(err, resourceErrors)
+	if oauth2Err.status != http.StatusInternalServerError {
+		challenge := `Bearer realm="` + oauth2Realm + `"`
+		if oauth2Err.code != "" {
+			challenge += `, error="` + oauth2Err.code + `", error_description="` + oauth2Err.description + `"`
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+	writeOAuth2Error(w, oauth2Err)
+}
+
+func writeOAuth2Error(w http.ResponseWriter, oauth2Err oauth2Error) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(oauth2Err.status)
+	body := map[string]interface{}{}
+	if oauth2Err.code != "" {
+		body["error"] = oauth2Err.code
+		body["error_description"] = oauth2Err.description
+	}
+	json.NewEncoder(w).Encode(body)
+}
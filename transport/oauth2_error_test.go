@@ -0,0 +1,47 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	endpoint2 "security/endpoint"
+	"security/service"
+	"strings"
+	"testing"
+)
+
+func TestOAuth2ErrorDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		encode      func(ctx context.Context, err error, w http.ResponseWriter)
+		err         error
+		status      int
+		code        string
+		description string
+	}{
+		{"unknown error", encodeTokenEndpointError, errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, OAuth2ServerError, "internal server error"},
+		{"wrapped credentials error", encodeTokenEndpointError, fmt.Errorf("%w: user alice", service.ErrUserNotExist), http.StatusBadRequest, OAuth2InvalidGrant, "bad credentials"},
+		{"client secret", encodeTokenEndpointError, service.ErrClientSecret, http.StatusUnauthorized, OAuth2InvalidClient, "client authentication failed"},
+		{"resource token error", encodeResourceError, fmt.Errorf("%w: %v", endpoint2.ErrInvalidToken, ErrorCertificateBinding), http.StatusUnauthorized, OAuth2InvalidToken, "invalid access token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.encode(context.Background(), test.err, w)
+			var body map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != test.status || body["error"] != test.code || body["error_description"] != test.description {
+				t.Fatalf("got %d %v, want %d %s %q", w.Code, body, test.status, test.code, test.description)
+			}
+			//错误原文不出现在响应中
+			if challenge := w.Header().Get("WWW-Authenticate"); strings.Contains(challenge, test.err.Error()) {
+				t.Fatalf("WWW-Authenticate %q contains the error text", challenge)
+			}
+		})
+	}
+}